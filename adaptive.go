@@ -0,0 +1,173 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// adaptiveTTL returns the lease lifetime of session under MaxTTL, as last
+// worked out by touchAdaptive, MinTTL for a session never touched yet.
+func (s *EtcdStore) adaptiveTTL(session *sessions.Session) time.Duration {
+	if ttl, ok := session.Values[ttlKey].(int64); ok {
+		return s.clampTTL(time.Duration(ttl) * time.Second)
+	}
+	return s.clampTTL(0)
+}
+
+// clampTTL bounds ttl to MinTTL (MaxTTL/16 if zero) and MaxTTL.
+func (s *EtcdStore) clampTTL(ttl time.Duration) time.Duration {
+	min := s.MinTTL
+	if min <= 0 {
+		min = s.MaxTTL / 16
+	}
+	switch {
+	case ttl < min:
+		return min
+	case ttl > s.MaxTTL:
+		return s.MaxTTL
+	}
+	return ttl
+}
+
+// nextTTL is the heuristic of MaxTTL: the lifetime of session doubles when
+// it is accessed at now within the first half of its current one, and
+// halves when accessed later.
+func (s *EtcdStore) nextTTL(session *sessions.Session, now time.Time) time.Duration {
+	ttl := s.adaptiveTTL(session)
+	last, ok := session.Values[lastAccessKey].(int64)
+	if !ok {
+		return ttl
+	}
+	if now.Sub(time.Unix(0, last)) < ttl/2 {
+		return s.clampTTL(ttl * 2)
+	}
+	return s.clampTTL(ttl / 2)
+}
+
+// touchAdaptive is touch under MaxTTL: it records the access and, unless
+// the session's lease already lasts the lifetime nextTTL works out or was
+// granted for it and can be extended, moves the session, with its
+// auxiliary data and user index entry, to a fresh lease of that lifetime,
+// revoking the one it leaves if unused. Loaded as kv, the session is left
+// alone once written since, like in touch.
+func (s *EtcdStore) touchAdaptive(ctx context.Context, session *sessions.Session, kv *mvccpb.KeyValue) error {
+	now := s.clock()
+	ttl := s.nextTTL(session, now)
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	session.Values[ttlKey] = seconds
+	session.Values[lastAccessKey] = now.UnixNano()
+
+	encoded, err := s.encode(session.Name(), session.ID, session.Values)
+	if err != nil {
+		return err
+	}
+
+	key := s.key(session.ID)
+	s.uncache(key)
+	prev, _ := session.Values[leaseKey].(int64)
+	if kv != nil {
+		prev = kv.Lease
+	}
+	if prev != 0 {
+		kept, err := s.keepLease(ctx, session, clientv3.LeaseID(prev), seconds)
+		if err != nil {
+			return err
+		}
+		if kept {
+			_, err := s.writeTouch(ctx, key, encoded, kv)
+			return err
+		}
+	}
+
+	lease, err := s.grant(ctx, seconds)
+	if err != nil {
+		return err
+	}
+	ops := append([]clientv3.Op{clientv3.OpPut(key, encoded, clientv3.WithLease(lease.ID))},
+		append(s.auxLeaseOps(session, lease.ID), s.fieldLeaseOps(session, lease.ID)...)...)
+	if userID, _ := session.Values[indexedUserKey].(string); userID != "" {
+		index := s.userIndexKey(userID, session.ID)
+		ops = append(ops, clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(index), ">", 0)},
+			[]clientv3.Op{clientv3.OpPut(index, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease.ID))},
+			nil))
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), ">", 0)
+	if kv != nil {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+	}
+	resp, err := s.commit(s.kv.Txn(ctx).
+		If(cmp).
+		Then(ops...).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())))
+	if err == nil && !resp.Succeeded {
+		s.revoke(s.Context, lease.ID)
+		if resp.Responses[0].GetResponseRange().Count == 0 {
+			return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+		// saved meanwhile, on a lease of its own
+		session.Values[leaseKey] = prev
+		return nil
+	}
+	if err != nil {
+		s.revoke(s.Context, lease.ID)
+		return err
+	}
+	if prev != 0 {
+		s.revokeUnused(ctx, clientv3.LeaseID(prev))
+	}
+	session.Values[leaseKey] = int64(lease.ID)
+	return nil
+}
+
+// keepLease reports whether session can stay on lease for a lifetime of
+// seconds: the lease has that long left, or was granted for it and only
+// holds keys of session, so that a keep-alive extends it.
+func (s *EtcdStore) keepLease(ctx context.Context, session *sessions.Session, lease clientv3.LeaseID, seconds int64) (bool, error) {
+	resp, err := s.timeToLive(ctx, lease, clientv3.WithAttachedKeys())
+	if err != nil {
+		return false, err
+	}
+	if resp.TTL >= seconds {
+		return true, nil
+	}
+	if resp.TTL <= 0 || resp.GrantedTTL != seconds {
+		return false, nil
+	}
+	userID, _ := session.Values[indexedUserKey].(string)
+	for _, key := range resp.Keys {
+		own := string(key) == s.key(session.ID) || strings.HasPrefix(string(key), s.auxPrefix(session.ID)) ||
+			userID != "" && string(key) == s.userIndexKey(userID, session.ID)
+		if !own {
+			return false, nil
+		}
+	}
+	_, err = s.keepAliveOnce(ctx, lease)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) || errors.Is(err, ErrInMemoryUnsupported) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// revokeUnused revokes lease, the one touchAdaptive moved a session off,
+// unless keys are still attached to it: the other sessions of a shared or
+// pooled lease, or keys of another prefix.
+func (s *EtcdStore) revokeUnused(ctx context.Context, lease clientv3.LeaseID) {
+	if lease == clientv3.NoLease {
+		return
+	}
+	resp, err := s.timeToLive(ctx, lease, clientv3.WithAttachedKeys())
+	if err != nil || resp.TTL <= 0 || len(resp.Keys) > 0 {
+		return
+	}
+	s.revoke(s.Context, lease)
+}
@@ -0,0 +1,131 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_AdaptiveTTL(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.MinTTL = time.Minute
+	s.MaxTTL = 8 * time.Minute
+
+	grantedTTL := func(id string) int64 {
+		session, err := loadByID(s, id)
+		assert.Nil(t, err)
+		lease, ok := LeaseID(session)
+		assert.True(t, ok)
+		resp, err := s.Client.TimeToLive(ctx, lease)
+		assert.Nil(t, err)
+		return resp.GrantedTTL
+	}
+
+	busy := newSavedSession(t, s, nil)
+	idle := newSavedSession(t, s, nil)
+	lease, _ := LeaseID(busy)
+	resp, err := s.Client.TimeToLive(ctx, lease)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(60), resp.GrantedTTL, "starts with MinTTL")
+
+	var ttls []int64
+	for i := 0; i < 5; i++ {
+		now = now.Add(10 * time.Second)
+		ttls = append(ttls, grantedTTL(busy.ID))
+	}
+	assert.Equal(t, []int64{60, 120, 240, 480, 480}, ttls, "grows up to MaxTTL")
+
+	assert.Equal(t, int64(60), grantedTTL(idle.ID))
+	now = now.Add(50 * time.Second)
+	assert.Equal(t, int64(60), grantedTTL(idle.ID), "accessed late")
+
+	// a busy session going quiet shrinks back, on its lease while that
+	// still lasts the new lifetime, as etcd's clock didn't move
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, int64(480), grantedTTL(busy.ID))
+
+	// saving keeps the lifetime
+	session, err := loadByID(s, busy.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(480), session.Values[ttlKey], "loaded right away")
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	lease, _ = LeaseID(session)
+	resp, err = s.Client.TimeToLive(ctx, lease)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(480), resp.GrantedTTL)
+}
+
+func TestEtcdStore_AdaptiveTTLRevokes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.now = tickingClock()
+	s.MaxTTL = 8 * time.Minute
+	session := newSavedSession(t, s, nil)
+
+	before, err := s.Client.Leases(ctx)
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		_, err := loadByID(s, session.ID)
+		assert.Nil(t, err)
+	}
+	after, err := s.Client.Leases(ctx)
+	assert.Nil(t, err)
+	// leeway for the tests of other packages running meanwhile
+	assert.InDelta(t, len(before.Leases), len(after.Leases), 5, "each replaced lease revoked")
+
+	// a lease other keys are still on is left alone
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	lease, _ := LeaseID(loaded)
+	other := s.key("other")
+	_, err = s.Client.Put(ctx, other, "x", clientv3.WithLease(lease))
+	assert.Nil(t, err)
+	_, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	resp, err := s.Client.Get(ctx, other)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+}
+
+func TestEtcdStore_AdaptiveTTLKeepsLease(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.MinTTL = time.Minute
+	s.MaxTTL = time.Minute
+	leases := &countingLease{Lease: s.lease}
+	s.lease = leases
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	lease, _ := LeaseID(session)
+
+	// the lifetime doesn't change, so the lease is kept or extended
+	grants := leases.count()
+	for i := 0; i < 5; i++ {
+		loaded, err := loadByID(s, session.ID)
+		assert.Nil(t, err)
+		id, _ := LeaseID(loaded)
+		assert.Equal(t, lease, id)
+	}
+	assert.Equal(t, grants, leases.count())
+
+	// nor does a stale read overwrite a later save
+	resp, err := s.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	read := resp.Kvs[0]
+	session.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	stale, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	stale.Values["foo"] = "bar"
+	stale.Values[ttlKey] = int64(1)
+	assert.Nil(t, s.touchAdaptive(ctx, stale, read))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", loaded.Values["foo"])
+}
@@ -0,0 +1,255 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// ErrInvalidAuxSuffix is returned for an auxiliary data suffix that is empty
+// or starts with the prefix of the store's own keys.
+var ErrInvalidAuxSuffix = errors.New("etcdstore: invalid aux suffix")
+
+// auxPrefix returns the prefix of the auxiliary data of session id. The
+// delimiter can't appear in session IDs, so it can't reach another session.
+func (s *EtcdStore) auxPrefix(id string) string {
+	return s.key(id) + s.keyDelimiter
+}
+
+// auxSuffixes returns the suffixes recorded in session's values.
+func auxSuffixes(session *sessions.Session) []string {
+	suffixes, _ := session.Values[auxKey].([]string)
+	return suffixes
+}
+
+// auxLeaseOps returns the ops moving the recorded auxiliary data of session
+// to lease. Each is guarded, so data deleted meanwhile isn't recreated and
+// doesn't fail the save.
+func (s *EtcdStore) auxLeaseOps(session *sessions.Session, lease clientv3.LeaseID) []clientv3.Op {
+	var ops []clientv3.Op
+	for _, suffix := range auxSuffixes(session) {
+		key := s.auxPrefix(session.ID) + suffix
+		ops = append(ops, clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(key), ">", 0)},
+			[]clientv3.Op{clientv3.OpPut(key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease))},
+			nil))
+	}
+	return ops
+}
+
+// moveAuxOps returns the ops moving the auxiliary data of oldID to newID on
+// lease, for MoveSession. The data newID holds already is moved to lease
+// too if keep is set, as auxLeaseOps does, and deleted otherwise where oldID has none to replace
+// it. Field keys are left to moveFields under FieldKeys.
+func (s *EtcdStore) moveAuxOps(ctx context.Context, oldID, newID string, keep bool, lease clientv3.LeaseID) ([]clientv3.Op, error) {
+	src, err := s.auxData(ctx, oldID)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := s.auxData(ctx, newID, clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []clientv3.Op{clientv3.OpDelete(s.auxPrefix(oldID), clientv3.WithPrefix())}
+	for suffix, kv := range src {
+		ops = append(ops, clientv3.OpPut(s.auxPrefix(newID)+suffix, string(kv.Value), clientv3.WithLease(lease)))
+	}
+	for suffix := range dst {
+		if _, ok := src[suffix]; ok {
+			continue
+		}
+		key := s.auxPrefix(newID) + suffix
+		if keep {
+			ops = append(ops, clientv3.OpTxn(
+				[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(key), ">", 0)},
+				[]clientv3.Op{clientv3.OpPut(key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease))},
+				nil))
+		} else {
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+	}
+	return ops, nil
+}
+
+// auxData returns the auxiliary data of session id by suffix, without its
+// field keys under FieldKeys.
+func (s *EtcdStore) auxData(ctx context.Context, id string, opts ...clientv3.OpOption) (map[string]*mvccpb.KeyValue, error) {
+	prefix := s.auxPrefix(id)
+	resp, err := s.get(ctx, prefix, append([]clientv3.OpOption{clientv3.WithPrefix()}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]*mvccpb.KeyValue, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if s.fieldKeys() && strings.HasPrefix(string(kv.Key), s.fieldPrefix(id)) {
+			continue
+		}
+		data[strings.TrimPrefix(string(kv.Key), prefix)] = kv
+	}
+	return data, nil
+}
+
+// mergeKept records in dst, once MergeValues has merged src into it, the
+// auxiliary data suffixes and labels of both, src's labels winning, so that
+// MoveSession keeps them whatever MergeValues did.
+func mergeKept(dst, src map[interface{}]interface{}) {
+	seen := make(map[string]bool)
+	var suffixes []string
+	for _, values := range []map[interface{}]interface{}{dst, src} {
+		stored, _ := values[auxKey].([]string)
+		for _, suffix := range stored {
+			if !seen[suffix] {
+				seen[suffix] = true
+				suffixes = append(suffixes, suffix)
+			}
+		}
+	}
+	if len(suffixes) > 0 {
+		sort.Strings(suffixes)
+		dst[auxKey] = suffixes
+	}
+
+	labels := make(map[string]string)
+	for _, values := range []map[interface{}]interface{}{dst, src} {
+		stored, _ := values[labelsKey].(map[string]string)
+		for k, v := range stored {
+			labels[k] = v
+		}
+	}
+	if len(labels) > 0 {
+		dst[labelsKey] = labels
+	}
+}
+
+// PutAux stores value under {key}{delimiter}{suffix} next to the session,
+// e.g. a CSRF token, sharing the session's lease so that it expires along
+// with it. Save keeps it on the session's lease and deleting the session
+// deletes it.
+//
+// The suffix is recorded in the session values, which are written back
+// like Touch does, so session must be the saved one. Suffixes starting with
+// an underscore are kept for the store's own data, such as the secret of
+// GenerateCSRFSecret and the field keys of FieldKeys.
+func (s *EtcdStore) PutAux(ctx context.Context, session *sessions.Session, suffix string, value []byte) error {
+	if err := checkAuxSuffix(suffix); err != nil {
+		return s.sessionError("aux", session.Name(), err)
+	}
+	return s.sessionError("aux", session.Name(), s.putAux(ctx, session, suffix, value))
+}
+
+// putAux is PutAux for any suffix, the store's own included.
+func (s *EtcdStore) putAux(ctx context.Context, session *sessions.Session, suffix string, value []byte) error {
+	suffixes := auxSuffixes(session)
+	i := sort.SearchStrings(suffixes, suffix)
+	if i == len(suffixes) || suffixes[i] != suffix {
+		suffixes = append(suffixes[:i:i], append([]string{suffix}, suffixes[i:]...)...)
+	}
+	return s.writeAux(ctx, session, suffixes, func(lease clientv3.LeaseID) clientv3.Op {
+		return clientv3.OpPut(s.auxPrefix(session.ID)+suffix, string(value), clientv3.WithLease(lease))
+	})
+}
+
+// checkAuxSuffix rejects an empty suffix, and one starting with
+// reservedPrefix, which would reach the keys the store keeps below the
+// session's.
+func checkAuxSuffix(suffix string) error {
+	if suffix == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidAuxSuffix)
+	}
+	if strings.HasPrefix(suffix, reservedPrefix) {
+		return fmt.Errorf("%w: %q starts with %q", ErrInvalidAuxSuffix, suffix, reservedPrefix)
+	}
+	return nil
+}
+
+// writeAux records suffixes in the values of session, writes them back and
+// runs the op returned by op for the session's lease in the same
+// transaction, retried until no other write got in between.
+func (s *EtcdStore) writeAux(ctx context.Context, session *sessions.Session, suffixes []string, op func(lease clientv3.LeaseID) clientv3.Op) error {
+	key := s.key(session.ID)
+	s.uncache(key)
+	for {
+		resp, err := s.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if resp.Count == 0 {
+			return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+
+		if len(suffixes) > 0 {
+			session.Values[auxKey] = suffixes
+		} else {
+			delete(session.Values, auxKey)
+		}
+		encoded, err := s.encode(session.Name(), session.ID, session.Values)
+		if err != nil {
+			return err
+		}
+
+		kv := resp.Kvs[0]
+		txn, err := s.commit(s.kv.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(key, encoded, clientv3.WithIgnoreLease()), op(clientv3.LeaseID(kv.Lease))))
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+		// saved meanwhile, maybe with another lease
+	}
+}
+
+// GetAux returns the auxiliary data stored by PutAux under suffix, or an
+// error wrapping ErrNotFound.
+func (s *EtcdStore) GetAux(ctx context.Context, session *sessions.Session, suffix string) ([]byte, error) {
+	if err := checkAuxSuffix(suffix); err != nil {
+		return nil, s.sessionError("aux", session.Name(), err)
+	}
+	value, err := s.getAux(ctx, session, suffix)
+	return value, s.sessionError("aux", session.Name(), err)
+}
+
+// getAux is GetAux for any suffix, the store's own included.
+func (s *EtcdStore) getAux(ctx context.Context, session *sessions.Session, suffix string) ([]byte, error) {
+	key := s.auxPrefix(session.ID) + suffix
+	resp, err := s.get(ctx, key, s.readOpts(ReadAux)...)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Count == 0 {
+		return nil, fmt.Errorf("key: %s is %w", key, ErrNotFound)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// DeleteAux deletes the auxiliary data stored by PutAux under suffix. It
+// isn't an error if there is none. The suffix is taken out of the session
+// values, which are written back as PutAux does.
+func (s *EtcdStore) DeleteAux(ctx context.Context, session *sessions.Session, suffix string) error {
+	return s.sessionError("aux", session.Name(), s.deleteAux(ctx, session, suffix))
+}
+
+func (s *EtcdStore) deleteAux(ctx context.Context, session *sessions.Session, suffix string) error {
+	if err := checkAuxSuffix(suffix); err != nil {
+		return err
+	}
+
+	var kept []string
+	for _, other := range auxSuffixes(session) {
+		if other != suffix {
+			kept = append(kept, other)
+		}
+	}
+	return s.writeAux(ctx, session, kept, func(clientv3.LeaseID) clientv3.Op {
+		return clientv3.OpDelete(s.auxPrefix(session.ID) + suffix)
+	})
+}
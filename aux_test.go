@@ -0,0 +1,84 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_Aux(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+	value, err := s.GetAux(ctx, session, "csrf")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("token"), value)
+
+	leaseOf := func(key string) int64 {
+		resp, err := store.Client.Get(ctx, key)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), resp.Count, key)
+		return resp.Kvs[0].Lease
+	}
+	auxKey := s.auxPrefix(session.ID) + "csrf"
+	assert.Equal(t, leaseOf(s.key(session.ID)), leaseOf(auxKey), "shares the session's lease")
+
+	// a later save, with a new lease, takes the aux data along
+	session.Options.MaxAge = 120
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	lease := leaseOf(s.key(session.ID))
+	assert.Equal(t, lease, leaseOf(auxKey))
+
+	ids, err := s.FilterSessions(ctx, "_session", func(*sessions.Session) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids, "aux data isn't a session")
+
+	// expiring the session expires the aux data
+	_, err = store.Client.Revoke(ctx, clientv3.LeaseID(lease))
+	assert.Nil(t, err)
+	_, err = s.GetAux(ctx, session, "csrf")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_DeleteAux(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, nil)
+
+	assert.True(t, errors.Is(s.PutAux(ctx, session, "", nil), ErrInvalidAuxSuffix))
+	assert.Nil(t, s.PutAux(ctx, session, "nonce", []byte("1")))
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+	assert.Equal(t, []string{"csrf", "nonce"}, session.Values[auxKey])
+
+	assert.Nil(t, s.DeleteAux(ctx, session, "nonce"))
+	_, err := s.GetAux(ctx, session, "nonce")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, []string{"csrf"}, session.Values[auxKey])
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"csrf"}, loaded.Values[auxKey], "removal stored")
+
+	// the store's own keys below the session's are out of reach
+	field := reservedPrefix + "field"
+	assert.True(t, errors.Is(s.PutAux(ctx, session, field, nil), ErrInvalidAuxSuffix))
+	assert.True(t, errors.Is(s.DeleteAux(ctx, session, field), ErrInvalidAuxSuffix))
+
+	// saving with a stale record of deleted data doesn't bring it back
+	session.Values[auxKey] = []string{"csrf", "nonce"}
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = s.GetAux(ctx, session, "nonce")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// deleting the session deletes the rest
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = s.GetAux(ctx, session, "csrf")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
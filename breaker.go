@@ -0,0 +1,121 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// ErrCircuitOpen is returned without contacting etcd while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("etcdstore: circuit breaker is open")
+
+// CircuitState is the state of the store's circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through to etcd.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests fast with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single request through again after the
+	// cooldown, failing the others fast until its result closes the circuit
+	// or opens it for another cooldown.
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker counts consecutive etcd failures for the store.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+
+	// probing is set while the request let through half-open is pending.
+	probing bool
+}
+
+// CircuitState returns the current state of the circuit breaker.
+func (s *EtcdStore) CircuitState() CircuitState {
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+
+	return s.breaker.state(s)
+}
+
+func (b *breaker) state(s *EtcdStore) CircuitState {
+	switch {
+	case !b.open:
+		return CircuitClosed
+	case s.clock().Sub(b.openedAt) >= s.BreakerCooldown:
+		return CircuitHalfOpen
+	default:
+		return CircuitOpen
+	}
+}
+
+func (b *breaker) allow(s *EtcdStore) error {
+	if s.BreakerThreshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state(s) {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record counts the outcome of a request that took took: err other than
+// an answer from etcd, such as a lease it doesn't know about, or took past
+// BreakerLatency is a failure.
+func (b *breaker) record(s *EtcdStore, err error, took time.Duration) {
+	if s.BreakerThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		// no verdict, let the next request probe
+		return
+	}
+	failed := err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) ||
+		s.BreakerLatency > 0 && took > s.BreakerLatency
+	if !failed {
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures++
+	if b.open || b.failures >= s.BreakerThreshold {
+		b.open = true
+		b.openedAt = s.clock()
+	}
+}
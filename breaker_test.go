@@ -0,0 +1,140 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_CircuitBreaker(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.BreakerThreshold = 2
+	s.BreakerCooldown = time.Minute
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	fault := &faultyKV{KV: s.kv, err: errors.New("etcd is struggling")}
+	s.kv = fault
+
+	_, err := loadByID(s, session.ID)
+	assert.Equal(t, fault.err, err)
+	assert.Equal(t, CircuitClosed, s.CircuitState())
+
+	_, err = loadByID(s, session.ID)
+	assert.Equal(t, fault.err, err)
+	assert.Equal(t, CircuitOpen, s.CircuitState())
+
+	// open: fail fast without reaching etcd
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, 2, fault.calls)
+
+	// half-open: a failed trial opens the circuit again
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, s.CircuitState())
+	_, err = loadByID(s, session.ID)
+	assert.Equal(t, fault.err, err)
+	assert.Equal(t, CircuitOpen, s.CircuitState())
+
+	// half-open: a successful trial closes it
+	now = now.Add(time.Minute)
+	fault.err = nil
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, CircuitClosed, s.CircuitState())
+
+	// a missing key is not a failure
+	fault.err = nil
+	for i := 0; i < 3; i++ {
+		_, err = loadByID(s, "missing")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+	assert.Equal(t, CircuitClosed, s.CircuitState())
+}
+
+func TestEtcdStore_CircuitBreakerProbe(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.BreakerThreshold = 1
+	s.BreakerCooldown = time.Minute
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	kv := s.kv
+	s.kv = &faultyKV{KV: kv, err: errors.New("etcd is struggling")}
+	_, err := loadByID(s, session.ID)
+	assert.NotNil(t, err)
+	assert.Equal(t, CircuitOpen, s.CircuitState())
+
+	// half-open: a single probe goes through while the others fail fast
+	now = now.Add(time.Minute)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	s.kv = blockingKV{KV: kv, started: started, release: release}
+	probe := make(chan error, 1)
+	go func() {
+		_, err := loadByID(s, session.ID)
+		probe <- err
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = loadByID(s, session.ID)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.True(t, errors.Is(err, ErrCircuitOpen), "%v", err)
+	}
+	assert.Equal(t, CircuitHalfOpen, s.CircuitState())
+
+	close(release)
+	assert.Nil(t, <-probe)
+	assert.Equal(t, CircuitClosed, s.CircuitState())
+	_, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+}
+
+func TestEtcdStore_CircuitBreakerLatency(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.BreakerThreshold = 2
+	s.BreakerCooldown = time.Minute
+	s.BreakerLatency = time.Second
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	kv := s.kv
+	s.kv = tickingKV{KV: kv, tick: func() { now = now.Add(2 * time.Second) }}
+	for i := 0; i < 2; i++ {
+		loaded, err := loadByID(s, session.ID)
+		assert.Nil(t, err, "slow but served")
+		assert.Equal(t, "bar", loaded.Values["foo"])
+	}
+	assert.Equal(t, CircuitOpen, s.CircuitState())
+
+	// a fast trial closes it
+	now = now.Add(time.Minute)
+	s.kv = kv
+	_, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, CircuitClosed, s.CircuitState())
+
+	// nor is a lease etcd doesn't know a failure
+	s.BreakerThreshold = 1
+	_, err = s.revoke(context.Background(), clientv3.LeaseID(12345))
+	assert.ErrorIs(t, err, rpctypes.ErrLeaseNotFound)
+	assert.Equal(t, CircuitClosed, s.CircuitState())
+}
@@ -0,0 +1,155 @@
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// bucketSegment starts the bucket keys used when Buckets is set.
+const bucketSegment = reservedPrefix + "bucket"
+
+// bucketEntry is a session held in a bucket.
+type bucketEntry struct {
+	Value []byte
+
+	// Expires is when the session expires, in Unix nanoseconds, as bucket
+	// keys have no lease.
+	Expires int64
+}
+
+// bucketKey returns the key of the bucket holding session id.
+func (s *EtcdStore) bucketKey(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return s.key(bucketSegment + s.keyDelimiter + strconv.FormatUint(uint64(h.Sum32()%uint32(s.Buckets)), 10))
+}
+
+// readBucket returns the entries of the bucket in resp, dropping the ones
+// expired for longer than SkewTolerance.
+func (s *EtcdStore) readBucket(resp *clientv3.GetResponse) (map[string]bucketEntry, error) {
+	entries := make(map[string]bucketEntry)
+	if resp.Count == 0 {
+		return entries, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(resp.Kvs[0].Value)).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%w: bucket %s: %v", ErrCorruptValue, resp.Kvs[0].Key, err)
+	}
+	now := s.clock().Add(-s.SkewTolerance).UnixNano()
+	for id, entry := range entries {
+		if entry.Expires <= now {
+			delete(entries, id)
+		}
+	}
+	return entries, nil
+}
+
+// loadBucketed returns the entry of session id from its bucket.
+func (s *EtcdStore) loadBucketed(ctx context.Context, id string) (bucketEntry, error) {
+	key := s.bucketKey(id)
+	resp, err := s.get(ctx, key, s.readOpts(ReadLoad, s.GetOptions...)...)
+	if err != nil {
+		return bucketEntry{}, err
+	}
+
+	entries, err := s.readBucket(resp)
+	if err != nil {
+		return bucketEntry{}, err
+	}
+	entry, ok := entries[id]
+	if !ok {
+		return bucketEntry{}, fmt.Errorf("key: %s/%s is %w", key, id, ErrNotFound)
+	}
+	return entry, nil
+}
+
+// updateBucket applies fn to the entries of the bucket holding session id
+// and writes them back, retrying until the bucket didn't change meanwhile.
+// An empty bucket is deleted.
+func (s *EtcdStore) updateBucket(ctx context.Context, id string, fn func(map[string]bucketEntry) error) error {
+	key := s.bucketKey(id)
+	for {
+		resp, err := s.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		entries, err := s.readBucket(resp)
+		if err != nil {
+			return err
+		}
+		if err := fn(entries); err != nil {
+			return err
+		}
+
+		cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		if resp.Count > 0 {
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+		}
+		op := clientv3.OpDelete(key)
+		if len(entries) > 0 {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
+				return err
+			}
+			op = clientv3.OpPut(key, buf.String(), s.PutOptions...)
+		}
+
+		txn, err := s.commit(s.kv.Txn(ctx).If(cmp).Then(op))
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+		// another session of the bucket was written, start over
+	}
+}
+
+// saveBucketed stores the encoded value of session id in its bucket for
+// maxAge seconds. If create is set, id must not be in the bucket yet.
+func (s *EtcdStore) saveBucketed(ctx context.Context, id, encoded string, maxAge int, create bool) error {
+	expires := s.clock().Add(time.Duration(maxAge) * time.Second).UnixNano()
+	return s.updateBucket(ctx, id, func(entries map[string]bucketEntry) error {
+		if _, ok := entries[id]; ok && create {
+			return fmt.Errorf("%w: key %s/%s", ErrIDCollision, s.bucketKey(id), id)
+		}
+		entries[id] = bucketEntry{Value: []byte(encoded), Expires: expires}
+		return nil
+	})
+}
+
+// touchBucketed replaces the value of session id in its bucket with
+// encoded, keeping its expiry, unless prev is set and the bucket no longer
+// holds it.
+func (s *EtcdStore) touchBucketed(ctx context.Context, id, encoded string, prev []byte) error {
+	return s.updateBucket(ctx, id, func(entries map[string]bucketEntry) error {
+		entry, ok := entries[id]
+		if !ok {
+			return fmt.Errorf("key: %s/%s is %w", s.bucketKey(id), id, ErrNotFound)
+		}
+		if prev != nil && !bytes.Equal(entry.Value, prev) {
+			return nil
+		}
+		entry.Value = []byte(encoded)
+		entries[id] = entry
+		return nil
+	})
+}
+
+// deleteBucketed removes session id from its bucket.
+func (s *EtcdStore) deleteBucketed(ctx context.Context, id string) error {
+	return s.updateBucket(ctx, id, func(entries map[string]bucketEntry) error {
+		if _, ok := entries[id]; !ok {
+			return fmt.Errorf("key: %s/%s is %w", s.bucketKey(id), id, ErrNotFound)
+		}
+		delete(entries, id)
+		return nil
+	})
+}
@@ -0,0 +1,85 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_Buckets(t *testing.T) {
+	s := newTestStore(t)
+	s.Buckets = 1
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	resp, err := store.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "no key of its own")
+	resp, err = store.Client.Get(context.Background(), s.bucketKey(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+
+	// expired on read
+	now = now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	session = newSavedSession(t, s, nil)
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	resp, err = store.Client.Get(context.Background(), s.key(bucketSegment), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "expired entries pruned, empty bucket deleted")
+}
+
+// Run with -race.
+func TestEtcdStore_BucketsConcurrent(t *testing.T) {
+	s := newTestStore(t)
+	s.Buckets = 1
+
+	var wg sync.WaitGroup
+	ids := make([]string, 16)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i] = newSavedSession(t, s, map[interface{}]interface{}{"n": i}).ID
+		}(i)
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		loaded, err := loadByID(s, id)
+		assert.Nil(t, err, fmt.Sprint(i))
+		assert.Equal(t, i, loaded.Values["n"], "no write to the bucket lost")
+	}
+}
+
+func TestEtcdStore_BucketsKeyDelimiter(t *testing.T) {
+	s := newTestStore(t)
+	assert.Nil(t, s.SetKeyDelimiter(":"))
+	s.Buckets = 1
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := store.Client.Get(context.Background(), s.keyPrefix+":"+bucketSegment+":0")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count, "under the delimiter")
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+}
@@ -0,0 +1,193 @@
+package etcdstore
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// DefaultCacheTTL is how long a cached session stays fresh when CacheSize
+// is set and CacheTTL isn't.
+const DefaultCacheTTL = time.Second
+
+// cache is a size-bounded LRU of stored session values by etcd key.
+type cache struct {
+	// hits, misses and evictions are updated atomically, for CacheStats.
+	hits, misses, evictions uint64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   list.List
+}
+
+type cacheEntry struct {
+	kv      *mvccpb.KeyValue
+	expires time.Time
+}
+
+// get returns the value cached for key if it is still fresh at now. Unless
+// keepStale is set, an entry found stale is dropped.
+func (c *cache) get(key string, now time.Time, keepStale bool) (*mvccpb.KeyValue, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		if !keepStale {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	atomic.AddUint64(&c.hits, 1)
+	return entry.kv, true
+}
+
+// stale returns the value cached for key, fresh or not.
+func (c *cache) stale(key string) (*mvccpb.KeyValue, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*cacheEntry).kv, true
+}
+
+// put caches kv until expires, evicting the least recently used entries
+// beyond size.
+func (c *cache) put(kv *mvccpb.KeyValue, expires time.Time, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := string(kv.Key)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &cacheEntry{kv: kv, expires: expires}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{kv: kv, expires: expires})
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, string(oldest.Value.(*cacheEntry).kv.Key))
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+// forget drops the entries of keys.
+func (c *cache) forget(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// forgetLease drops the entries of the values attached to lease.
+func (c *cache) forgetLease(lease int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if elem.Value.(*cacheEntry).kv.Lease == lease {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// CacheStats counts what the cache enabled by CacheSize did since the store
+// was created, to help size it.
+type CacheStats struct {
+	// Hits and Misses count the loads served from the cache, and those
+	// that read etcd, an entry gone stale included.
+	Hits, Misses uint64
+
+	// Evictions counts the entries dropped to make room for others, which
+	// a larger CacheSize would have kept.
+	Evictions uint64
+
+	// Size is how many entries the cache holds now.
+	Size int
+}
+
+// CacheStats returns the statistics of the cache. The counters are read
+// atomically, only Size waits for the cache's lock.
+func (s *EtcdStore) CacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:      atomic.LoadUint64(&s.cache.hits),
+		Misses:    atomic.LoadUint64(&s.cache.misses),
+		Evictions: atomic.LoadUint64(&s.cache.evictions),
+	}
+	s.cache.mu.Lock()
+	stats.Size = s.cache.order.Len()
+	s.cache.mu.Unlock()
+	return stats
+}
+
+// cached returns the value cached for key, if the cache is enabled.
+func (s *EtcdStore) cached(key string) (*mvccpb.KeyValue, bool) {
+	if s.CacheSize <= 0 || s.Buckets > 0 {
+		return nil, false
+	}
+	return s.cache.get(key, s.clock(), s.StaleWhileUnavailable)
+}
+
+// staleCached returns the value cached for key under StaleWhileUnavailable,
+// however old, for a load that failed to reach etcd. Field keys aren't
+// cached, so sessions stored with them aren't served stale.
+func (s *EtcdStore) staleCached(key string) (*mvccpb.KeyValue, bool) {
+	if !s.StaleWhileUnavailable || s.CacheSize <= 0 || s.Buckets > 0 || s.fieldKeys() {
+		return nil, false
+	}
+	return s.cache.stale(key)
+}
+
+// cacheValue caches kv, if the cache is enabled.
+func (s *EtcdStore) cacheValue(kv *mvccpb.KeyValue) {
+	if s.CacheSize <= 0 || s.Buckets > 0 {
+		return
+	}
+
+	ttl := s.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	s.cache.put(kv, s.clock().Add(ttl), s.CacheSize)
+}
+
+// uncache drops the values cached for keys, which are about to change.
+func (s *EtcdStore) uncache(keys ...string) {
+	if s.CacheSize > 0 {
+		s.cache.forget(keys...)
+	}
+}
+
+// IsStale reports whether session was served from the cache under
+// StaleWhileUnavailable because etcd couldn't be reached when it was
+// loaded.
+func IsStale(session *sessions.Session) bool {
+	stale, _ := session.Values[staleKey].(bool)
+	return stale
+}
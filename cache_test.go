@@ -0,0 +1,178 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCache(t *testing.T) {
+	var c cache
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		c.put(&mvccpb.KeyValue{Key: []byte(key)}, now.Add(time.Second), 2)
+	}
+
+	_, ok := c.get("a", now, false)
+	assert.False(t, ok, "least recently used evicted")
+	_, ok = c.get("b", now, false)
+	assert.True(t, ok)
+	c.put(&mvccpb.KeyValue{Key: []byte("d")}, now.Add(time.Second), 2)
+	_, ok = c.get("c", now, false)
+	assert.False(t, ok, "b was used more recently")
+
+	_, ok = c.get("d", now.Add(time.Second), true)
+	assert.False(t, ok, "expired")
+	_, ok = c.stale("d")
+	assert.True(t, ok, "kept stale")
+	_, ok = c.get("b", now.Add(time.Second), false)
+	assert.False(t, ok, "expired")
+	_, ok = c.stale("b")
+	assert.False(t, ok, "dropped")
+	c.forget("d")
+	_, ok = c.get("d", now, false)
+	assert.False(t, ok)
+}
+
+func TestEtcdStore_Cache(t *testing.T) {
+	s := newTestStore(t)
+	s.CacheSize = 10
+	s.CacheTTL = time.Minute
+	kv := &faultyKV{KV: s.kv}
+	s.kv = kv
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	_, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	calls := kv.calls
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, calls, kv.calls, "served from the cache")
+
+	session.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", loaded.Values["foo"], "saving drops the entry")
+}
+
+func TestEtcdStore_StaleWhileUnavailable(t *testing.T) {
+	s := newTestStore(t)
+	s.CacheSize = 10
+	s.CacheTTL = time.Minute
+	s.StaleWhileUnavailable = true
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	kv := &faultyKV{KV: s.kv}
+	s.kv = kv
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	gone := newSavedSession(t, s, nil)
+	for _, id := range []string{session.ID, gone.ID} {
+		loaded, err := loadByID(s, id)
+		assert.Nil(t, err)
+		assert.False(t, IsStale(loaded))
+	}
+	_, err := s.Client.Delete(context.Background(), s.key(gone.ID))
+	assert.Nil(t, err)
+
+	// past CacheTTL etcd is read again, and a missing session dropped
+	now = now.Add(time.Hour)
+	_, err = loadByID(s, gone.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	kv.err = status.Error(codes.Unavailable, "connection refused")
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.True(t, IsStale(loaded))
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	s.IdleTimeout = 2 * time.Hour
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err, "not touched")
+	assert.True(t, IsStale(loaded))
+	s.IdleTimeout = time.Minute
+	loaded.Values[lastAccessKey] = now.Add(-time.Hour).UnixNano()
+	assert.ErrorIs(t, s.checkIdle(context.Background(), loaded, nil, nil), ErrIdleTimeout)
+	s.IdleTimeout = 0
+	_, err = loadByID(s, gone.ID)
+	assert.ErrorIs(t, err, kv.err)
+	s.FieldKeys = true
+	_, err = loadByID(s, session.ID)
+	assert.ErrorIs(t, err, kv.err, "field keys aren't cached")
+	s.FieldKeys = false
+
+	// writes still fail
+	loaded.Options.MaxAge = 60
+	assert.ErrorIs(t, s.Save(nil, httptest.NewRecorder(), loaded), kv.err)
+
+	s.StaleWhileUnavailable = false
+	_, err = loadByID(s, session.ID)
+	assert.ErrorIs(t, err, kv.err)
+
+	// once etcd is back, the flag isn't stored
+	s.StaleWhileUnavailable = true
+	kv.err = nil
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+	reloaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.False(t, IsStale(reloaded))
+}
+
+func TestEtcdStore_CacheWrites(t *testing.T) {
+	s := newTestStore(t)
+	s.CacheSize = 10
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := store.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+
+	// reads lag behind the first write from now on
+	s.kv = laggingKV{KV: s.kv, rev: resp.Header.Revision}
+	session.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"], "stale without CacheWrites")
+
+	s.CacheWrites = true
+	session.Values["foo"] = "qux"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "qux", loaded.Values["foo"], "reads its own write")
+
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, ok := s.cached(s.key(session.ID))
+	assert.False(t, ok, "deleting drops the entry")
+}
+
+func TestEtcdStore_CacheStats(t *testing.T) {
+	s := newTestStore(t)
+	s.CacheSize = 2
+	s.CacheTTL = time.Minute
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, newSavedSession(t, s, nil).ID)
+	}
+	assert.Equal(t, CacheStats{}, s.CacheStats())
+
+	for _, id := range ids {
+		_, err := loadByID(s, id)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, CacheStats{Misses: 3, Evictions: 1, Size: 2}, s.CacheStats())
+
+	_, err := loadByID(s, ids[2])
+	assert.Nil(t, err)
+	_, err = loadByID(s, ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 4, Evictions: 2, Size: 2}, s.CacheStats())
+}
@@ -0,0 +1,76 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// CompareAndSwap replaces the stored values of session with
+// session.Values, keeping its lease, only if the session key was last
+// written at expectedRevision, e.g. as SessionMeta.ModRevision reported it,
+// and reports whether it did. It returns false when another write got in
+// between, for custom concurrency control over sessions rebuilt wholesale.
+//
+// Under FieldKeys, the field keys are rewritten along with it, and those of
+// values no longer in session.Values deleted. Unlike Save, it neither sets
+// a cookie nor updates the user index, so the value under UserIDKey must
+// not change. Like Touch, it doesn't apply to Buckets.
+func (s *EtcdStore) CompareAndSwap(ctx context.Context, session *sessions.Session, expectedRevision int64) (bool, error) {
+	swapped, err := s.compareAndSwap(ctx, session, expectedRevision)
+	return swapped, s.sessionError("cas", session.Name(), err)
+}
+
+func (s *EtcdStore) compareAndSwap(ctx context.Context, session *sessions.Session, expectedRevision int64) (bool, error) {
+	if err := checkReservedValues(session.Values); err != nil {
+		return false, err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+
+	encoded, err := s.encode(session.Name(), session.ID, session.Values)
+	if err != nil {
+		return false, err
+	}
+
+	key := s.key(session.ID)
+	s.uncache(key)
+	ops := []clientv3.Op{clientv3.OpPut(key, encoded, clientv3.WithIgnoreLease())}
+	var digests map[string]string
+	if s.fieldKeys() {
+		resp, err := s.get(ctx, key, clientv3.WithKeysOnly())
+		if err != nil {
+			return false, err
+		}
+		if resp.Count == 0 {
+			return false, fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+		// a lease read before another write fails the revision check below
+		var fieldOps []clientv3.Op
+		if fieldOps, digests, err = s.rewriteFieldOps(ctx, session, clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+			return false, err
+		}
+		ops = append(ops, fieldOps...)
+	}
+
+	txn, err := s.commit(s.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0),
+			clientv3.Compare(clientv3.ModRevision(key), "=", expectedRevision)).
+		Then(ops...).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())))
+	if err != nil {
+		return false, err
+	}
+	if !txn.Succeeded && txn.Responses[0].GetResponseRange().Count == 0 {
+		return false, fmt.Errorf("key: %s is %w", key, ErrNotFound)
+	}
+	if txn.Succeeded && s.fieldKeys() {
+		setFieldDigests(session, digests)
+	}
+	return txn.Succeeded, nil
+}
@@ -0,0 +1,46 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_CompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"step": 1})
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	rev, lease := resp.Kvs[0].ModRevision, resp.Kvs[0].Lease
+
+	// a concurrent write makes the revision stale
+	assert.Nil(t, s.Touch(ctx, session))
+	session.Values = map[interface{}]interface{}{"step": 2}
+	swapped, err := s.CompareAndSwap(ctx, session, rev)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, loaded.Values["step"], "not clobbered")
+
+	resp, err = store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	swapped, err = s.CompareAndSwap(ctx, session, resp.Kvs[0].ModRevision)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, loaded.Values["step"])
+	resp, err = store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, lease, resp.Kvs[0].Lease, "lease kept")
+
+	missing := sessions.NewSession(s, "_session")
+	missing.ID = "missing"
+	_, err = s.CompareAndSwap(ctx, missing, 0)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
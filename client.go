@@ -0,0 +1,90 @@
+package etcdstore
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// The helpers below are the store's only way of talking to etcd, so that
+// every request goes through call.
+
+func (s *EtcdStore) get(ctx context.Context, key string, opts ...clientv3.OpOption) (resp *clientv3.GetResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.kv.Get(ctx, key, opts...)
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (resp *clientv3.PutResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.kv.Put(ctx, key, val, opts...)
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) del(ctx context.Context, key string, opts ...clientv3.OpOption) (resp *clientv3.DeleteResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.kv.Delete(ctx, key, opts...)
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) commit(txn clientv3.Txn) (resp *clientv3.TxnResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = txn.Commit()
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) grant(ctx context.Context, ttl int64) (resp *clientv3.LeaseGrantResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.lease.Grant(ctx, ttl)
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) revoke(ctx context.Context, id clientv3.LeaseID) (resp *clientv3.LeaseRevokeResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.lease.Revoke(ctx, id)
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) timeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (resp *clientv3.LeaseTimeToLiveResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.lease.TimeToLive(ctx, id, opts...)
+		return err
+	})
+	return resp, err
+}
+
+func (s *EtcdStore) keepAliveOnce(ctx context.Context, id clientv3.LeaseID) (resp *clientv3.LeaseKeepAliveResponse, err error) {
+	err = s.call(func() (err error) {
+		resp, err = s.lease.KeepAliveOnce(ctx, id)
+		return err
+	})
+	return resp, err
+}
+
+// call runs a single etcd request through the circuit breaker.
+func (s *EtcdStore) call(fn func() error) error {
+	if err := s.breaker.allow(s); err != nil {
+		return err
+	}
+
+	start := s.clock()
+	err := fn()
+	if isAuthTokenExpired(err) && !s.NoAuthRetry {
+		// clientv3 dropped the token and fetches a fresh one for the retry
+		err = fn()
+	}
+	s.breaker.record(s, err, s.clock().Sub(start))
+	return err
+}
@@ -0,0 +1,224 @@
+package etcdstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression identifies a value compression algorithm. Compressed values
+// record it in their first byte, so they still decode after the store's
+// Compression setting changes.
+type Compression byte
+
+const (
+	// NoCompression stores values as they are.
+	NoCompression Compression = 0
+	// Gzip compresses values with compress/gzip.
+	Gzip Compression = 1
+	// Zstd is reserved for zstd. This package doesn't ship an implementation,
+	// to stay free of the dependency; register one with RegisterCompressor.
+	Zstd Compression = 2
+)
+
+// Compressor implements a compression algorithm for stored values.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var compressors = struct {
+	sync.RWMutex
+	m map[Compression]Compressor
+}{m: map[Compression]Compressor{Gzip: gzipCompressor{}}}
+
+// RegisterCompressor makes a compression algorithm available under id, for
+// use as EtcdStore.Compression and to decode values compressed with it.
+// It panics if id is NoCompression.
+func RegisterCompressor(id Compression, c Compressor) {
+	if id == NoCompression {
+		panic("etcdstore: can't register a compressor as NoCompression")
+	}
+
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[id] = c
+}
+
+func compressor(id Compression) (Compressor, error) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+
+	c, ok := compressors.m[id]
+	if !ok {
+		return nil, fmt.Errorf("etcdstore: unknown compression %d", id)
+	}
+	return c, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// pack returns what encode hands to the codecs for values: values
+// themselves, or when they serialize to more than CompressThreshold bytes
+// and Compression is set, the compressed serialization prefixed with the
+// compression byte, or values with the fields under CompressKeys compressed.
+// Compressing before the codecs means it happens ahead of any encryption.
+func (s *EtcdStore) pack(values map[interface{}]interface{}) (interface{}, error) {
+	if s.Compression == NoCompression {
+		return values, nil
+	}
+	if len(s.CompressKeys) > 0 {
+		return s.packFields(values)
+	}
+
+	serialized, err := s.valueSerializer().Serialize(values)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized) <= s.CompressThreshold {
+		return values, nil
+	}
+
+	c, err := compressor(s.Compression)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := c.Compress(serialized)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(s.Compression)}, compressed...), nil
+}
+
+// unpack is the inverse of pack for a compressed payload.
+func (s *EtcdStore) unpack(payload []byte, values *map[interface{}]interface{}) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("%w: empty compressed value", ErrCorruptValue)
+	}
+
+	c, err := compressor(Compression(payload[0]))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptValue, err)
+	}
+	serialized, err := c.Decompress(payload[1:])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptValue, err)
+	}
+	if err := s.valueSerializer().Deserialize(serialized, values); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptValue, err)
+	}
+	return nil
+}
+
+// compressedField replaces a value compressed on its own under CompressKeys.
+// Data is the serialization of the values holding it alone under its key,
+// compressed and prefixed with the compression byte like a compressed
+// payload.
+type compressedField struct {
+	Data []byte
+}
+
+func init() {
+	gob.RegisterName("etcdstore.compressedField", compressedField{})
+	RegisterJSONName("etcdstore.compressedField", compressedField{})
+}
+
+// packFields returns a copy of values with those under CompressKeys that
+// serialize to more than CompressThreshold bytes compressed.
+func (s *EtcdStore) packFields(values map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	var packed map[interface{}]interface{}
+	for _, key := range s.CompressKeys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+
+		serialized, err := s.valueSerializer().Serialize(map[interface{}]interface{}{key: value})
+		if err != nil {
+			return nil, fmt.Errorf("etcdstore: serializing %q: %w", key, err)
+		}
+		if len(serialized) <= s.CompressThreshold {
+			continue
+		}
+
+		c, err := compressor(s.Compression)
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := c.Compress(serialized)
+		if err != nil {
+			return nil, err
+		}
+
+		if packed == nil {
+			packed = make(map[interface{}]interface{}, len(values))
+			for k, v := range values {
+				packed[k] = v
+			}
+		}
+		packed[key] = compressedField{Data: append([]byte{byte(s.Compression)}, compressed...)}
+	}
+	if packed == nil {
+		return values, nil
+	}
+	return packed, nil
+}
+
+// unpackFields restores in place the values packFields compressed. It
+// doesn't depend on CompressKeys, so values stay readable once it changes.
+func (s *EtcdStore) unpackFields(values map[interface{}]interface{}) error {
+	for key, value := range values {
+		field, ok := value.(compressedField)
+		if !ok {
+			continue
+		}
+		if len(field.Data) == 0 {
+			return fmt.Errorf("%w: empty compressed field %v", ErrCorruptValue, key)
+		}
+
+		c, err := compressor(Compression(field.Data[0]))
+		if err != nil {
+			return fmt.Errorf("%w: field %v: %v", ErrCorruptValue, key, err)
+		}
+		serialized, err := c.Decompress(field.Data[1:])
+		if err != nil {
+			return fmt.Errorf("%w: field %v: %v", ErrCorruptValue, key, err)
+		}
+		var stored map[interface{}]interface{}
+		if err := s.valueSerializer().Deserialize(serialized, &stored); err != nil {
+			return fmt.Errorf("%w: field %v: %v", ErrCorruptValue, key, err)
+		}
+		// the only value, whatever key a FieldName renamed it to
+		if len(stored) != 1 {
+			return fmt.Errorf("%w: field %v holds %d values", ErrCorruptValue, key, len(stored))
+		}
+		for _, v := range stored {
+			values[key] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package etcdstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/assert"
+)
+
+// reverseCompressor stands in for another algorithm, such as zstd.
+type reverseCompressor struct{}
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (r reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return r.Compress(data)
+}
+
+func TestEtcdStore_Compression(t *testing.T) {
+	RegisterCompressor(200, reverseCompressor{})
+	s := newTestStore(t)
+	large := strings.Repeat("session data ", 150)
+
+	stored := func(id string) int {
+		resp, err := s.Client.Get(context.Background(), s.key(id))
+		assert.Nil(t, err)
+		return len(resp.Kvs[0].Value)
+	}
+
+	plain := newSavedSession(t, s, map[interface{}]interface{}{"blob": large})
+
+	s.Compression = Gzip
+	s.CompressThreshold = 1024
+	gzipped := newSavedSession(t, s, map[interface{}]interface{}{"blob": large})
+	small := newSavedSession(t, s, map[interface{}]interface{}{"blob": "tiny"})
+	assert.Less(t, stored(gzipped.ID), stored(plain.ID)/4)
+
+	s.Compression = 200
+	reversed := newSavedSession(t, s, map[interface{}]interface{}{"blob": large})
+
+	// every value decodes whatever the current setting
+	for _, compression := range []Compression{NoCompression, Gzip, 200} {
+		s.Compression = compression
+		for _, session := range []struct {
+			id   string
+			blob string
+		}{{plain.ID, large}, {gzipped.ID, large}, {small.ID, "tiny"}, {reversed.ID, large}} {
+			loaded, err := loadByID(s, session.id)
+			assert.Nil(t, err)
+			assert.Equal(t, session.blob, loaded.Values["blob"])
+		}
+	}
+
+	// an unknown compression is rejected even for values left uncompressed
+	s.Compression = 0
+	session := newSavedSession(t, s, nil)
+	s.Compression = 201
+	err := s.save(context.Background(), session, false)
+	assert.True(t, errors.Is(err, ErrInvalidPipeline), "unknown compression")
+	session.Values["blob"] = large
+	assert.NotNil(t, s.save(context.Background(), session, false), "unknown compression")
+}
+
+func TestEtcdStore_CompressKeys(t *testing.T) {
+	s := newTestStore(t)
+	s.Compression = Gzip
+	s.CompressThreshold = 1024
+	s.CompressKeys = []string{"blob", "missing"}
+	large := strings.Repeat("session data ", 150)
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"blob": large, "user": "alice"})
+	assert.Equal(t, large, session.Values["blob"], "the caller's values are left alone")
+
+	// only the listed field is compressed, the others read as they are
+	resp, err := s.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Less(t, len(resp.Kvs[0].Value), len(large)/2)
+	stored := make(map[interface{}]interface{})
+	assert.Nil(t, securecookie.DecodeMulti("_session", string(resp.Kvs[0].Value), &stored, s.Codecs...))
+	assert.Equal(t, "alice", stored["user"])
+	assert.IsType(t, compressedField{}, stored["blob"])
+
+	// and decode whatever the current setting
+	s.CompressKeys = nil
+	s.Compression = NoCompression
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, large, loaded.Values["blob"])
+	assert.Equal(t, "alice", loaded.Values["user"])
+
+	// fields under the threshold aren't compressed
+	s.Compression = Gzip
+	s.CompressKeys = []string{"blob"}
+	small := newSavedSession(t, s, map[interface{}]interface{}{"blob": "tiny"})
+	resp, err = s.Client.Get(context.Background(), s.key(small.ID))
+	assert.Nil(t, err)
+	stored = make(map[interface{}]interface{})
+	assert.Nil(t, securecookie.DecodeMulti("_session", string(resp.Kvs[0].Value), &stored, s.Codecs...))
+	assert.Equal(t, "tiny", stored["blob"])
+}
+
+// hookedCount is known to JSONSerializer through a hook alone, not to gob.
+type hookedCount int
+
+var countHooks = map[string]JSONHook{"visits": {
+	Marshal: func(value interface{}) ([]byte, error) {
+		return json.Marshal(int(value.(hookedCount)))
+	},
+	Unmarshal: func(data []byte) (interface{}, error) {
+		var n int
+		err := json.Unmarshal(data, &n)
+		return hookedCount(n), err
+	},
+}}
+
+func TestEtcdStore_CompressionJSON(t *testing.T) {
+	s := newTestStore(t)
+	s.serializer = JSONSerializer{Hooks: countHooks}
+	setSerializer(s, s.serializer)
+	s.Compression = Gzip
+	s.CompressThreshold = 1024
+	large := strings.Repeat("session data ", 150)
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"blob": large, "visits": hookedCount(3)})
+	resp, err := s.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Less(t, len(resp.Kvs[0].Value), len(large)/2)
+	var payload []byte
+	assert.Nil(t, securecookie.DecodeMulti("_session", string(resp.Kvs[0].Value), &payload, s.Codecs...))
+	assert.Equal(t, byte(Gzip), payload[0], "compressed with the serializer")
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, large, loaded.Values["blob"])
+	assert.Equal(t, hookedCount(3), loaded.Values["visits"], "through the serializer's hooks")
+}
+
+func TestEtcdStore_CompressKeysJSON(t *testing.T) {
+	s := newTestStore(t)
+	s.serializer = JSONSerializer{Hooks: countHooks}
+	setSerializer(s, s.serializer)
+	s.Compression = Gzip
+	s.CompressThreshold = 1024
+	s.CompressKeys = []string{"blob"}
+	large := strings.Repeat("session data ", 150)
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"blob": large, "visits": hookedCount(3)})
+	resp, err := s.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Less(t, len(resp.Kvs[0].Value), len(large)/2)
+
+	// the compressed field is marked with its type name in the JSON
+	var object map[string]jsonValue
+	assert.Nil(t, securecookie.DecodeMulti("_session", string(resp.Kvs[0].Value), &object, s.Codecs...))
+	assert.Equal(t, "etcdstore.compressedField", object["blob"].Type)
+	var field compressedField
+	assert.Nil(t, json.Unmarshal(object["blob"].Value, &field))
+	assert.Equal(t, byte(Gzip), field.Data[0])
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, large, loaded.Values["blob"])
+	assert.Equal(t, hookedCount(3), loaded.Values["visits"])
+}
@@ -0,0 +1,43 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with -race to check runtime reconfiguration.
+func TestEtcdStore_ConcurrentMaxAge(t *testing.T) {
+	s := newTestStore(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s.MaxAge(3600 + i*j)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+				assert.Nil(t, err, "http new request")
+				session, err := s.New(req, "_session")
+				assert.Nil(t, err)
+				session.Values["foo"] = "bar"
+				assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.RotateKeys([]byte("new-secret"), nil, []byte("secret"), nil)
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,202 @@
+package etcdstore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // registers "gzip"
+)
+
+// ErrInvalidConfig is returned by NewEtcdStoreFromConfig for a StoreConfig
+// whose settings don't fit together.
+var ErrInvalidConfig = errors.New("etcdstore: invalid config")
+
+// StoreConfig gathers the connection and behaviour settings of a store in
+// one place, for NewEtcdStoreFromConfig.
+type StoreConfig struct {
+	// Endpoints are the etcd client URLs, at least one is required.
+	Endpoints []string
+
+	// Username and Password authenticate against etcd. PasswordFile may be
+	// given instead of Password, its content with the trailing newline
+	// trimmed is the password.
+	Username     string
+	Password     string
+	PasswordFile string
+
+	// CertFile and KeyFile hold the client certificate for TLS mutual auth
+	// and must be set together. CAFile verifies the server certificate, the
+	// system roots are used without it. Setting any of them enables TLS.
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	// DialTimeout, DialKeepAliveTime and DialKeepAliveTimeout are passed to
+	// the etcd client. The keepalive settings default to
+	// DefaultDialKeepAliveTime and DefaultDialKeepAliveTimeout.
+	DialTimeout          time.Duration
+	DialKeepAliveTime    time.Duration
+	DialKeepAliveTimeout time.Duration
+
+	// GRPCCompression, e.g. "gzip", compresses the etcd requests and
+	// responses on the wire with that gRPC compressor, trading CPU for
+	// bandwidth on constrained networks. Unlike EtcdStore.Compression, which
+	// compresses stored values and so what etcd keeps, it only helps with
+	// the transfer, of every request, and etcd stores values as they are.
+	// The compressor must be registered with gRPC, as "gzip" is.
+	GRPCCompression string
+
+	// Context is the store's Context, context.Background() if nil.
+	Context context.Context
+
+	// Prefix is the key prefix, "/sessions" if empty. KeyDelimiter is
+	// passed to SetKeyDelimiter when not empty.
+	Prefix       string
+	KeyDelimiter string
+
+	// KeyPairs are the securecookie hash and block keys, as for
+	// NewEtcdStore. At least a hash key is required.
+	KeyPairs [][]byte
+
+	// Serializer encodes session values, securecookie.GobEncoder if nil.
+	Serializer securecookie.Serializer
+
+	// InsecurePlainID sets EtcdStore.InsecurePlainID, logging its warning
+	// as the store is built. FOR DEVELOPMENT ONLY.
+	InsecurePlainID bool
+}
+
+// NewEtcdStoreFromConfig validates config and returns a store connected as
+// it describes.
+func NewEtcdStoreFromConfig(config StoreConfig) (*EtcdStore, error) {
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s, err := NewEtcdStore(clientConfig, ctx, config.Prefix, config.KeyPairs...)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.KeyDelimiter != "" {
+		s.keyDelimiter = config.KeyDelimiter
+	}
+	if config.Serializer != nil {
+		s.serializer = config.Serializer
+		for _, codec := range s.Codecs {
+			if cookie, ok := codec.(*securecookie.SecureCookie); ok {
+				cookie.SetSerializer(config.Serializer)
+			}
+		}
+	}
+	if config.InsecurePlainID {
+		s.InsecurePlainID = true
+		s.warnPlainID()
+	}
+	return s, nil
+}
+
+// validate reports the first setting of config that is missing or clashes
+// with another one.
+func (config StoreConfig) validate() error {
+	switch {
+	case len(config.Endpoints) == 0:
+		return fmt.Errorf("%w: no endpoints", ErrInvalidConfig)
+	case config.CertFile != "" && config.KeyFile == "":
+		return fmt.Errorf("%w: CertFile set without KeyFile", ErrInvalidConfig)
+	case config.KeyFile != "" && config.CertFile == "":
+		return fmt.Errorf("%w: KeyFile set without CertFile", ErrInvalidConfig)
+	case config.Password != "" && config.PasswordFile != "":
+		return fmt.Errorf("%w: both Password and PasswordFile set", ErrInvalidConfig)
+	case config.Username == "" && (config.Password != "" || config.PasswordFile != ""):
+		return fmt.Errorf("%w: password set without Username", ErrInvalidConfig)
+	case config.DialTimeout < 0 || config.DialKeepAliveTime < 0 || config.DialKeepAliveTimeout < 0:
+		return fmt.Errorf("%w: negative timeout", ErrInvalidConfig)
+	case len(config.KeyPairs) == 0 || len(config.KeyPairs[0]) == 0:
+		return fmt.Errorf("%w: no hash key in KeyPairs", ErrInvalidConfig)
+	case config.GRPCCompression != "" && encoding.GetCompressor(config.GRPCCompression) == nil:
+		return fmt.Errorf("%w: gRPC compressor %q not registered", ErrInvalidConfig, config.GRPCCompression)
+	}
+
+	for _, endpoint := range config.Endpoints {
+		if endpoint == "" {
+			return fmt.Errorf("%w: empty endpoint", ErrInvalidConfig)
+		}
+	}
+	if config.KeyDelimiter != "" {
+		if err := checkKeyDelimiter(config.KeyDelimiter); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	return nil
+}
+
+// clientConfig validates config and turns it into an etcd client config,
+// reading the password and TLS files it names.
+func (config StoreConfig) clientConfig() (clientv3.Config, error) {
+	if err := config.validate(); err != nil {
+		return clientv3.Config{}, err
+	}
+
+	clientConfig := clientv3.Config{
+		Endpoints:            config.Endpoints,
+		Username:             config.Username,
+		Password:             config.Password,
+		DialTimeout:          config.DialTimeout,
+		DialKeepAliveTime:    config.DialKeepAliveTime,
+		DialKeepAliveTimeout: config.DialKeepAliveTimeout,
+	}
+	if config.GRPCCompression != "" {
+		clientConfig.DialOptions = append(clientConfig.DialOptions,
+			grpc.WithDefaultCallOptions(grpc.UseCompressor(config.GRPCCompression)))
+	}
+
+	if config.PasswordFile != "" {
+		b, err := ioutil.ReadFile(config.PasswordFile)
+		if err != nil {
+			return clientConfig, fmt.Errorf("%w: reading PasswordFile: %v", ErrInvalidConfig, err)
+		}
+		clientConfig.Password = strings.TrimRight(string(b), "\r\n")
+	}
+
+	if config.CertFile == "" && config.CAFile == "" {
+		return clientConfig, nil
+	}
+
+	clientConfig.TLS = &tls.Config{}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return clientConfig, fmt.Errorf("%w: loading client certificate: %v", ErrInvalidConfig, err)
+		}
+		clientConfig.TLS.Certificates = []tls.Certificate{cert}
+	}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return clientConfig, fmt.Errorf("%w: reading CAFile: %v", ErrInvalidConfig, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return clientConfig, fmt.Errorf("%w: no certificates in CAFile", ErrInvalidConfig)
+		}
+		clientConfig.TLS.RootCAs = pool
+	}
+	return clientConfig, nil
+}
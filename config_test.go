@@ -0,0 +1,159 @@
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestStoreConfig_Invalid(t *testing.T) {
+	valid := StoreConfig{Endpoints: []string{_defaultEtcd}, KeyPairs: [][]byte{[]byte("secret")}}
+
+	for name, change := range map[string]func(*StoreConfig){
+		"no endpoints":         func(c *StoreConfig) { c.Endpoints = nil },
+		"empty endpoint":       func(c *StoreConfig) { c.Endpoints = []string{""} },
+		"cert without key":     func(c *StoreConfig) { c.CertFile = "client.pem" },
+		"key without cert":     func(c *StoreConfig) { c.KeyFile = "client-key.pem" },
+		"two passwords":        func(c *StoreConfig) { c.Username, c.Password, c.PasswordFile = "root", "pass", "pass.txt" },
+		"password no username": func(c *StoreConfig) { c.Password = "pass" },
+		"negative timeout":     func(c *StoreConfig) { c.DialTimeout = -1 },
+		"no key pairs":         func(c *StoreConfig) { c.KeyPairs = nil },
+		"bad delimiter":        func(c *StoreConfig) { c.KeyDelimiter = "A" },
+		"missing cert files":   func(c *StoreConfig) { c.CertFile, c.KeyFile = "missing.pem", "missing-key.pem" },
+		"missing ca file":      func(c *StoreConfig) { c.CAFile = "missing-ca.pem" },
+		"unknown compressor":   func(c *StoreConfig) { c.GRPCCompression = "brotli" },
+	} {
+		config := valid
+		change(&config)
+		_, err := NewEtcdStoreFromConfig(config)
+		assert.True(t, errors.Is(err, ErrInvalidConfig), name)
+	}
+}
+
+func TestStoreConfig_PasswordFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("s3cret\n"), 0600))
+
+	config, err := StoreConfig{
+		Endpoints:    []string{_defaultEtcd},
+		Username:     "root",
+		PasswordFile: file,
+		KeyPairs:     [][]byte{[]byte("secret")},
+	}.clientConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "root", config.Username)
+	assert.Equal(t, "s3cret", config.Password)
+	assert.Nil(t, config.TLS)
+}
+
+// countingSerializer counts the values it serializes.
+type countingSerializer struct {
+	securecookie.GobEncoder
+	n *int
+}
+
+func (c countingSerializer) Serialize(src interface{}) ([]byte, error) {
+	*c.n++
+	return c.GobEncoder.Serialize(src)
+}
+
+func TestNewEtcdStoreFromConfig(t *testing.T) {
+	var serialized int
+	s, err := NewEtcdStoreFromConfig(StoreConfig{
+		Endpoints:    []string{_defaultEtcd},
+		Prefix:       "/test/config",
+		KeyDelimiter: ":",
+		KeyPairs:     [][]byte{[]byte("secret")},
+		Serializer:   countingSerializer{n: &serialized},
+	})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "/test/config:id", s.key("id"))
+	assert.NotNil(t, s.Context)
+
+	_, err = s.encode("_session", "", map[interface{}]interface{}{"foo": "bar"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, serialized)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	plain, err := NewEtcdStoreFromConfig(StoreConfig{
+		Endpoints:       []string{_defaultEtcd},
+		Prefix:          "/test/config",
+		KeyPairs:        [][]byte{[]byte("secret")},
+		InsecurePlainID: true,
+	})
+	assert.Nil(t, err)
+	defer plain.Close()
+	assert.True(t, plain.InsecurePlainID)
+	assert.Contains(t, logged.String(), "WARNING: InsecurePlainID", "warned as built")
+}
+
+func TestEtcdStore_RotateKeysSerializer(t *testing.T) {
+	config := StoreConfig{
+		Endpoints:  []string{_defaultEtcd},
+		Prefix:     fmt.Sprintf("/test/%s/%d", t.Name(), time.Now().UnixNano()),
+		KeyPairs:   [][]byte{[]byte("secret")},
+		Serializer: JSONSerializer{},
+	}
+	s, err := NewEtcdStoreFromConfig(config)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer s.Client.Delete(context.Background(), config.Prefix+"/", clientv3.WithPrefix())
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	s.RotateKeys([]byte("new-secret"), nil, []byte("secret"), nil)
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err, "old key still accepted")
+	loaded.Options.MaxAge = 60
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+
+	// a store configured with the new key alone reads what the rotated one wrote
+	config.KeyPairs = [][]byte{[]byte("new-secret")}
+	rotated, err := NewEtcdStoreFromConfig(config)
+	assert.Nil(t, err)
+	defer rotated.Close()
+	loaded, err = loadByID(rotated, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+}
+
+func TestStoreConfig_GRPCCompression(t *testing.T) {
+	config := StoreConfig{
+		Endpoints: []string{_defaultEtcd},
+		Prefix:    fmt.Sprintf("/test/%s/%d", t.Name(), time.Now().UnixNano()),
+		KeyPairs:  [][]byte{[]byte("secret")},
+	}
+	clientConfig, err := config.clientConfig()
+	assert.Nil(t, err)
+	assert.Empty(t, clientConfig.DialOptions)
+
+	config.GRPCCompression = "gzip"
+	clientConfig, err = config.clientConfig()
+	assert.Nil(t, err)
+	assert.Len(t, clientConfig.DialOptions, 1)
+
+	// etcd takes compressed requests
+	s, err := NewEtcdStoreFromConfig(config)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer s.Client.Delete(context.Background(), config.Prefix+"/", clientv3.WithPrefix())
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+}
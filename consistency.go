@@ -0,0 +1,42 @@
+package etcdstore
+
+import "go.etcd.io/etcd/client/v3"
+
+// ReadOp names a read path of the store whose consistency can be chosen
+// with ReadConsistency.
+type ReadOp int
+
+const (
+	// ReadLoad is the read of a session by New, Get, GetWithMeta and
+	// FindRaw.
+	ReadLoad ReadOp = iota
+	// ReadScan is the prefix scan of FilterSessions and
+	// ListSessionIDsCreatedBetween.
+	ReadScan
+	// ReadUserSessions is the user index read of UserSessions. Save always
+	// reads the index linearizably, as it enforces MaxSessionsPerUser.
+	ReadUserSessions
+	// ReadAux is the read of GetAux.
+	ReadAux
+)
+
+// Consistency is how up to date an etcd read must be.
+type Consistency int
+
+const (
+	// Linearizable reads go through the leader and see every write
+	// committed before them.
+	Linearizable Consistency = iota
+	// Serializable reads are served by whichever member is asked, without
+	// a round-trip to the leader, and may miss the latest writes.
+	Serializable
+)
+
+// readOpts returns opts, made serializable if ReadConsistency asks for it
+// on op.
+func (s *EtcdStore) readOpts(op ReadOp, opts ...clientv3.OpOption) []clientv3.OpOption {
+	if s.ReadConsistency[op] != Serializable {
+		return opts
+	}
+	return append(opts[:len(opts):len(opts)], clientv3.WithSerializable())
+}
@@ -0,0 +1,61 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_ReadConsistency(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+
+	kv := &consistencyKV{KV: s.kv}
+	s.kv = kv
+	read := map[ReadOp]func() string{
+		ReadLoad: func() string {
+			_, err := loadByID(s, session.ID)
+			assert.Nil(t, err)
+			return s.key(session.ID)
+		},
+		ReadScan: func() string {
+			_, err := s.FilterSessions(ctx, "_session", func(*sessions.Session) bool { return true })
+			assert.Nil(t, err)
+			return s.key("")
+		},
+		ReadUserSessions: func() string {
+			_, err := s.UserSessions(ctx, "alice")
+			assert.Nil(t, err)
+			return s.userIndexPrefix("alice")
+		},
+		ReadAux: func() string {
+			_, err := s.GetAux(ctx, session, "csrf")
+			assert.Nil(t, err)
+			return s.auxPrefix(session.ID) + "csrf"
+		},
+	}
+
+	for op, fn := range read {
+		s.ReadConsistency = nil
+		serializable, ok := kv.wasSerializable(fn())
+		assert.True(t, ok, op)
+		assert.False(t, serializable, "linearizable by default: %d", op)
+
+		s.ReadConsistency = map[ReadOp]Consistency{op: Serializable}
+		for other, otherFn := range read {
+			serializable, _ := kv.wasSerializable(otherFn())
+			assert.Equal(t, other == op, serializable, "%d with %d serializable", other, op)
+		}
+	}
+
+	// saves read the user index linearizably whatever UserSessions uses
+	s.ReadConsistency = map[ReadOp]Consistency{ReadUserSessions: Serializable}
+	assert.Nil(t, s.save(ctx, session, false))
+	serializable, _ := kv.wasSerializable(s.userIndexPrefix("alice"))
+	assert.False(t, serializable)
+}
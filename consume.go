@@ -0,0 +1,100 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Consume deletes the session called name stored under id and returns it,
+// for single-use tokens stored as sessions, e.g. email verification or
+// magic links. The value is read and the key deleted by the same request,
+// so among concurrent calls for id only one gets the session: the others,
+// like calls for a missing or expired session, get an error wrapping
+// ErrNotFound. A consumed token can't be told apart from one that never
+// existed.
+//
+// Its auxiliary data, field keys and user index entry are deleted along
+// with it, and SoftDeleteGrace doesn't apply. The session is consumed even
+// if its value then fails to decode, which is reported as for New.
+func (s *EtcdStore) Consume(ctx context.Context, name, id string) (*sessions.Session, error) {
+	session, err := s.consume(ctx, name, id)
+	return session, s.sessionError("consume", name, err)
+}
+
+func (s *EtcdStore) consume(ctx context.Context, name, id string) (*sessions.Session, error) {
+	if err := s.checkPlainID(id); err != nil {
+		return nil, err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	session := sessions.NewSession(s, name)
+	session.ID = id
+	s.mu.RLock()
+	options := *s.Options
+	s.mu.RUnlock()
+	session.Options = &options
+
+	var value []byte
+	var fields []*mvccpb.KeyValue
+	if s.Buckets > 0 {
+		err := s.updateBucket(ctx, id, func(entries map[string]bucketEntry) error {
+			entry, ok := entries[id]
+			if !ok {
+				return fmt.Errorf("key: %s/%s is %w", s.bucketKey(id), id, ErrNotFound)
+			}
+			value = entry.Value
+			delete(entries, id)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		key := s.key(id)
+		s.uncache(key)
+		ops := []clientv3.Op{clientv3.OpDelete(key, clientv3.WithPrevKV())}
+		if s.fieldKeys() {
+			// read before the delete below, which it would otherwise see
+			ops = append(ops, clientv3.OpGet(s.fieldPrefix(id), clientv3.WithPrefix()))
+		}
+		ops = append(ops, clientv3.OpDelete(s.auxPrefix(id), clientv3.WithPrefix()))
+		resp, err := s.commit(s.kv.Txn(ctx).Then(ops...))
+		if err != nil {
+			return nil, err
+		}
+		deleted := resp.Responses[0].GetResponseDeleteRange()
+		if deleted.Deleted == 0 {
+			return nil, fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+		value = deleted.PrevKvs[0].Value
+		if s.fieldKeys() {
+			fields = resp.Responses[1].GetResponseRange().Kvs
+		}
+		s.logWrite(key, nil, resp.Header.Revision)
+	}
+	s.publish(EventDeleted, name, id)
+
+	if err := s.decode(name, id, value, &session.Values); err != nil {
+		return nil, err
+	}
+	if _, err := s.readFields(name, id, fields, session.Values); err != nil {
+		return nil, err
+	}
+	if userID, _ := session.Values[indexedUserKey].(string); userID != "" {
+		if _, err := s.del(ctx, s.userIndexKey(userID, id)); err != nil {
+			return nil, err
+		}
+	}
+	if s.AfterLoad != nil {
+		s.AfterLoad(session)
+	}
+	return session, nil
+}
@@ -0,0 +1,69 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_Consume(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+
+	token := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice", "email": "alice@example.com"})
+	assert.Nil(t, s.PutAux(ctx, token, "nonce", []byte("x")))
+	consumed, err := s.Consume(ctx, "_session", token.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice@example.com", consumed.Values["email"])
+	assert.Equal(t, token.ID, consumed.ID)
+
+	_, err = loadByID(s, token.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0, "index entry gone")
+	resp, err := s.Client.Get(ctx, s.auxPrefix(token.ID)+"nonce")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "aux data gone")
+
+	_, err = s.Consume(ctx, "_session", token.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "consumed once")
+}
+
+func TestEtcdStore_ConsumeConcurrently(t *testing.T) {
+	ctx := context.Background()
+	for _, buckets := range []int{0, 4} {
+		s := newTestStore(t)
+		s.Buckets = buckets
+		token := newSavedSession(t, s, map[interface{}]interface{}{"email": "bob@example.com"})
+
+		var wg sync.WaitGroup
+		results := make([]*sessions.Session, 8)
+		errs := make([]error, len(results))
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = s.Consume(ctx, "_session", token.ID)
+			}(i)
+		}
+		wg.Wait()
+
+		won := 0
+		for i, session := range results {
+			if errs[i] == nil {
+				won++
+				assert.Equal(t, "bob@example.com", session.Values["email"])
+			} else {
+				assert.True(t, errors.Is(errs[i], ErrNotFound), "buckets %d", buckets)
+				assert.Nil(t, session)
+			}
+		}
+		assert.Equal(t, 1, won, "buckets %d", buckets)
+	}
+}
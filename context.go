@@ -0,0 +1,60 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// contextKey keys a session in a context by its name. The type is
+// unexported, so keys set by other packages can never collide with it.
+type contextKey struct {
+	name string
+}
+
+// saveContext returns the context Save talks to etcd with: r's context,
+// also done when the store's Context is, or with PersistOnDisconnect the
+// store's Context bounded by PersistTimeout. Without a request, it is the
+// store's Context.
+func (s *EtcdStore) saveContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if r == nil {
+		return context.WithCancel(s.Context)
+	}
+	if s.PersistOnDisconnect {
+		timeout := s.PersistTimeout
+		if timeout <= 0 {
+			timeout = DefaultPersistTimeout
+		}
+		return context.WithTimeout(s.Context, timeout)
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline, ok := s.Context.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(r.Context(), deadline)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+	go func() {
+		select {
+		case <-s.Context.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// ContextWithSession returns a copy of ctx carrying session under its name.
+// Sessions with different names can be stored in the same context.
+func ContextWithSession(ctx context.Context, session *sessions.Session) context.Context {
+	return context.WithValue(ctx, contextKey{session.Name()}, session)
+}
+
+// SessionFromContext returns the session called name stored in ctx by
+// ContextWithSession.
+func SessionFromContext(ctx context.Context, name string) (*sessions.Session, bool) {
+	session, ok := ctx.Value(contextKey{name}).(*sessions.Session)
+	return session, ok
+}
@@ -0,0 +1,59 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionContext(t *testing.T) {
+	web := sessions.NewSession(store, "web")
+	admin := sessions.NewSession(store, "admin")
+
+	ctx := ContextWithSession(context.Background(), web)
+	ctx = ContextWithSession(ctx, admin)
+	// a plain string key with the same name doesn't interfere
+	ctx = context.WithValue(ctx, "web", "not a session")
+
+	session, ok := SessionFromContext(ctx, "web")
+	assert.True(t, ok)
+	assert.Same(t, web, session)
+
+	session, ok = SessionFromContext(ctx, "admin")
+	assert.True(t, ok)
+	assert.Same(t, admin, session)
+
+	session, ok = SessionFromContext(ctx, "api")
+	assert.False(t, ok)
+	assert.Nil(t, session)
+}
+
+func TestEtcdStore_PersistOnDisconnect(t *testing.T) {
+	s := newTestStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req = req.WithContext(ctx)
+
+	// by default the write is abandoned with the request
+	session := sessions.NewSession(s, "_session")
+	session.Options = &sessions.Options{MaxAge: 60}
+	session.Values["foo"] = "bar"
+	err = s.Save(req, httptest.NewRecorder(), session)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	s.PersistOnDisconnect = true
+	session.ID = ""
+	err = s.Save(req, httptest.NewRecorder(), session)
+	assert.Nil(t, err)
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+}
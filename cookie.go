@@ -0,0 +1,157 @@
+package etcdstore
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// maxCookieNameLength bounds session names so that the name alone can't eat
+// a significant part of the ~4KB browsers allow per cookie.
+const maxCookieNameLength = 256
+
+// maxCookieSize is the size of the name=value pair of a cookie browsers are
+// guaranteed to accept, per RFC 6265 section 6.1. They drop larger ones.
+const maxCookieSize = 4096
+
+// ErrCookieTooLarge is returned by Save when the session cookie would be
+// larger than browsers accept, e.g. because of a long session ID.
+var ErrCookieTooLarge = errors.New("etcdstore: session cookie too large")
+
+// checkCookieSize rejects a cookie browsers would drop.
+func checkCookieSize(name, value string) error {
+	if size := len(name) + 1 + len(value); size > maxCookieSize {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrCookieTooLarge, size, maxCookieSize)
+	}
+	return nil
+}
+
+// ErrInvalidCookieName is returned by New and Save for a session name that
+// isn't a valid RFC 6265 cookie name.
+var ErrInvalidCookieName = errors.New("etcdstore: invalid cookie name")
+
+// checkCookieName reports whether name is a non-empty RFC 6265 token (RFC
+// 2616 section 2.2) of at most maxCookieNameLength bytes.
+func checkCookieName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: empty", ErrInvalidCookieName)
+	case len(name) > maxCookieNameLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidCookieName, maxCookieNameLength)
+	}
+
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return fmt.Errorf("%w: illegal character %q in %q", ErrInvalidCookieName, c, name)
+		}
+	}
+	return nil
+}
+
+// ErrCookiePrefix is returned by Save for a session named with the __Host-
+// or __Secure- prefix whose Options break the rules of the prefix, since
+// browsers would reject the cookie.
+var ErrCookiePrefix = errors.New("etcdstore: cookie options don't fit the name prefix")
+
+// checkCookiePrefix enforces the rules of the cookie name prefixes: a
+// __Secure- cookie must be Secure, and a __Host- one also have Path "/" and
+// no Domain. Browsers match the prefixes regardless of case.
+func checkCookiePrefix(name string, options *sessions.Options) error {
+	hasPrefix := func(prefix string) bool {
+		return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+	}
+
+	switch {
+	case hasPrefix("__Host-"):
+		if !options.Secure || options.Path != "/" || options.Domain != "" {
+			return fmt.Errorf("%w: %s requires Secure, Path \"/\" and no Domain", ErrCookiePrefix, name)
+		}
+	case hasPrefix("__Secure-"):
+		if !options.Secure {
+			return fmt.Errorf("%w: %s requires Secure", ErrCookiePrefix, name)
+		}
+	}
+	return nil
+}
+
+// setCookie adds cookie to the Set-Cookie headers of w, replacing those of
+// an earlier save for the same name, path and domain unless AppendCookies
+// is set. Headers for other paths or domains are kept, e.g. the one
+// expiring a legacy cookie elsewhere. It sets nothing under DisableCookie
+// or for a nil w.
+func (s *EtcdStore) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	if w == nil || s.DisableCookie {
+		return
+	}
+	if s.AppendCookies {
+		http.SetCookie(w, cookie)
+		return
+	}
+
+	line := cookie.String()
+	if line == "" {
+		return
+	}
+	name, path, domain, _ := parseSetCookie(line)
+	header := w.Header()
+	lines := header["Set-Cookie"]
+	kept := lines[:0]
+	for _, other := range lines {
+		if n, p, d, ok := parseSetCookie(other); !ok || n != name || p != path || d != domain {
+			kept = append(kept, other)
+		}
+	}
+	header["Set-Cookie"] = append(kept, line)
+}
+
+// parseSetCookie returns what identifies the cookie set by a Set-Cookie
+// header line, as net/http parses it.
+func parseSetCookie(line string) (name, path, domain string, ok bool) {
+	cookies := (&http.Response{Header: http.Header{"Set-Cookie": {line}}}).Cookies()
+	if len(cookies) != 1 {
+		return "", "", "", false
+	}
+	c := cookies[0]
+	return c.Name, c.Path, strings.ToLower(strings.TrimPrefix(c.Domain, ".")), true
+}
+
+// cookieValue returns the cookie value carrying the session ID.
+func (s *EtcdStore) cookieValue(name, id string) (string, error) {
+	if s.InsecurePlainID {
+		return id, nil
+	}
+	return s.encodeID(name, id)
+}
+
+// idFromCookie is the inverse of cookieValue.
+func (s *EtcdStore) idFromCookie(name, value string, id *string) error {
+	if s.InsecurePlainID {
+		if err := s.checkPlainID(value); err != nil {
+			return err
+		}
+		*id = value
+		return nil
+	}
+	return s.decodeID(name, value, id)
+}
+
+// checkPlainID rejects a session ID taken as is from the client when empty,
+// containing the key delimiter or starting with reservedPrefix, so that it
+// can't reach keys outside the session's.
+func (s *EtcdStore) checkPlainID(id string) error {
+	if id == "" || strings.Contains(id, s.keyDelimiter) || strings.HasPrefix(id, reservedPrefix) {
+		return fmt.Errorf("etcdstore: invalid plain session id %q", id)
+	}
+	return nil
+}
+
+// warnPlainID logs, once per store, that InsecurePlainID is set.
+func (s *EtcdStore) warnPlainID() {
+	s.plainIDWarn.Do(func() {
+		log.Printf("etcdstore: WARNING: InsecurePlainID is enabled for prefix %s, session cookies can be forged; never use it in production", s.keyPrefix)
+	})
+}
@@ -0,0 +1,260 @@
+package etcdstore
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_InvalidCookieName(t *testing.T) {
+	for _, name := range []string{
+		"",
+		strings.Repeat("a", maxCookieNameLength+1),
+		"bad name",
+		"bad;name",
+		"bad\r\nSet-Cookie: x",
+		"bad=name",
+		"bäd",
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+		assert.Nil(t, err, "http new request")
+
+		session, err := store.New(req, name)
+		assert.True(t, errors.Is(err, ErrInvalidCookieName), name)
+		assert.NotNil(t, session)
+
+		rsp := httptest.NewRecorder()
+		err = store.Save(req, rsp, sessions.NewSession(store, name))
+		assert.True(t, errors.Is(err, ErrInvalidCookieName), name)
+		assert.Empty(t, rsp.Header().Values("Set-Cookie"))
+	}
+
+	assert.Nil(t, checkCookieName(strings.Repeat("a", maxCookieNameLength)))
+	assert.Nil(t, checkCookieName("__Host-session_1.v2"))
+}
+
+func TestEtcdStore_InsecurePlainID(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	base := newTestStore(t)
+	base.InsecurePlainID = true
+	assert.Zero(t, logged.Len())
+	s := base.WithPrefix(base.keyPrefix + "/plain")
+	assert.Equal(t, 1, strings.Count(logged.String(), "WARNING"), "warned as built")
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	session.Values["foo"] = "bar"
+	rsp := httptest.NewRecorder()
+	assert.Nil(t, s.Save(req, rsp, session))
+
+	cookies := rsp.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, session.ID, cookies[0].Value)
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(cookies[0])
+	loaded, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(&http.Cookie{Name: "_session", Value: "../other"})
+	_, err = s.New(req, "_session")
+	assert.NotNil(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(&http.Cookie{Name: "_session", Value: "_healthcheck"})
+	_, err = s.New(req, "_session")
+	assert.NotNil(t, err, "reserved for SelfTest")
+
+	assert.Equal(t, 1, strings.Count(logged.String(), "WARNING"), "not again on use")
+}
+
+func TestEtcdStore_IDExtractor(t *testing.T) {
+	s := newTestStore(t)
+	s.IDExtractor = func(r *http.Request, name string) (string, bool) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return token, token != ""
+	}
+	saved := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	// an API client with the raw ID in a header
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.Header.Set("Authorization", "Bearer "+saved.ID)
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "bar", session.Values["foo"])
+
+	// a browser with the cookie
+	rsp := httptest.NewRecorder()
+	assert.Nil(t, s.Save(req, rsp, session))
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(rsp.Result().Cookies()[0])
+	session, err = s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, saved.ID, session.ID)
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.Header.Set("Authorization", "Bearer ../other")
+	session, err = s.New(req, "_session")
+	assert.NotNil(t, err)
+	assert.True(t, session.IsNew)
+}
+
+func TestEtcdStore_CookieTooLarge(t *testing.T) {
+	s := newTestStore(t)
+	for _, codec := range s.Codecs {
+		codec.(*securecookie.SecureCookie).MaxLength(0)
+	}
+
+	for _, plain := range []bool{false, true} {
+		s.InsecurePlainID = plain
+		session := sessions.NewSession(s, "_session")
+		session.Options = &sessions.Options{MaxAge: 60}
+		session.ID = strings.Repeat("a", 4100)
+		session.Values["foo"] = "bar"
+
+		rsp := httptest.NewRecorder()
+		err := s.Save(nil, rsp, session)
+		assert.ErrorIs(t, err, ErrCookieTooLarge)
+		assert.Contains(t, err.Error(), "4096")
+		assert.Len(t, rsp.Result().Cookies(), 0)
+		_, err = loadByID(s, session.ID)
+		assert.ErrorIs(t, err, ErrNotFound, "nothing stored")
+	}
+
+	// up to the limit is fine
+	s.InsecurePlainID = true
+	session := sessions.NewSession(s, "_session")
+	session.Options = &sessions.Options{MaxAge: 60}
+	session.ID = strings.Repeat("a", maxCookieSize-len("_session="))
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+}
+
+func TestEtcdStore_CookiePrefix(t *testing.T) {
+	s := newTestStore(t)
+	for _, tt := range []struct {
+		name    string
+		options sessions.Options
+		ok      bool
+	}{
+		{"__Host-sid", sessions.Options{Path: "/", Secure: true}, true},
+		{"__host-sid", sessions.Options{Path: "/", Secure: true}, true},
+		{"__Host-sid", sessions.Options{Path: "/"}, false},
+		{"__Host-sid", sessions.Options{Path: "/app", Secure: true}, false},
+		{"__Host-sid", sessions.Options{Path: "/", Domain: "example.com", Secure: true}, false},
+		{"__Secure-sid", sessions.Options{Path: "/app", Domain: "example.com", Secure: true}, true},
+		{"__SECURE-sid", sessions.Options{Path: "/"}, false},
+		{"sid", sessions.Options{Path: "/"}, true},
+	} {
+		session := sessions.NewSession(s, tt.name)
+		options := tt.options
+		options.MaxAge = 60
+		session.Options = &options
+		w := httptest.NewRecorder()
+		err := s.Save(nil, w, session)
+		if tt.ok {
+			assert.Nil(t, err, tt.name)
+			assert.NotEmpty(t, w.Header().Get("Set-Cookie"), tt.name)
+		} else {
+			assert.True(t, errors.Is(err, ErrCookiePrefix), tt.name)
+			assert.Empty(t, w.Header().Get("Set-Cookie"), tt.name)
+			assert.Equal(t, "", session.ID, "nothing stored")
+		}
+	}
+}
+
+func TestEtcdStore_RepeatedSave(t *testing.T) {
+	s := newTestStore(t)
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	http.SetCookie(w, &http.Cookie{Name: "other", Value: "1"})
+
+	session, err := s.New(r, "sid")
+	assert.Nil(t, err)
+	session.Values["step"] = "middleware"
+	assert.Nil(t, s.Save(r, w, session))
+	session.Values["step"] = "handler"
+	assert.Nil(t, s.Save(r, w, session))
+
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "other", cookies[0].Name)
+		assert.Equal(t, "sid", cookies[1].Name)
+	}
+
+	s.AppendCookies = true
+	assert.Nil(t, s.Save(r, w, session))
+	assert.Len(t, w.Header()["Set-Cookie"], 3)
+}
+
+func TestEtcdStore_TokenInBody(t *testing.T) {
+	s := newTestStore(t)
+	s.TokenExtractor = func(r *http.Request, name string) (string, bool) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return token, token != ""
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err)
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	session.Values["foo"] = "bar"
+	token, err := s.SaveToken(req, session)
+	assert.Nil(t, err)
+	var id string
+	assert.Nil(t, securecookie.DecodeMulti("_session", token, &id, s.Codecs...))
+	assert.Equal(t, session.ID, id, "the cookie's value")
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	loaded, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	// Save sets no cookie either under DisableCookie
+	s.DisableCookie = true
+	w := httptest.NewRecorder()
+	loaded.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(req, w, loaded))
+	assert.Empty(t, w.Header()["Set-Cookie"])
+	reloaded, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", reloaded.Values["foo"])
+
+	reloaded.Options.MaxAge = -1
+	token, err = s.SaveToken(req, reloaded)
+	assert.Nil(t, err)
+	assert.Empty(t, token, "deleted")
+	assert.Empty(t, w.Header()["Set-Cookie"])
+	_, err = loadByID(s, session.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// a token that doesn't decode is an error, as a bad cookie would be
+	req.Header.Set("Authorization", "Bearer forged")
+	_, err = s.New(req, "_session")
+	assert.NotNil(t, err)
+}
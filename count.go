@@ -0,0 +1,73 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// ErrSessionQuotaExceeded is returned when creating a session while
+// MaxSessions sessions are stored.
+var ErrSessionQuotaExceeded = errors.New("etcdstore: session quota exceeded")
+
+// sessionCount is the count of sessions cached for SessionCountTTL.
+type sessionCount struct {
+	mu      sync.Mutex
+	count   int64
+	expires time.Time
+}
+
+// CountSessions returns how many sessions are stored under the prefix,
+// leaving out the store's reserved keys and auxiliary data, and so the
+// sessions kept in Buckets.
+//
+// Like FilterSessions, it pages through every key under the prefix, though
+// without fetching the values.
+func (s *EtcdStore) CountSessions(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.scan(ctx, func(*mvccpb.KeyValue) error {
+		count++
+		return nil
+	}, s.readOpts(ReadScan, clientv3.WithKeysOnly())...)
+	return count, err
+}
+
+// checkSessionQuota returns ErrSessionQuotaExceeded if MaxSessions is set
+// and reached, before a session is created.
+func (s *EtcdStore) checkSessionQuota(ctx context.Context) error {
+	if s.MaxSessions <= 0 {
+		return nil
+	}
+
+	s.sessionCount.mu.Lock()
+	defer s.sessionCount.mu.Unlock()
+
+	now := s.clock()
+	if !now.Before(s.sessionCount.expires) {
+		count, err := s.CountSessions(ctx)
+		if err != nil {
+			return err
+		}
+		s.sessionCount.count, s.sessionCount.expires = count, now.Add(s.SessionCountTTL)
+	}
+	if s.sessionCount.count >= int64(s.MaxSessions) {
+		return fmt.Errorf("%w: %d sessions", ErrSessionQuotaExceeded, s.sessionCount.count)
+	}
+	return nil
+}
+
+// countCreated adds a session created since the count was cached.
+func (s *EtcdStore) countCreated() {
+	if s.MaxSessions <= 0 {
+		return
+	}
+
+	s.sessionCount.mu.Lock()
+	s.sessionCount.count++
+	s.sessionCount.mu.Unlock()
+}
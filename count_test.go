@@ -0,0 +1,76 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_MaxSessions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.MaxSessions = 2
+
+	// below the limit
+	first := newSavedSession(t, s, nil)
+	assert.Nil(t, s.PutAux(ctx, first, "token", []byte("t0k3n")), "not counted")
+	second := newSavedSession(t, s, nil)
+	count, err := s.CountSessions(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// at the limit, new sessions are refused but existing ones saved
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	err = s.Save(req, httptest.NewRecorder(), session)
+	assert.True(t, errors.Is(err, ErrSessionQuotaExceeded))
+	assert.Equal(t, "", session.ID, "nothing stored")
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), second))
+
+	// above it, once lowered
+	s.MaxSessions = 1
+	session, err = s.New(req, "_session")
+	assert.Nil(t, err)
+	err = s.Save(req, httptest.NewRecorder(), session)
+	assert.True(t, errors.Is(err, ErrSessionQuotaExceeded))
+
+	// deleting makes room
+	second.Options.MaxAge = -1
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), second))
+	s.MaxSessions = 2
+	newSavedSession(t, s, nil)
+}
+
+func TestEtcdStore_SessionCountTTL(t *testing.T) {
+	s := newTestStore(t)
+	s.MaxSessions = 2
+	s.SessionCountTTL = time.Minute
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+
+	// the cached count follows the store's own creations
+	newSavedSession(t, s, nil)
+	newSavedSession(t, s, nil)
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	err = s.Save(nil, httptest.NewRecorder(), session)
+	assert.True(t, errors.Is(err, ErrSessionQuotaExceeded))
+
+	// and is counted again once stale
+	_, err = store.Client.Delete(context.Background(), s.key(""), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	err = s.Save(nil, httptest.NewRecorder(), session)
+	assert.True(t, errors.Is(err, ErrSessionQuotaExceeded), "cached")
+	now = now.Add(time.Minute)
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+}
@@ -0,0 +1,47 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+)
+
+// CreateIfAbsent stores session under its ID unless a session already
+// holds it, in a single transaction, and reports whether it did. This
+// makes creation idempotent, e.g. for a login endpoint retried with the
+// same ID: of concurrent calls for an ID, exactly one creates the session,
+// and the others leave the stored session as it is.
+//
+// The ID is used as is, so it must be a valid plain ID: not empty, without
+// the key delimiter and not starting with "_". Its MaxAge must be positive.
+// No cookie is set; Save the session afterwards to send one.
+func (s *EtcdStore) CreateIfAbsent(ctx context.Context, session *sessions.Session) (bool, error) {
+	created, err := s.createIfAbsent(ctx, session)
+	return created, s.sessionError("create", session.Name(), err)
+}
+
+func (s *EtcdStore) createIfAbsent(ctx context.Context, session *sessions.Session) (bool, error) {
+	if err := s.checkPlainID(session.ID); err != nil {
+		return false, err
+	}
+	if session.Options.MaxAge <= 0 {
+		return false, fmt.Errorf("etcdstore: can't create a session with MaxAge %d", session.Options.MaxAge)
+	}
+
+	if err := s.checkSessionQuota(ctx); err != nil {
+		return false, err
+	}
+
+	err := s.save(ctx, session, true)
+	if errors.Is(err, ErrIDCollision) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.countCreated()
+	s.publish(EventCreated, session.Name(), session.ID)
+	return true, nil
+}
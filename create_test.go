@@ -0,0 +1,89 @@
+package etcdstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_CreateIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	newSession := func(id, user string) *sessions.Session {
+		session := sessions.NewSession(s, "_session")
+		session.Options = &sessions.Options{MaxAge: 60}
+		session.ID = id
+		session.Values["user"] = user
+		return session
+	}
+
+	created, err := s.CreateIfAbsent(ctx, newSession("login-1", "alice"))
+	assert.Nil(t, err)
+	assert.True(t, created)
+	created, err = s.CreateIfAbsent(ctx, newSession("login-1", "bob"))
+	assert.Nil(t, err)
+	assert.False(t, created)
+	loaded, err := loadByID(s, "login-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", loaded.Values["user"], "left as it is")
+
+	before, err := s.Client.Leases(ctx)
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		created, err := s.CreateIfAbsent(ctx, newSession("login-1", "bob"))
+		assert.Nil(t, err)
+		assert.False(t, created)
+	}
+	after, err := s.Client.Leases(ctx)
+	assert.Nil(t, err)
+	// leeway for the tests of other packages running meanwhile
+	assert.InDelta(t, len(before.Leases), len(after.Leases), 5, "the losers' leases revoked")
+
+	for _, id := range []string{"", "a/b", "_users"} {
+		_, err := s.CreateIfAbsent(ctx, newSession(id, "alice"))
+		assert.NotNil(t, err, id)
+	}
+	expired := newSession("login-2", "alice")
+	expired.Options.MaxAge = 0
+	_, err = s.CreateIfAbsent(ctx, expired)
+	assert.NotNil(t, err)
+}
+
+func TestEtcdStore_CreateIfAbsentRace(t *testing.T) {
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+
+	const racers = 8
+	var wg sync.WaitGroup
+	wins := make(chan int, racers)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := sessions.NewSession(s, "_session")
+			session.Options = &sessions.Options{MaxAge: 60}
+			session.ID = "racy"
+			session.Values["user"] = "alice"
+			session.Values["racer"] = i
+			created, err := s.CreateIfAbsent(context.Background(), session)
+			assert.Nil(t, err)
+			if created {
+				wins <- i
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(wins)
+
+	assert.Len(t, wins, 1, "exactly one wins")
+	loaded, err := loadByID(s, "racy")
+	assert.Nil(t, err)
+	assert.Equal(t, <-wins, loaded.Values["racer"])
+	ids, err := s.UserSessions(context.Background(), "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"racy"}, ids)
+}
@@ -0,0 +1,44 @@
+package etcdstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// stampCreated records the creation time of a session saved for the first
+// time under TrackCreation.
+func (s *EtcdStore) stampCreated(session *sessions.Session) {
+	if !s.TrackCreation {
+		return
+	}
+	if _, ok := session.Values[createdKey]; !ok {
+		session.Values[createdKey] = s.clock().UnixNano()
+	}
+}
+
+// CreatedAt returns when session was first saved, if TrackCreation was set
+// at the time.
+func CreatedAt(session *sessions.Session) (time.Time, bool) {
+	created, ok := session.Values[createdKey].(int64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, created), true
+}
+
+// ListSessionIDsCreatedBetween returns the IDs of the sessions called name
+// created at or after from and before to, for targeted investigations.
+// Only sessions saved under TrackCreation carry a creation time; the others
+// are left out.
+//
+// etcd keys aren't ordered by time, so like FilterSessions this pages
+// through every key under the prefix and decodes each value, and it stops
+// early when ctx is cancelled.
+func (s *EtcdStore) ListSessionIDsCreatedBetween(ctx context.Context, name string, from, to time.Time) ([]string, error) {
+	return s.FilterSessions(ctx, name, func(session *sessions.Session) bool {
+		created, ok := CreatedAt(session)
+		return ok && !created.Before(from) && created.Before(to)
+	})
+}
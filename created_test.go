@@ -0,0 +1,44 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_ListSessionIDsCreatedBetween(t *testing.T) {
+	s := newTestStore(t)
+	s.TrackCreation = true
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	early := newSavedSession(t, s, nil)
+	now = now.Add(time.Hour)
+	middle := newSavedSession(t, s, nil)
+	created, ok := CreatedAt(middle)
+	assert.True(t, ok)
+	assert.Equal(t, now.UnixNano(), created.UnixNano())
+
+	// saving again keeps the creation time
+	now = now.Add(time.Hour)
+	assert.Nil(t, s.save(context.Background(), middle, false))
+	late := newSavedSession(t, s, nil)
+	s.TrackCreation = false
+	newSavedSession(t, s, nil)
+
+	ctx := context.Background()
+	ids, err := s.ListSessionIDsCreatedBetween(ctx, "_session", now.Add(-90*time.Minute), now)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{middle.ID}, ids)
+
+	ids, err = s.ListSessionIDsCreatedBetween(ctx, "_session", now.Add(-2*time.Hour), now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{early.ID, middle.ID, late.ID}, ids)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.ListSessionIDsCreatedBetween(cancelled, "_session", now.Add(-2*time.Hour), now)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,87 @@
+package etcdstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"github.com/gorilla/sessions"
+)
+
+// csrfSuffix is the auxiliary data suffix of a session's anti-CSRF secret.
+const csrfSuffix = reservedPrefix + "csrf"
+
+const (
+	csrfSecretLength = 32
+	csrfNonceLength  = 16
+)
+
+// ErrInvalidCSRFToken is returned by VerifyCSRFToken for a token that
+// wasn't issued by CSRFToken for the session.
+var ErrInvalidCSRFToken = errors.New("etcdstore: invalid csrf token")
+
+// GenerateCSRFSecret creates a random anti-CSRF secret for session, stores
+// it as auxiliary data, so that it expires and is deleted along with the
+// session, and returns it. A previous secret is replaced, which invalidates
+// the tokens derived from it. As with PutAux, session must be the saved one.
+func (s *EtcdStore) GenerateCSRFSecret(ctx context.Context, session *sessions.Session) ([]byte, error) {
+	secret := generateRandomKey(csrfSecretLength)
+	if len(secret) < csrfSecretLength {
+		return nil, ErrEntropyFailure
+	}
+	if err := s.putAux(ctx, session, csrfSuffix, secret); err != nil {
+		return nil, s.sessionError("aux", session.Name(), err)
+	}
+	return secret, nil
+}
+
+// CSRFSecret returns the anti-CSRF secret of session, or an error wrapping
+// ErrNotFound if GenerateCSRFSecret wasn't called for it.
+func (s *EtcdStore) CSRFSecret(ctx context.Context, session *sessions.Session) ([]byte, error) {
+	secret, err := s.getAux(ctx, session, csrfSuffix)
+	return secret, s.sessionError("aux", session.Name(), err)
+}
+
+// CSRFToken returns a token for a form of session, to be checked with
+// VerifyCSRFToken when the form is posted. Each call returns a different
+// token: a random nonce followed by its HMAC-SHA256 under the session's
+// secret, base64 encoded for URLs, which keeps the secret itself from being
+// recovered through compression side channels.
+func (s *EtcdStore) CSRFToken(ctx context.Context, session *sessions.Session) (string, error) {
+	secret, err := s.CSRFSecret(ctx, session)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := generateRandomKey(csrfNonceLength)
+	if len(nonce) < csrfNonceLength {
+		return "", ErrEntropyFailure
+	}
+	return base64.RawURLEncoding.EncodeToString(append(nonce, csrfMAC(secret, nonce)...)), nil
+}
+
+// VerifyCSRFToken checks that token was returned by CSRFToken for session,
+// under its current secret. It returns ErrInvalidCSRFToken if not.
+func (s *EtcdStore) VerifyCSRFToken(ctx context.Context, session *sessions.Session, token string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != csrfNonceLength+sha256.Size {
+		return ErrInvalidCSRFToken
+	}
+
+	secret, err := s.CSRFSecret(ctx, session)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(raw[csrfNonceLength:], csrfMAC(secret, raw[:csrfNonceLength])) {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+func csrfMAC(secret, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
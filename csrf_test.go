@@ -0,0 +1,53 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_CSRF(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	_, err := s.CSRFToken(ctx, session)
+	assert.ErrorIs(t, err, ErrNotFound, "no secret yet")
+
+	secret, err := s.GenerateCSRFSecret(ctx, session)
+	assert.Nil(t, err)
+	assert.Len(t, secret, csrfSecretLength)
+	stored, err := s.CSRFSecret(ctx, session)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, stored)
+
+	token, err := s.CSRFToken(ctx, session)
+	assert.Nil(t, err)
+	other, err := s.CSRFToken(ctx, session)
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, other)
+	assert.Nil(t, s.VerifyCSRFToken(ctx, session, token))
+	assert.Nil(t, s.VerifyCSRFToken(ctx, session, other))
+
+	tampered := []byte(token)
+	tampered[len(tampered)-1] ^= 1
+	for _, bad := range []string{"", "not base64!", string(tampered), token[:len(token)-4]} {
+		assert.ErrorIs(t, s.VerifyCSRFToken(ctx, session, bad), ErrInvalidCSRFToken, bad)
+	}
+
+	// a new secret invalidates the tokens derived from the old one
+	_, err = s.GenerateCSRFSecret(ctx, session)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, s.VerifyCSRFToken(ctx, session, token), ErrInvalidCSRFToken)
+
+	// the secret expires with the session's lease
+	token, err = s.CSRFToken(ctx, session)
+	assert.Nil(t, err)
+	resp, err := s.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	_, err = s.Client.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+	assert.Nil(t, err)
+	assert.ErrorIs(t, s.VerifyCSRFToken(ctx, session, token), ErrNotFound)
+}
@@ -1,4 +1,40 @@
 /*
 Package etcdstore is a session store backend for gorilla/sessions
+
+Session values go through the securecookie.Serializer of the codecs, which
+StoreConfig.Serializer sets:
+
+  - securecookie.GobEncoder, the default, keeps the Go types of values.
+    Types other than gob's basic ones must be registered with gob.Register,
+    custom structs for instance; the store registers time.Time itself.
+  - securecookie.JSONEncoder turns numbers into float64 and time.Time into
+    strings, and with them the int64 values the store keeps under
+    ReservedValuesPrefix, which breaks IdleTimeout, TrackCreation and
+    LeaseID.
+  - JSONSerializer writes JSON too, but keeps the types registered with
+    RegisterJSON, and lets JSONSerializer.Hooks convert specific keys.
+  - FallbackSerializer writes with one of them and reads with it or older
+    ones, to migrate stored values from one to another.
+
+EtcdStore.RedistoreFormat bypasses the codecs, storing values as redistore
+does, with RedistoreGobSerializer or RedistoreJSONSerializer, to share them
+with a redistore while migrating.
+
+Otherwise a value is stored through these stages, always in this order:
+
+ 1. the values are serialized and, under EtcdStore.Compression, compressed,
+    prefixed with the compression byte, whole or by CompressKeys;
+ 2. the codecs serialize that, encrypt it if they have a block key, sign it
+    and base64 encode it, as securecookie.EncodeMulti does;
+ 3. EtcdStore.Checksum appends a CRC32 after a '.';
+ 4. EtcdStore.Padding appends '~' characters.
+
+Compression thus always comes before encryption, on data that still
+compresses and without its size revealing the plaintext through the
+ciphertext. Loading undoes the stages in reverse, each one told by the
+value itself, the separators, the compression byte and the codecs'
+signature, rather than by the current settings: a value written with any
+of them enabled loads once they are disabled, as long as the codecs still
+accept it.
 */
 package etcdstore
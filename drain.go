@@ -0,0 +1,64 @@
+package etcdstore
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned for operations started after CloseGraceful.
+	ErrClosed = errors.New("etcdstore: store is closed")
+
+	// ErrDrainTimeout is returned by CloseGraceful when operations were still
+	// running at the deadline. The client is closed regardless.
+	ErrDrainTimeout = errors.New("etcdstore: timed out waiting for in-flight operations")
+)
+
+// inflight tracks the loads, saves and deletes in progress.
+type inflight struct {
+	mu      sync.RWMutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// begin registers an operation, which must call the returned func when
+// done. It fails once the store is closing.
+func (f *inflight) begin() (func(), error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closing {
+		return nil, ErrClosed
+	}
+	f.wg.Add(1)
+	return f.wg.Done, nil
+}
+
+// CloseGraceful stops accepting new loads, saves and deletes, waits up to
+// timeout for those in progress and then closes the client like Close, so
+// that a rolling restart doesn't abort session writes. It returns
+// ErrDrainTimeout if some were still running.
+func (s *EtcdStore) CloseGraceful(timeout time.Duration) error {
+	s.inflight.mu.Lock()
+	s.inflight.closing = true
+	s.inflight.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		err = ErrDrainTimeout
+	}
+
+	if closeErr := s.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_CloseGraceful(t *testing.T) {
+	s := newTestStore(t)
+	started := make(chan struct{}, 1)
+	s.kv = slowKV{KV: s.kv, delay: 200 * time.Millisecond, started: started}
+
+	session := sessions.NewSession(s, "_session")
+	session.ID = "draining"
+	session.Options = &sessions.Options{MaxAge: 60}
+	session.Values["foo"] = "bar"
+
+	saved := make(chan error, 1)
+	go func() { saved <- s.save(context.Background(), session, false) }()
+	<-started
+
+	assert.Nil(t, s.CloseGraceful(5*time.Second))
+	assert.Nil(t, <-saved, "the save in progress completed")
+
+	_, err := loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrClosed), "new operations are refused")
+
+	// s is closed now, check with the shared client
+	resp, err := store.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+}
+
+func TestEtcdStore_CloseGracefulTimeout(t *testing.T) {
+	s := newTestStore(t)
+	started := make(chan struct{}, 1)
+	s.kv = slowKV{KV: s.kv, delay: time.Second, started: started}
+
+	session := sessions.NewSession(s, "_session")
+	session.ID = "slow"
+	session.Options = &sessions.Options{MaxAge: 60}
+
+	go s.save(context.Background(), session, false)
+	<-started
+
+	assert.Equal(t, ErrDrainTimeout, s.CloseGraceful(10*time.Millisecond))
+}
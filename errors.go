@@ -0,0 +1,45 @@
+package etcdstore
+
+import "fmt"
+
+// SessionError is the error returned by the store's per-session methods.
+// It tells which store and session failed when several stores share a
+// request, e.g. in a sessions.MultiError from sessions.Save. The underlying
+// error often names the session's key, which holds its ID and so lets
+// anyone reading it take the session over: keep these errors out of logs
+// shared more widely than the store itself.
+//
+// Test for the underlying cause with errors.Is and errors.As; a codec
+// failure, for instance, is found with errors.As and a securecookie.Error.
+type SessionError struct {
+	// Op is what failed: "load", "save", "move", "touch", "incr", "aux",
+	// "reset", "expire", "create", "keepalive", "cas", "reassign" or
+	// "consume".
+	Op string
+
+	// Prefix is the store's key prefix and Name the session name.
+	Prefix string
+	Name   string
+
+	Err error
+}
+
+func (e *SessionError) Error() string {
+	return fmt.Sprintf("etcdstore: %s session %q under %s: %v", e.Op, e.Name, e.Prefix, e.Err)
+}
+
+func (e *SessionError) Unwrap() error {
+	return e.Err
+}
+
+// sessionError wraps err, if any, in a SessionError for the session called
+// name. Errors that already are one are returned as is.
+func (s *EtcdStore) sessionError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*SessionError); ok {
+		return err
+	}
+	return &SessionError{Op: op, Prefix: s.keyPrefix, Name: name, Err: err}
+}
@@ -0,0 +1,64 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionError(t *testing.T) {
+	s := newTestStore(t)
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(&http.Cookie{Name: "_session", Value: "forged"})
+	_, err = s.New(req, "_session")
+
+	var sessionErr *SessionError
+	assert.True(t, errors.As(err, &sessionErr))
+	assert.Equal(t, "load", sessionErr.Op)
+	assert.Equal(t, s.keyPrefix, sessionErr.Prefix)
+	assert.Equal(t, "_session", sessionErr.Name)
+	assert.True(t, strings.Contains(err.Error(), s.keyPrefix), err.Error())
+	assert.True(t, strings.Contains(err.Error(), `"_session"`), err.Error())
+
+	var cookieErr securecookie.Error
+	assert.True(t, errors.As(err, &cookieErr), "cause still reachable")
+	assert.True(t, cookieErr.IsDecode())
+
+	session := sessions.NewSession(s, "_session")
+	session.ID = "missing"
+	err = s.Touch(context.Background(), session)
+	assert.True(t, errors.As(err, &sessionErr))
+	assert.Equal(t, "touch", sessionErr.Op)
+	_, err = s.IncrValue(context.Background(), session, "n", 1)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.As(err, &sessionErr))
+	assert.Equal(t, "incr", sessionErr.Op)
+}
+
+func TestSessionError_MultiStore(t *testing.T) {
+	first, second := newTestStore(t), newTestStore(t)
+	second.kv = &faultyKV{KV: second.kv, err: errors.New("quota exceeded")}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	_, err = first.Get(req, "first")
+	assert.Nil(t, err)
+	_, err = second.Get(req, "second")
+	assert.Nil(t, err)
+
+	err = sessions.Save(req, httptest.NewRecorder())
+	var errMulti sessions.MultiError
+	assert.True(t, errors.As(err, &errMulti))
+	assert.Len(t, errMulti, 1)
+	// gorilla flattens the error into its own message
+	assert.True(t, strings.HasSuffix(errMulti[0].Error(), `etcdstore: save session "second" under `+second.keyPrefix+": quota exceeded"), errMulti[0].Error())
+}
@@ -3,15 +3,61 @@ package etcdstore
 import (
 	"context"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
 )
 
+var (
+	// ErrNotFound is returned when a session key does not exist in etcd.
+	ErrNotFound = errors.New("not found in etcd")
+
+	// ErrUnavailable is returned by New when StrictLoad is set and etcd
+	// could not be queried for the session.
+	ErrUnavailable = errors.New("etcdstore: etcd is unavailable")
+
+	// ErrInvalidOpOption is returned when GetOptions or PutOptions hold an
+	// option that can't apply to a single session key.
+	ErrInvalidOpOption = errors.New("etcdstore: invalid etcd op option")
+
+	// ErrInvalidKeyDelimiter is returned by SetKeyDelimiter.
+	ErrInvalidKeyDelimiter = errors.New("etcdstore: invalid key delimiter")
+
+	// ErrEntropyFailure is returned by Save when the system's random number
+	// generator failed to produce a session ID.
+	ErrEntropyFailure = errors.New("etcdstore: failed to generate random session id")
+
+	// ErrIDCollision is returned by Save under RejectIDCollision when every
+	// ID generated for a new session was already taken.
+	ErrIDCollision = errors.New("etcdstore: session id already taken")
+
+	// errCorruptDeleted is returned by load after it removed an undecodable
+	// value under DeleteCorrupt.
+	errCorruptDeleted = errors.New("etcdstore: corrupt session deleted")
+)
+
+const (
+	// DefaultDialKeepAliveTime is the gRPC keepalive ping interval used when
+	// the config passed to NewEtcdStore leaves DialKeepAliveTime unset.
+	DefaultDialKeepAliveTime = 30 * time.Second
+
+	// DefaultDialKeepAliveTimeout is how long a keepalive ping may go
+	// unanswered before the connection is considered dead.
+	DefaultDialKeepAliveTimeout = 10 * time.Second
+
+	// DefaultPersistTimeout bounds Save under PersistOnDisconnect when
+	// PersistTimeout is unset.
+	DefaultPersistTimeout = 10 * time.Second
+)
+
 // EtcdStore stores sessions in a etcd backend.
 type EtcdStore struct {
 	Client  *clientv3.Client
@@ -19,83 +65,957 @@ type EtcdStore struct {
 	Codecs  []securecookie.Codec
 	Options *sessions.Options
 
-	keyPrefix string
+	// GetOptions are appended to the Get that loads a session, e.g.
+	// clientv3.WithSerializable(). Range options (WithPrefix, WithFromKey,
+	// WithRange) and WithKeysOnly/WithCountOnly are rejected.
+	GetOptions []clientv3.OpOption
+
+	// ReadConsistency picks the consistency of each read path. All of them
+	// are Linearizable by default; aggregates that may be a little stale,
+	// such as FilterSessions for an admin view, can be made Serializable,
+	// while security checks loading a session should stay linearizable, or
+	// they may accept a session just deleted.
+	ReadConsistency map[ReadOp]Consistency
+
+	// PutOptions are appended to the Put that saves a session. Only options
+	// valid for a single-key Put are accepted; the session lease always
+	// overrides WithLease, so don't pass WithLease or WithIgnoreLease.
+	PutOptions []clientv3.OpOption
+
+	// MergeValues is called by MoveSession when the destination ID already
+	// holds a session, to fold the moved values in src into dst; dst is then
+	// stored. When nil, the moved values replace the destination's.
+	MergeValues func(dst, src map[interface{}]interface{})
+
+	// StrictLoad makes New return a nil session when etcd can't be reached,
+	// instead of a fresh session alongside the error. Missing keys and
+	// undecodable values still yield a new session.
+	StrictLoad bool
+
+	// Compression, if set, compresses values that serialize to more than
+	// CompressThreshold bytes before handing them to the codecs. Values
+	// record their compression, so changing it keeps older values readable.
+	//
+	// CompressKeys, if set along with Compression, compresses the values
+	// under those keys alone, each one serializing to more than
+	// CompressThreshold bytes on its own, and leaves the others as they
+	// are: a large, rarely used value then doesn't slow down reading the
+	// small ones, and they stay readable with the codecs alone. Such a
+	// value is replaced by an etcdstore.compressedField, written by
+	// JSONSerializer as {"t":"etcdstore.compressedField","v":{"Data":...}}
+	// with Data the base64 of the compression byte and the compressed
+	// serialization of the value alone under its key.
+	Compression       Compression
+	CompressThreshold int
+	CompressKeys      []string
+
+	// IdleTimeout, when positive, expires sessions that haven't been loaded
+	// or saved for that long, independently of their lease. The last access
+	// time is kept in the session values and refreshed on every load, which
+	// under Buckets rewrites the session's bucket.
+	IdleTimeout time.Duration
+
+	// SkewTolerance is added to the timestamp-based expiries, IdleTimeout,
+	// bucketed sessions included, and the expiry of bucketed sessions, so
+	// that a node whose clock runs slightly ahead of the one that stamped a
+	// session doesn't expire it early. It delays those expiries by as much
+	// on the other nodes. It complements lease-based expiry, which etcd
+	// measures on its own clock and isn't affected.
+	SkewTolerance time.Duration
+
+	// FieldKeys stores each session value under a string key, other than
+	// the store's own, in a key of its own next to the session's,
+	// {key}{delimiter}_field{delimiter}{values key}, on the session's lease.
+	// Save then writes only the values changed since the session was loaded
+	// or last saved, moves the others to its new lease without sending them
+	// again and deletes those removed; a load ranges over them after
+	// reading the session's key, which keeps the other values. This suits
+	// large sessions of which each request changes little. Every value goes
+	// through the stages of the package documentation on its own, and is
+	// told changed by its serialized form, which JSONSerializer keeps
+	// stable: gob may rewrite unchanged values holding maps.
+	//
+	// The price is a key per value, so that many more keys for etcd to index,
+	// and a second Get per load. It can be turned on for an existing store,
+	// whose sessions move to field keys as they are saved, but not off, as
+	// their values would be lost. It doesn't apply to Buckets or
+	// RedistoreFormat, and StaleWhileUnavailable doesn't serve its sessions,
+	// whose field keys aren't cached.
+	FieldKeys bool
+
+	// TrackCreation records in the values of every new session the time it
+	// was first saved, for CreatedAt and ListSessionIDsCreatedBetween.
+	TrackCreation bool
+
+	// DeleteCorrupt makes New delete a stored value it can't decode and
+	// start a fresh session instead of returning the decode error, so a user
+	// isn't stuck behind a truncated or tampered value.
+	DeleteCorrupt bool
+
+	// OnCorrupt, if set, is called with the key of every value removed
+	// because of DeleteCorrupt.
+	OnCorrupt func(key string)
+
+	// BreakerThreshold, when positive, enables a circuit breaker that opens
+	// after that many consecutive failed etcd requests. While open, requests
+	// fail fast with ErrCircuitOpen until BreakerCooldown has passed. A
+	// request taking longer than BreakerLatency, when positive, counts as
+	// failed even if it succeeds, so that a struggling cluster trips the
+	// breaker before timing out. etcd not knowing a lease isn't a failure.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	BreakerLatency   time.Duration
+
+	// OnQuotaExceeded, if set, is called with the etcd error when Save
+	// fails with ErrStorageFull, e.g. to page an operator or shed load.
+	OnQuotaExceeded func(err error)
+
+	// Padding, if set, pads every stored value to the size it returns, e.g.
+	// PadToMultiple(1024) or PadToPowerOfTwo, so that anyone reading etcd
+	// can't tell sessions apart by size, such as logged in or not. The
+	// padding follows the encoded value and the checksum, and is stripped
+	// on load. It costs etcd storage and bandwidth, up to the quantum or
+	// double the size, so pick the coarsest size classes that hide enough.
+	Padding Padding
+
+	// Checksum appends a CRC32 of the encoded value when saving, so that
+	// storage-level corruption is reported as ErrChecksumMismatch on load.
+	// It guards against bit rot, not tampering: use an authenticated codec
+	// for that. Checksummed values are verified even once Checksum is unset.
+	Checksum bool
+
+	// LeasePoolSize, when positive, keeps that many leases for the default
+	// MaxAge granted ahead of time and refilled in the background, saving a
+	// Grant round-trip on Save. A pooled lease may be up to a minute old
+	// when drawn, so such sessions can expire that much earlier than MaxAge.
+	// Unused leases are revoked on Close.
+	LeasePoolSize int
+
+	// PersistOnDisconnect makes Save write to etcd even after the client
+	// went away and the request's context was cancelled, so state recorded
+	// by a partially handled request isn't lost. The write then runs under
+	// the store's Context with PersistTimeout (DefaultPersistTimeout if
+	// zero) instead, which means a slow etcd can keep a handler busy after
+	// its client is gone.
+	PersistOnDisconnect bool
+	PersistTimeout      time.Duration
+
+	// InsecurePlainID puts the raw session ID in the cookie instead of a
+	// securecookie-encoded one, which makes cookies readable while
+	// debugging. FOR DEVELOPMENT ONLY: anyone can then forge a cookie for any
+	// session ID. Turn it on with StoreConfig.InsecurePlainID, which logs a
+	// warning as the store is built; WithPrefix logs it again for a store
+	// derived from one with it set.
+	InsecurePlainID bool
+
+	// BeforeSave is called with the session before its values are encoded
+	// and written, and may change them, e.g. to drop large transient data.
+	// Whatever it leaves in session.Values is what gets stored, and it also
+	// changes the session the caller holds. It should not depend on the
+	// request, as it isn't passed one.
+	BeforeSave func(session *sessions.Session)
+
+	// AfterLoad is called with the session once its values were read from
+	// etcd, e.g. to recompute what BeforeSave dropped.
+	AfterLoad func(session *sessions.Session)
+
+	// IDExtractor, if set, is asked first for the ID of the session called
+	// name in r, for clients carrying it in a header rather than a cookie.
+	// When it reports one, the ID is used as is, without securecookie
+	// decoding, and the cookie is ignored; otherwise the cookie is looked
+	// up. Save still sets the cookie, unless DisableCookie, header clients
+	// read session.ID instead.
+	// As with InsecurePlainID, whoever holds such an ID holds the session.
+	IDExtractor func(r *http.Request, name string) (string, bool)
+
+	// TokenExtractor, if set, is asked next for the token of the session
+	// called name in r, as SaveToken returns it, e.g. from an Authorization
+	// header. When it reports one, the token is decoded like the cookie
+	// would be, and the cookie is ignored.
+	TokenExtractor func(r *http.Request, name string) (string, bool)
+
+	// DisableCookie makes Save write no Set-Cookie header, for clients that
+	// get the session by other means, such as the token of SaveToken or the
+	// ID under IDExtractor. The session is stored and deleted as usual.
+	DisableCookie bool
+
+	// UserIDKey, when set, names the string value in session.Values that
+	// holds the user a session belongs to. Saved sessions are then indexed
+	// by user under the prefix, for UserSessions and MaxSessionsPerUser. The
+	// user a session is indexed under is recorded in its values.
+	UserIDKey string
+
+	// MaxSessionsPerUser, when positive and UserIDKey is set, caps the
+	// sessions a user may hold: saving a new session for a user at the cap
+	// deletes their oldest ones in the same transaction, and OnEvict, if
+	// set, is called with the user and the IDs of the evicted sessions.
+	MaxSessionsPerUser int
+	OnEvict            func(userID string, sessionIDs []string)
+
+	// Retries, when positive, is how many times a load, save, delete,
+	// MoveSession or IncrValue is retried after a transient etcd failure,
+	// such as an unreachable member or a leader election, waiting as
+	// Backoff says in between (a jittered exponential backoff from 50ms up
+	// to 1s if nil). Each attempt counts towards the circuit breaker.
+	// WithRetryBudget bounds the retries of all the operations of a request
+	// together.
+	Retries int
+	Backoff Backoff
+
+	// NoAuthRetry turns off the immediate retry of a request etcd rejected
+	// because the client's auth token expired mid-operation, which the
+	// client then fetches a fresh one for. That retry happens once per
+	// request, whatever Retries, without waiting or spending the retry
+	// budget, and only the retried attempt counts towards the circuit
+	// breaker.
+	NoAuthRetry bool
+
+	// CacheSize, when positive, keeps up to that many loaded session values
+	// in memory for CacheTTL (DefaultCacheTTL if zero), least recently used
+	// first out, sparing etcd a read per load. The store drops an entry when
+	// it writes the session, but writes by other processes go unseen until
+	// it expires. Bucketed stores aren't cached.
+	//
+	// CacheWrites also caches what Save wrote, so that the next load on this
+	// node reads its own write even when etcd reads are serializable or
+	// routed to a lagging member. Reading one's writes across nodes still
+	// depends on etcd's linearizable reads.
+	CacheSize   int
+	CacheTTL    time.Duration
+	CacheWrites bool
+
+	// StaleWhileUnavailable makes a load that fails to reach etcd, as
+	// opposed to finding the session missing, fall back to the value cached
+	// under CacheSize even once CacheTTL has passed, so that users aren't
+	// logged out while etcd is briefly unreachable. IsStale then reports the
+	// session as served stale. Values stay cached past CacheTTL for that
+	// until evicted or read again; a session missing from etcd is dropped.
+	//
+	// A stale session may have been changed, deleted or revoked by another
+	// process since it was cached, and is served nonetheless: logging out
+	// elsewhere doesn't take effect until etcd is back. It isn't touched
+	// under IdleTimeout or MaxTTL, writes still fail, and saving a stale
+	// session once etcd is back overwrites whatever was written meanwhile.
+	StaleWhileUnavailable bool
+
+	// Buckets, when positive, stores sessions in that many shared keys
+	// instead of one key each, which suits huge numbers of tiny sessions.
+	// A session goes to the bucket picked by a hash of its ID, which holds a
+	// map of IDs to values and expiry times, updated by read-modify-write
+	// under a revision compare. Every write to a bucket contends with the
+	// others to it and rewrites it whole, so pick enough buckets to keep
+	// them small and lightly contended.
+	//
+	// Buckets must be set before the store is used and never changed, as
+	// sessions are looked for in the bucket of the current count. Bucketed
+	// sessions have no lease and are expired on read, so the features built
+	// on per-session keys don't apply to them: the user index, auxiliary
+	// data, MoveSession, IncrValue and FilterSessions.
+	Buckets int
+
+	// RejectIDCollision makes Save check that the ID generated for a new
+	// session isn't already taken, in the same transaction that stores it,
+	// rather than overwrite the session holding it. A taken ID is replaced
+	// by a fresh one up to IDCollisionRetries times
+	// (DefaultIDCollisionRetries if zero). This turns the plain Put of new
+	// sessions into a transaction.
+	RejectIDCollision  bool
+	IDCollisionRetries int
+
+	// Events, if set, receives the lifecycle events of the sessions: their
+	// creation, saves, deletions and expiries. Publishing never blocks a
+	// request: events wait for the sink in a queue of EventQueueSize
+	// (DefaultEventQueueSize if zero), and are dropped, as counted by
+	// DroppedEvents, while it is full. Events must be set before the store
+	// is used.
+	Events         EventSink
+	EventQueueSize int
+
+	// MaxSessions, when positive, caps the sessions stored under the prefix,
+	// as counted by CountSessions: creating a session once that many are
+	// stored fails with ErrSessionQuotaExceeded, while existing sessions can
+	// still be saved. Every creation then pages through the keys of the
+	// prefix first, unless SessionCountTTL lets the count be reused for that
+	// long, at the cost of letting the cap be overshot by other processes
+	// meanwhile.
+	MaxSessions     int
+	SessionCountTTL time.Duration
+
+	// PageSize is how many keys the prefix scans of FilterSessions,
+	// CountSessions and the like fetch per request, DefaultPageSize if zero.
+	// Larger pages take fewer round-trips but more memory, on the client and
+	// on etcd. It can't be negative.
+	PageSize int
+
+	// SoftDeleteGrace, when positive, makes deleting a session move its
+	// value to a tombstone key leased for that long instead, e.g. for an
+	// undo of a logout: Undelete restores it until then. Loads, scans and
+	// Exists treat it as absent meanwhile. Each deleted session keeps its
+	// size in etcd, and a lease, for the grace period, and deleting costs a
+	// TimeToLive and a Grant more. It doesn't apply to Buckets.
+	SoftDeleteGrace time.Duration
+
+	// MaxKeepAlives, when positive, caps the keep-alives StartKeepAlive
+	// runs, as counted by ActiveKeepAlives, so that a leak or a flood of
+	// long-lived connections can't pile up goroutines: past it, keeping
+	// another lease alive fails with ErrTooManyKeepAlives. Sessions on a
+	// lease kept alive already don't count.
+	MaxKeepAlives int
+
+	// MaxKeepAliveDuration, when positive, caps how long StartKeepAlive
+	// keeps a lease alive, for an absolute session lifetime policy: past
+	// it, the keep-alive stops and the lease expires after its TTL, even
+	// though the connection is still open. OnKeepAliveStopped, if set, is
+	// then called with the name and ID of each session it kept alive, e.g.
+	// to close the connection. It isn't called for StopKeepAlive or Close.
+	MaxKeepAliveDuration time.Duration
+	OnKeepAliveStopped   func(name, id string)
+
+	// AppendCookies makes Save add a Set-Cookie header each time, as
+	// http.SetCookie does. By default, saving a session again on the same
+	// response, e.g. from a middleware and then the handler, replaces the
+	// Set-Cookie header of the previous save, so that browsers don't pick
+	// either of two cookies for the same name, path and domain.
+	AppendCookies bool
+
+	// DeleteWhenEmpty makes Save delete a session left without values, as
+	// for a MaxAge of zero or less, and expire its cookie, rather than keep
+	// an empty key and its lease around. Values under ReservedValuesPrefix
+	// don't count. A new empty session is then never written.
+	DeleteWhenEmpty bool
+
+	// EmbedID stores the session ID within each value, at the cost of a few
+	// bytes, and checks on load that it matches the key read, failing with
+	// ErrIDMismatch otherwise. This catches a value copied to the wrong key
+	// by hand or by a bug, and values moved between keys by someone without
+	// the key pairs. Values stored without it are still accepted, so it can
+	// be turned on for an existing store.
+	EmbedID bool
+
+	// MaxTTL, when positive, makes the lease of each session adapt to how
+	// often it is used, between MinTTL (MaxTTL/16 if zero) and MaxTTL,
+	// instead of lasting MaxAge: a session starts with MinTTL and every
+	// load, or Touch, moves it to a fresh lease, whose lifetime doubles
+	// when the access comes within the first half of the previous one and
+	// halves when it comes later. Busy sessions so stay around, while
+	// those used once expire soon. Save keeps the current lifetime. The
+	// cookie still lasts MaxAge, which should be at least MaxTTL.
+	//
+	// Each load then costs a Grant and a Put, as the lifetime and last
+	// access are kept in the session values, and a TimeToLive and a Revoke
+	// of the lease left behind once no other key is on it. It doesn't apply
+	// to Buckets.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// WriteLogTTL, when positive, keeps for that long what Save wrote and
+	// deleted, with its revision, so that a load on this process reads its
+	// own writes even when etcd reads are serializable or routed to a
+	// lagging member: a read older than the logged write is replaced by it.
+	// Unlike CacheWrites, every load still reads etcd. It doesn't help
+	// across processes, and a session whose lease expired since it was
+	// written on this process may still load until the entry expires, so
+	// keep it short, about the replication lag. It doesn't apply to Buckets.
+	WriteLogTTL time.Duration
+
+	// RedistoreFormat, if set, stores session values in redistore's format
+	// with that serializer, e.g. RedistoreGobSerializer{}, in place of the
+	// securecookie-encoded one, so that a Redis-backed redistore and this
+	// store can read each other's values while migrating between them, with
+	// both writing the sessions. Values in the store's own format are still
+	// read. Keys differ, redistore's being "session_" and the ID by default,
+	// but with the same key pairs both set the same cookies.
+	//
+	// Values are then stored in the clear, unsigned: Codecs, Checksum,
+	// Padding and compression don't apply to them.
+	RedistoreFormat RedistoreSerializer
+
+	// BackgroundContext, if set, bounds the goroutines the store runs on its
+	// own, apart from any request: the keep-alives of StartKeepAlive, the
+	// refill of LeasePoolSize and the publishing of Events. Once it is done,
+	// they stop as on Close, keep-alives without calling OnKeepAliveStopped
+	// and queued events dropped, while the store keeps serving requests;
+	// StartKeepAlive then fails with its error. It lets them share the
+	// lifecycle of the application's other background work rather than of
+	// Context, which the store's requests also derive from. It must be set
+	// before the store is used.
+	BackgroundContext context.Context
+
+	keyPrefix    string
+	keyDelimiter string
+	now          func() time.Time
+	kv           clientv3.KV
+	lease        clientv3.Lease
+	breaker      breaker
+	leasePool    leasePool
+	inflight     inflight
+	events       events
+	sessionCount sessionCount
+	keepAlives   keepAlives
+	cache        cache
+	writeLog     writeLog
+	plainIDWarn  sync.Once
+
+	// serializer is StoreConfig.Serializer, for Config.
+	serializer securecookie.Serializer
+
+	// expiredDropped counts the IDs ExpiredSessions dropped.
+	expiredDropped uint64
+
+	// ownsClient is unset on the stores returned by WithPrefix, so that
+	// they leave the client open on Close.
+	ownsClient bool
+
+	// Options and Codecs may be changed at runtime through MaxAge and
+	// RotateKeys only; mu guards them while requests are in flight.
+	mu sync.RWMutex
 }
 
+// NewEtcdStore connects to etcd and returns a store keeping its sessions
+// under prefix ("/sessions" if empty).
+//
+// If config leaves DialKeepAliveTime or DialKeepAliveTimeout unset they
+// default to DefaultDialKeepAliveTime and DefaultDialKeepAliveTimeout, so
+// idle connections stay warm behind load balancers. The keepalive time must
+// be shorter than the balancer's idle timeout: the 30s default suits AWS ALB
+// (60s) as well as NLB (350s) and GCP (600s). etcd rejects pings more
+// frequent than its --grpc-keepalive-min-time (5s by default).
 func NewEtcdStore(config clientv3.Config, ctx context.Context, prefix string, keyPairs ...[]byte) (*EtcdStore, error) {
-	client, err := clientv3.New(config)
+	client, err := clientv3.New(withKeepAliveDefaults(config))
 	if err != nil {
 		return nil, err
 	}
 
+	return newStore(client, client.KV, client.Lease, ctx, prefix, keyPairs...), nil
+}
+
+// newStore returns a store with default settings talking to etcd through
+// kv and lease.
+func newStore(client *clientv3.Client, kv clientv3.KV, lease clientv3.Lease, ctx context.Context, prefix string, keyPairs ...[]byte) *EtcdStore {
 	if prefix == "" {
 		prefix = "/sessions"
 	}
 
 	return &EtcdStore{
-		Client:    client,
-		Context:   ctx,
-		keyPrefix: prefix,
-		Codecs:    securecookie.CodecsFromPairs(keyPairs...),
+		Client:       client,
+		Context:      ctx,
+		keyPrefix:    prefix,
+		keyDelimiter: "/",
+		now:          time.Now,
+		kv:           kv,
+		lease:        lease,
+		ownsClient:   true,
+		Codecs:       securecookie.CodecsFromPairs(keyPairs...),
 		Options: &sessions.Options{
 			Path:   "/",
 			MaxAge: 86400 * 30,
 		},
-	}, nil
+	}
+}
+
+// withKeepAliveDefaults fills in the keepalive settings config leaves unset.
+func withKeepAliveDefaults(config clientv3.Config) clientv3.Config {
+	if config.DialKeepAliveTime == 0 {
+		config.DialKeepAliveTime = DefaultDialKeepAliveTime
+	}
+	if config.DialKeepAliveTimeout == 0 {
+		config.DialKeepAliveTimeout = DefaultDialKeepAliveTimeout
+	}
+	return config
+}
+
+// generateRandomKey is securecookie.GenerateRandomKey, swapped out by tests.
+var generateRandomKey = securecookie.GenerateRandomKey
+
+// sessionIDLength is the number of random bytes in a session ID.
+const sessionIDLength = 32
+
+// DefaultIDCollisionRetries is how many times Save replaces a taken ID
+// under RejectIDCollision when IDCollisionRetries is zero.
+const DefaultIDCollisionRetries = 3
+
+// newSessionID returns a random base32 session ID.
+func newSessionID() (string, error) {
+	b := generateRandomKey(sessionIDLength)
+	if len(b) < sessionIDLength {
+		return "", ErrEntropyFailure
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
 }
 
-func (s *EtcdStore) load(session *sessions.Session) error {
-	key := s.keyPrefix + "/" + session.ID
-	resp, err := s.Client.Get(s.Context, key)
+// key returns the etcd key holding the session with the given ID.
+func (s *EtcdStore) key(id string) string {
+	return s.keyPrefix + s.keyDelimiter + id
+}
+
+// SetKeyDelimiter sets what separates the prefix from the session ID in
+// etcd keys, "/" by default. It must be called before the store is used.
+//
+// The delimiter can't be empty, which would let prefix scans match other
+// prefixes, nor contain control characters or characters that may appear in
+// generated session IDs (base32: A-Z, 2-7 and "=").
+func (s *EtcdStore) SetKeyDelimiter(delim string) error {
+	if err := checkKeyDelimiter(delim); err != nil {
+		return err
+	}
+
+	s.keyDelimiter = delim
+	return nil
+}
+
+// WithPrefix returns a store keeping its sessions under prefix instead, on
+// the same etcd connection, e.g. to run separate namespaces for web, API and
+// admin sessions. A trailing key delimiter is dropped from prefix, and an
+// empty one means "/sessions".
+//
+// The derived store starts with the settings of s, including its Codecs
+// and a copy of its Options, but is configured apart from then on: MaxAge
+// and RotateKeys apply to the store they are called on. Caches, the
+// breaker, the lease pool and the event queue aren't shared. Closing the
+// derived store leaves the client open; it is closed along with s.
+func (s *EtcdStore) WithPrefix(prefix string) *EtcdStore {
+	prefix = strings.TrimSuffix(prefix, s.keyDelimiter)
+	if prefix == "" {
+		prefix = "/sessions"
+	}
+
+	s.mu.RLock()
+	options := *s.Options
+	codecs := append([]securecookie.Codec(nil), s.Codecs...)
+	s.mu.RUnlock()
+
+	derived := &EtcdStore{
+		Client:                s.Client,
+		Context:               s.Context,
+		Codecs:                codecs,
+		Options:               &options,
+		GetOptions:            s.GetOptions,
+		ReadConsistency:       s.ReadConsistency,
+		PutOptions:            s.PutOptions,
+		MergeValues:           s.MergeValues,
+		StrictLoad:            s.StrictLoad,
+		Compression:           s.Compression,
+		CompressThreshold:     s.CompressThreshold,
+		CompressKeys:          s.CompressKeys,
+		IdleTimeout:           s.IdleTimeout,
+		SkewTolerance:         s.SkewTolerance,
+		FieldKeys:             s.FieldKeys,
+		TrackCreation:         s.TrackCreation,
+		DeleteCorrupt:         s.DeleteCorrupt,
+		OnCorrupt:             s.OnCorrupt,
+		BreakerThreshold:      s.BreakerThreshold,
+		BreakerCooldown:       s.BreakerCooldown,
+		BreakerLatency:        s.BreakerLatency,
+		OnQuotaExceeded:       s.OnQuotaExceeded,
+		Padding:               s.Padding,
+		Checksum:              s.Checksum,
+		LeasePoolSize:         s.LeasePoolSize,
+		PersistOnDisconnect:   s.PersistOnDisconnect,
+		PersistTimeout:        s.PersistTimeout,
+		InsecurePlainID:       s.InsecurePlainID,
+		BeforeSave:            s.BeforeSave,
+		AfterLoad:             s.AfterLoad,
+		IDExtractor:           s.IDExtractor,
+		TokenExtractor:        s.TokenExtractor,
+		DisableCookie:         s.DisableCookie,
+		UserIDKey:             s.UserIDKey,
+		MaxSessionsPerUser:    s.MaxSessionsPerUser,
+		OnEvict:               s.OnEvict,
+		Retries:               s.Retries,
+		NoAuthRetry:           s.NoAuthRetry,
+		Backoff:               s.Backoff,
+		CacheSize:             s.CacheSize,
+		CacheTTL:              s.CacheTTL,
+		CacheWrites:           s.CacheWrites,
+		StaleWhileUnavailable: s.StaleWhileUnavailable,
+		Buckets:               s.Buckets,
+		RejectIDCollision:     s.RejectIDCollision,
+		IDCollisionRetries:    s.IDCollisionRetries,
+		Events:                s.Events,
+		EventQueueSize:        s.EventQueueSize,
+		MaxSessions:           s.MaxSessions,
+		SessionCountTTL:       s.SessionCountTTL,
+		PageSize:              s.PageSize,
+		SoftDeleteGrace:       s.SoftDeleteGrace,
+		MaxKeepAlives:         s.MaxKeepAlives,
+		MaxKeepAliveDuration:  s.MaxKeepAliveDuration,
+		OnKeepAliveStopped:    s.OnKeepAliveStopped,
+		AppendCookies:         s.AppendCookies,
+		DeleteWhenEmpty:       s.DeleteWhenEmpty,
+		EmbedID:               s.EmbedID,
+		MinTTL:                s.MinTTL,
+		MaxTTL:                s.MaxTTL,
+		WriteLogTTL:           s.WriteLogTTL,
+		RedistoreFormat:       s.RedistoreFormat,
+		BackgroundContext:     s.BackgroundContext,
+
+		keyPrefix:    prefix,
+		keyDelimiter: s.keyDelimiter,
+		serializer:   s.serializer,
+		now:          s.now,
+		kv:           s.kv,
+		lease:        s.lease,
+	}
+	if derived.InsecurePlainID {
+		derived.warnPlainID()
+	}
+	return derived
+}
+
+func checkKeyDelimiter(delim string) error {
+	if delim == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidKeyDelimiter)
+	}
+	for _, c := range delim {
+		if c < ' ' || c == 0x7f || c >= 'A' && c <= 'Z' || c >= '2' && c <= '7' || c == '=' {
+			return fmt.Errorf("%w: %q", ErrInvalidKeyDelimiter, delim)
+		}
+	}
+	return nil
+}
+
+func (s *EtcdStore) load(ctx context.Context, session *sessions.Session) error {
+	return s.loadWithMeta(ctx, session, nil)
+}
+
+// loadWithMeta is load, also filling in meta unless it is nil.
+func (s *EtcdStore) loadWithMeta(ctx context.Context, session *sessions.Session, meta *SessionMeta) error {
+	done, err := s.inflight.begin()
 	if err != nil {
 		return err
 	}
+	defer done()
+
+	if err := checkGetOptions(s.GetOptions); err != nil {
+		return err
+	}
+
+	key := s.key(session.ID)
+	var value []byte
+	var kv *mvccpb.KeyValue
+	var expires int64
+	if s.Buckets > 0 {
+		entry, err := s.loadBucketed(ctx, session.ID)
+		if err != nil {
+			return err
+		}
+		value, expires = entry.Value, entry.Expires
+	} else if cached, ok := s.cached(key); ok {
+		kv = cached
+		value = kv.Value
+	} else {
+		var resp *clientv3.GetResponse
+		err := s.retry(ctx, func() (err error) {
+			resp, err = s.get(ctx, key, s.readOpts(ReadLoad, s.GetOptions...)...)
+			return err
+		})
+		if err != nil {
+			stale, ok := s.staleCached(key)
+			if !ok {
+				return err
+			}
+			// marked first, so that loaded doesn't write to etcd
+			session.Values[staleKey] = true
+			return s.loaded(ctx, session, stale, stale.Value, 0, meta)
+		}
 
-	if resp.Count == 0 {
-		return fmt.Errorf("key: %s is not found in etcd", key)
+		if resp.Count > 0 {
+			kv = resp.Kvs[0]
+		}
+		if kv = s.readYourWrites(key, kv); kv == nil {
+			s.uncache(key)
+			return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+		value = kv.Value
+		s.cacheValue(kv)
 	}
+	return s.loaded(ctx, session, kv, value, expires, meta)
+}
 
-	if err = securecookie.DecodeMulti(session.Name(), string(resp.Kvs[0].Value), &session.Values, s.Codecs...); err != nil {
+// loaded completes the load of session from value, read from kv unless
+// bucketed, which expires at expires if so.
+func (s *EtcdStore) loaded(ctx context.Context, session *sessions.Session, kv *mvccpb.KeyValue, value []byte, expires int64, meta *SessionMeta) error {
+	key := s.key(session.ID)
+	err := s.decode(session.Name(), session.ID, value, &session.Values)
+	if err == nil && s.fieldKeys() {
+		err = s.loadFields(ctx, session)
+	}
+	if err != nil {
+		if s.DeleteCorrupt && isCorrupt(err) {
+			return s.deleteCorrupt(ctx, session, key)
+		}
 		return err
 	}
+	if kv != nil && kv.Lease != 0 {
+		session.Values[leaseKey] = kv.Lease
+	}
 
+	if meta != nil {
+		if err := s.fillMeta(ctx, meta, session, kv, len(value), expires); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkIdle(ctx, session, kv, value); err != nil {
+		return err
+	}
+
+	if s.AfterLoad != nil {
+		s.AfterLoad(session)
+	}
 	return nil
 }
 
-func (s *EtcdStore) delete(session *sessions.Session) error {
-	key := s.keyPrefix + "/" + session.ID
-	resp, err := s.Client.Delete(s.Context, key)
+// isCorrupt reports whether err means a stored value couldn't be decoded.
+func isCorrupt(err error) bool {
+	var codecErr securecookie.Error
+	return errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrCorruptValue) ||
+		errors.As(err, &codecErr) && codecErr.IsDecode()
+}
+
+// deleteCorrupt removes the undecodable value stored for session and resets
+// it to a fresh one.
+func (s *EtcdStore) deleteCorrupt(ctx context.Context, session *sessions.Session, key string) error {
+	if err := s.delete(ctx, session); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if s.OnCorrupt != nil {
+		s.OnCorrupt(key)
+	}
+
+	session.ID = ""
+	session.Values = make(map[interface{}]interface{})
+	return errCorruptDeleted
+}
+
+func (s *EtcdStore) delete(ctx context.Context, session *sessions.Session) error {
+	done, err := s.inflight.begin()
 	if err != nil {
 		return err
 	}
+	defer done()
 
-	if resp.Deleted == 0 {
-		return fmt.Errorf("key: %s is not found in etcd", key)
+	if s.Buckets > 0 {
+		return s.deleteBucketed(ctx, session.ID)
+	}
+	if s.SoftDeleteGrace > 0 {
+		return s.softDelete(ctx, session)
 	}
 
+	key := s.key(session.ID)
+	s.uncache(key)
+	var deleted, rev int64
+	userID, _ := session.Values[indexedUserKey].(string)
+	if _, hasAux := session.Values[auxKey]; userID != "" || hasAux || s.fieldKeys() {
+		ops := []clientv3.Op{clientv3.OpDelete(key), clientv3.OpDelete(s.auxPrefix(session.ID), clientv3.WithPrefix())}
+		if userID != "" {
+			ops = append(ops, clientv3.OpDelete(s.userIndexKey(userID, session.ID)))
+		}
+		err := s.retry(ctx, func() error {
+			resp, err := s.commit(s.kv.Txn(ctx).Then(ops...))
+			if err == nil {
+				deleted, rev = resp.Responses[0].GetResponseDeleteRange().Deleted, resp.Header.Revision
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		err := s.retry(ctx, func() error {
+			resp, err := s.del(ctx, key)
+			if err == nil {
+				deleted, rev = resp.Deleted, resp.Header.Revision
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if deleted == 0 {
+		return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+	}
+	s.logWrite(key, nil, rev)
+
 	return nil
 }
 
-// save writes encoded session.Values to etcd.
-func (s *EtcdStore) save(session *sessions.Session) error {
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
-		s.Codecs...)
+// save writes encoded session.Values to etcd. If create is set, it fails
+// with ErrIDCollision rather than overwrite a session holding the same ID.
+func (s *EtcdStore) save(ctx context.Context, session *sessions.Session, create bool) error {
+	done, err := s.inflight.begin()
 	if err != nil {
 		return err
 	}
+	defer done()
 
-	key := s.keyPrefix + "/" + session.ID
+	if err := checkPutOptions(s.PutOptions); err != nil {
+		return err
+	}
 
-	grant, err := s.Client.Grant(s.Context, int64(session.Options.MaxAge+1))
+	if s.BeforeSave != nil {
+		s.BeforeSave(session)
+	}
+	if err := checkReservedValues(session.Values); err != nil {
+		return err
+	}
+
+	if s.IdleTimeout > 0 {
+		session.Values[lastAccessKey] = s.clock().UnixNano()
+	}
+	s.stampCreated(session)
+
+	userID, err := s.userOf(session.Values)
 	if err != nil {
 		return err
 	}
+	prevUser, _ := session.Values[indexedUserKey].(string)
+	if userID != "" {
+		session.Values[indexedUserKey] = userID
+	} else {
+		delete(session.Values, indexedUserKey)
+	}
 
-	_, err = s.Client.Put(s.Context, key, encoded, clientv3.WithLease(grant.ID))
+	encoded, err := s.encode(session.Name(), session.ID, session.Values)
 	if err != nil {
 		return err
 	}
+	var fields []fieldWrite
+	var digests map[string]string
+	if s.fieldKeys() {
+		if fields, digests, err = s.fieldWrites(session); err != nil {
+			return err
+		}
+	}
+
+	if s.Buckets > 0 {
+		if err := s.saveBucketed(ctx, session.ID, encoded, session.Options.MaxAge, create); err != nil {
+			return s.saveError(err)
+		}
+		return nil
+	}
+
+	key := s.key(session.ID)
+
+	s.uncache(key)
+	var leaseID clientv3.LeaseID
+	var rev int64
+	err = s.retry(ctx, func() (err error) {
+		ttl := int64(session.Options.MaxAge + 1)
+		if s.MaxTTL > 0 {
+			ttl = int64((s.adaptiveTTL(session) + time.Second - 1) / time.Second)
+		}
+		leaseID, err = s.sessionLease(ctx, session, ttl)
+		if err != nil {
+			return err
+		}
+
+		opts := append(append([]clientv3.OpOption{}, s.PutOptions...), clientv3.WithLease(leaseID))
+		auxOps := append(s.auxLeaseOps(session, leaseID), s.fieldOps(session.ID, fields, leaseID)...)
+		if userID == "" && prevUser == "" && len(auxOps) == 0 && !create {
+			resp, err := s.put(ctx, key, encoded, opts...)
+			if err != nil {
+				return err
+			}
+			rev = resp.Header.Revision
+			return nil
+		}
+		rev, err = s.saveTxn(ctx, session, prevUser, userID, leaseID, create, append([]clientv3.Op{clientv3.OpPut(key, encoded, opts...)}, auxOps...))
+		return err
+	})
+	if errors.Is(err, ErrIDCollision) {
+		// nothing was written on the lease granted for the ID
+		s.forgetLease(ctx, session)
+		s.revokeUnused(ctx, leaseID)
+	}
+	if err != nil {
+		return s.saveError(err)
+	}
+
+	written := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(encoded), ModRevision: rev, Lease: int64(leaseID)}
+	if s.CacheWrites {
+		s.cacheValue(written)
+	}
+	s.logWrite(key, written, rev)
+	session.Values[leaseKey] = int64(leaseID)
+	setFieldDigests(session, digests)
+	return nil
+}
+
+// saveTxn commits ops, which write the session, along with the user index
+// changes they entail, retrying until the index didn't change under it. If
+// create is set, the session key must not exist. It returns the revision
+// of the commit.
+func (s *EtcdStore) saveTxn(ctx context.Context, session *sessions.Session, prevUser, userID string, lease clientv3.LeaseID, create bool, ops []clientv3.Op) (int64, error) {
+	key := s.key(session.ID)
+	for {
+		cmps, indexOps, evicted, err := s.indexTxn(ctx, session, prevUser, userID, lease)
+		if err != nil {
+			return 0, err
+		}
+		if create {
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+		}
+
+		resp, err := s.commit(s.kv.Txn(ctx).If(cmps...).Then(append(ops[:len(ops):len(ops)], indexOps...)...))
+		if err != nil {
+			return 0, err
+		}
+		if !resp.Succeeded {
+			if create {
+				// the index changed, or the ID is taken
+				taken, err := s.get(ctx, key, clientv3.WithCountOnly())
+				if err != nil {
+					return 0, err
+				}
+				if taken.Count > 0 {
+					return 0, fmt.Errorf("%w: key %s", ErrIDCollision, key)
+				}
+			}
+			continue
+		}
+
+		if len(evicted) > 0 {
+			for _, id := range evicted {
+				s.uncache(s.key(id))
+				s.logWrite(s.key(id), nil, resp.Header.Revision)
+				s.publish(EventDeleted, session.Name(), id)
+			}
+			if s.OnEvict != nil {
+				s.OnEvict(userID, evicted)
+			}
+		}
+		return resp.Header.Revision, nil
+	}
+}
 
+// checkGetOptions rejects options that would make the session Get return
+// something other than the single key's value.
+func checkGetOptions(opts []clientv3.OpOption) error {
+	op := clientv3.OpGet("", opts...)
+	switch {
+	case op.RangeBytes() != nil:
+		return fmt.Errorf("%w: range in get", ErrInvalidOpOption)
+	case op.IsKeysOnly():
+		return fmt.Errorf("%w: keys only in get", ErrInvalidOpOption)
+	case op.IsCountOnly():
+		return fmt.Errorf("%w: count only in get", ErrInvalidOpOption)
+	}
+	return nil
+}
+
+// checkPutOptions rejects options clientv3 doesn't allow on a Put, which it
+// reports by panicking.
+func checkPutOptions(opts []clientv3.OpOption) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidOpOption, r)
+		}
+	}()
+
+	clientv3.OpPut("", "", opts...)
 	return nil
 }
 
@@ -103,6 +1023,9 @@ func (s *EtcdStore) save(session *sessions.Session) error {
 // implementation. Individual sessions can be deleted by setting Options.MaxAge
 // = -1 for that session.
 func (s *EtcdStore) MaxAge(age int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.Options.MaxAge = age
 
 	// Set the maxAge for each securecookie instance.
@@ -113,23 +1036,80 @@ func (s *EtcdStore) MaxAge(age int) {
 	}
 }
 
+// RotateKeys replaces the store's codecs with ones built from keyPairs, as
+// securecookie.CodecsFromPairs does, keeping the current MaxAge. List the
+// new pair first, followed by the old ones still accepted for decoding.
+// The new codecs use StoreConfig.Serializer, if one was set.
+func (s *EtcdStore) RotateKeys(keyPairs ...[]byte) {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(s.Options.MaxAge)
+			if s.serializer != nil {
+				sc.SetSerializer(s.serializer)
+			}
+		}
+	}
+	s.Codecs = codecs
+}
+
 // New returns a session for the given name without adding it to the registry.
 //
 // See gorilla/sessions CookieStore.New().
 func (s *EtcdStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := s.newSession(r, name, nil)
+	return session, s.sessionError("load", name, err)
+}
+
+// newSession is New, also filling in meta for a loaded session unless it
+// is nil.
+func (s *EtcdStore) newSession(r *http.Request, name string, meta *SessionMeta) (*sessions.Session, error) {
 	session := sessions.NewSession(s, name)
+	if err := checkCookieName(name); err != nil {
+		return session, err
+	}
 
+	s.mu.RLock()
 	options := *s.Options
+	s.mu.RUnlock()
 	session.Options = &options
 	session.IsNew = true
 
 	var err error
-	if c, errCookie := r.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	var found bool
+	if s.IDExtractor != nil {
+		var id string
+		if id, found = s.IDExtractor(r, name); found {
+			if err = s.checkPlainID(id); err == nil {
+				session.ID = id
+			}
+		}
+	}
+	if !found && s.TokenExtractor != nil {
+		var token string
+		if token, found = s.TokenExtractor(r, name); found {
+			err = s.idFromCookie(name, token, &session.ID)
+		}
+	}
+	if !found {
+		if c, errCookie := r.Cookie(name); errCookie == nil {
+			found = true
+			err = s.idFromCookie(name, c.Value, &session.ID)
+		}
+	}
+	if found {
 		if err == nil {
-			err = s.load(session)
+			err = s.loadWithMeta(withRetryBudgetOf(s.Context, r.Context()), session, meta)
 			if err == nil {
 				session.IsNew = false
+			} else if errors.Is(err, errCorruptDeleted) {
+				err = nil
+			} else if s.StrictLoad && isUnavailable(err) {
+				return nil, &unavailableError{err: err}
 			}
 		}
 	}
@@ -139,42 +1119,344 @@ func (s *EtcdStore) New(r *http.Request, name string) (*sessions.Session, error)
 
 // Get returns a session for the given name after adding it to the registry.
 //
+// The registry keys sessions by name alone, so when several stores serve
+// the same name on one request, only the first one's session goes into the
+// registry; the others' are kept aside for the request. sessions.Save then
+// misses those, save them with Save or SaveAll.
+//
 // See gorilla/sessions CookieStore.Get().
 func (s *EtcdStore) Get(r *http.Request, name string) (*sessions.Session, error) {
-	return sessions.GetRegistry(r).Get(s, name)
+	s.track(r, name)
+	if !s.StrictLoad {
+		return s.registryGet(r, s, name)
+	}
+
+	session, err := s.registryGet(r, strictStore{s}, name)
+	if errors.Is(err, ErrUnavailable) {
+		return nil, err
+	}
+	return session, err
 }
 
 // Save adds a single session to the response.
-func (s *EtcdStore) Save(_ *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+//
+// The etcd requests are cancelled along with r's context, unless
+// PersistOnDisconnect is set. Saving a session again while serving the same
+// r reuses the lease granted the first time, unless its MaxAge changed.
+func (s *EtcdStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	_, err := s.saveSession(r, w, session)
+	return s.sessionError("save", session.Name(), err)
+}
+
+// SaveToken is Save for clients carrying the session in something other
+// than a cookie, e.g. a token in a JSON response body: it sets no cookie,
+// whatever DisableCookie, and returns the token the cookie would have held,
+// "" if the session was deleted. Clients send it back for TokenExtractor.
+// r may be nil, outside of a request.
+func (s *EtcdStore) SaveToken(r *http.Request, session *sessions.Session) (string, error) {
+	token, err := s.saveSession(r, nil, session)
+	return token, s.sessionError("save", session.Name(), err)
+}
+
+// saveSession saves session and sets its cookie on w, unless nil, and
+// returns the cookie's value.
+func (s *EtcdStore) saveSession(r *http.Request, w http.ResponseWriter, session *sessions.Session) (string, error) {
+	if err := checkCookieName(session.Name()); err != nil {
+		return "", err
+	}
+	if err := checkCookiePrefix(session.Name(), session.Options); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := s.saveContext(r)
+	defer cancel()
+	if r != nil {
+		ctx = context.WithValue(ctx, requestLeasesKey{s}, s.requestLeases(r))
+	}
+
 	if session.Options.MaxAge <= 0 {
-		if err := s.delete(session); err != nil {
-			return err
+		if err := s.delete(ctx, session); err != nil {
+			return "", err
 		}
+		s.publish(EventDeleted, session.Name(), session.ID)
 
-		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
-		return nil
+		s.setCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return "", nil
+	}
+	if s.DeleteWhenEmpty && isEmpty(session.Values) {
+		return "", s.deleteEmpty(ctx, w, session)
+	}
+
+	create := session.ID == ""
+	retries := s.IDCollisionRetries
+	if retries <= 0 {
+		retries = DefaultIDCollisionRetries
 	}
+	if create {
+		if err := s.checkSessionQuota(ctx); err != nil {
+			return "", err
+		}
+	}
+	var encoded string
+	for attempt := 0; ; attempt++ {
+		if create {
+			id, err := newSessionID()
+			if err != nil {
+				return "", err
+			}
+			session.ID = id
+		}
+
+		// checked ahead of the write, so a session can't be stored without a
+		// cookie leading to it
+		var err error
+		if encoded, err = s.cookieValue(session.Name(), session.ID); err != nil {
+			return "", err
+		}
+		if err := checkCookieSize(session.Name(), encoded); err != nil {
+			return "", err
+		}
 
-	if session.ID == "" {
-		session.ID = strings.TrimRight(
-			base32.StdEncoding.EncodeToString(
-				securecookie.GenerateRandomKey(32)), "=")
+		err = s.save(ctx, session, create && s.RejectIDCollision)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrIDCollision) || attempt >= retries {
+			return "", err
+		}
 	}
+	if create {
+		s.countCreated()
+		s.publish(EventCreated, session.Name(), session.ID)
+	} else {
+		s.publish(EventSaved, session.Name(), session.ID)
+	}
+
+	s.setCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return encoded, nil
+}
+
+// MoveSession moves the session called name from oldID to newID in a single
+// transaction, keeping the lease (and so the remaining TTL) of the old key.
+// If newID already holds a session, its values are merged with MergeValues,
+// or replaced when MergeValues is nil. Its auxiliary data, and under
+// FieldKeys its field keys, move along with it; a merge keeps the auxiliary
+// data and labels of both, the moved session's winning. It publishes
+// EventDeleted for oldID, then EventCreated or EventSaved for newID.
+func (s *EtcdStore) MoveSession(ctx context.Context, name, oldID, newID string) error {
+	return s.sessionError("move", name, s.moveSession(ctx, name, oldID, newID))
+}
 
-	if err := s.save(session); err != nil {
+func (s *EtcdStore) moveSession(ctx context.Context, name, oldID, newID string) error {
+	for _, id := range []string{oldID, newID} {
+		if err := s.checkPlainID(id); err != nil {
+			return err
+		}
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
 		return err
 	}
+	defer done()
+
+	oldKey, newKey := s.key(oldID), s.key(newID)
+	s.uncache(oldKey, newKey)
+	var replaced bool
+	err = s.retry(ctx, func() error {
+		for {
+			oldResp, err := s.get(ctx, oldKey)
+			if err != nil {
+				return err
+			}
+			if oldResp.Count == 0 {
+				return fmt.Errorf("key: %s is %w", oldKey, ErrNotFound)
+			}
+
+			newResp, err := s.get(ctx, newKey)
+			if err != nil {
+				return err
+			}
+
+			old := oldResp.Kvs[0]
+			lease := clientv3.LeaseID(old.Lease)
+			value := string(old.Value)
+			var fieldOps []clientv3.Op
+			newCmp := clientv3.Compare(clientv3.CreateRevision(newKey), "=", 0)
+			if newResp.Count > 0 {
+				newCmp = clientv3.Compare(clientv3.ModRevision(newKey), "=", newResp.Kvs[0].ModRevision)
+			}
+			switch {
+			case s.fieldKeys():
+				var dst *mvccpb.KeyValue
+				if newResp.Count > 0 {
+					dst = newResp.Kvs[0]
+				}
+				if value, fieldOps, err = s.moveFields(ctx, name, oldID, newID, old, dst, lease); err != nil {
+					return err
+				}
+			case newResp.Count > 0 && s.MergeValues != nil:
+				if value, err = s.merge(name, oldID, newID, string(newResp.Kvs[0].Value), value); err != nil {
+					return err
+				}
+			case s.EmbedID:
+				if value, err = s.reembed(name, oldID, newID, value); err != nil {
+					return err
+				}
+			}
+			auxOps, err := s.moveAuxOps(ctx, oldID, newID, newResp.Count > 0 && s.MergeValues != nil, lease)
+			if err != nil {
+				return err
+			}
+
+			ops := append([]clientv3.Op{clientv3.OpPut(newKey, value, clientv3.WithLease(lease)), clientv3.OpDelete(oldKey)}, fieldOps...)
+			ops = append(ops, auxOps...)
+			if s.UserIDKey != "" {
+				var dst string
+				if newResp.Count > 0 {
+					dst = string(newResp.Kvs[0].Value)
+				}
+				ops = append(ops, s.moveIndexOps(name, oldID, newID, string(old.Value), dst, value, lease)...)
+			}
 
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+			resp, err := s.commit(s.kv.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision(oldKey), "=", old.ModRevision), newCmp).
+				Then(ops...))
+			if err != nil {
+				return err
+			}
+			if resp.Succeeded {
+				rev := resp.Header.Revision
+				s.logWrite(oldKey, nil, rev)
+				s.logWrite(newKey, &mvccpb.KeyValue{Key: []byte(newKey), Value: []byte(value), ModRevision: rev, Lease: old.Lease}, rev)
+				replaced = newResp.Count > 0
+				return nil
+			}
+			// one of the keys changed under us, start over
+		}
+	})
 	if err != nil {
 		return err
 	}
 
-	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	s.publish(EventDeleted, name, oldID)
+	if replaced {
+		s.publish(EventSaved, name, newID)
+	} else {
+		s.publish(EventCreated, name, newID)
+	}
 	return nil
 }
 
-// Close the etcd client
+// reembed re-encodes the value stored for oldID under EmbedID, to embed
+// newID instead.
+func (s *EtcdStore) reembed(name, oldID, newID, value string) (string, error) {
+	values := make(map[interface{}]interface{})
+	if err := s.decode(name, oldID, []byte(value), &values); err != nil {
+		return "", err
+	}
+	return s.encode(name, newID, values)
+}
+
+// merge decodes the values stored in dst and src, under newID and oldID,
+// folds src into dst with MergeValues and returns the encoded result.
+func (s *EtcdStore) merge(name, oldID, newID, dst, src string) (string, error) {
+	dstValues := make(map[interface{}]interface{})
+	if err := s.decode(name, newID, []byte(dst), &dstValues); err != nil {
+		return "", err
+	}
+	srcValues := make(map[interface{}]interface{})
+	if err := s.decode(name, oldID, []byte(src), &srcValues); err != nil {
+		return "", err
+	}
+
+	s.MergeValues(dstValues, srcValues)
+	mergeKept(dstValues, srcValues)
+	return s.encode(name, newID, dstValues)
+}
+
+// strictStore hands the gorilla registry a placeholder session when New
+// fails under StrictLoad, since the registry can't cope with a nil session.
+type strictStore struct {
+	*EtcdStore
+}
+
+func (s strictStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := s.EtcdStore.New(r, name)
+	if session == nil {
+		session = sessions.NewSession(s.EtcdStore, name)
+	}
+	return session, err
+}
+
+// isUnavailable reports whether err came from talking to etcd rather than
+// from a missing or expired key or a codec failure.
+func isUnavailable(err error) bool {
+	var codecErr securecookie.Error
+	return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrIdleTimeout) &&
+		!isCorrupt(err) && !errors.As(err, &codecErr)
+}
+
+// unavailableError is ErrUnavailable caused by err, which errors.Is and
+// errors.As still find.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrUnavailable, e.err)
+}
+
+func (e *unavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// Close the etcd client, unless the store was returned by WithPrefix.
 func (s *EtcdStore) Close() error {
+	s.leasePool.close(s)
+	s.keepAlives.close()
+	s.events.close()
+	if s.Client == nil || !s.ownsClient {
+		return nil
+	}
 	return s.Client.Close()
 }
+
+// background returns BackgroundContext, or a context never done.
+func (s *EtcdStore) background() context.Context {
+	if s.BackgroundContext != nil {
+		return s.BackgroundContext
+	}
+	return context.Background()
+}
+
+// isEmpty reports whether values hold nothing but the store's own data.
+func isEmpty(values map[interface{}]interface{}) bool {
+	for k := range values {
+		if name, ok := k.(string); !ok || !strings.HasPrefix(name, ReservedValuesPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteEmpty is Save of a session without values under DeleteWhenEmpty.
+// The session may have expired or never been saved, which is just as
+// good.
+func (s *EtcdStore) deleteEmpty(ctx context.Context, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID != "" {
+		if err := s.delete(ctx, session); err == nil {
+			s.publish(EventDeleted, session.Name(), session.ID)
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	expired := *session.Options
+	expired.MaxAge = -1
+	s.setCookie(w, sessions.NewCookie(session.Name(), "", &expired))
+	return nil
+}
@@ -1,11 +1,17 @@
 package etcdstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/etcd/client/v3"
 )
@@ -81,3 +87,429 @@ func TestEtcdStore_Save(t *testing.T) {
 	err = session2.Save(req, rsp)
 	assert.Nil(t, err)
 }
+
+func TestEtcdStore_StrictLoad(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// nothing listens on this endpoint, so every etcd call fails
+	down, err := NewEtcdStore(clientv3.Config{Endpoints: []string{"http://127.0.0.1:1"}}, ctx, "/sessions", []byte("secret"))
+	assert.Nil(t, err)
+	defer down.Close()
+
+	encoded, err := securecookie.EncodeMulti("_session", "some-id", down.Codecs...)
+	assert.Nil(t, err)
+
+	newReq := func() *http.Request {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+		assert.Nil(t, err, "http new request")
+		req.AddCookie(&http.Cookie{Name: "_session", Value: encoded})
+		return req
+	}
+
+	// lenient by default
+	session, err := down.New(newReq(), "_session")
+	assert.NotNil(t, err)
+	assert.NotNil(t, session)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+
+	down.StrictLoad = true
+	session, err = down.New(newReq(), "_session")
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "cause kept: %v", err)
+	assert.Nil(t, session)
+
+	session, err = down.Get(newReq(), "_session")
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Nil(t, session)
+
+	// a missing key is not an outage
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	encoded, err = securecookie.EncodeMulti("_session", "missing-id", store.Codecs...)
+	assert.Nil(t, err)
+	req.AddCookie(&http.Cookie{Name: "_session", Value: encoded})
+	store.StrictLoad = true
+	defer func() { store.StrictLoad = false }()
+	session, err = store.New(req, "_session")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.NotNil(t, session)
+	assert.True(t, session.IsNew)
+}
+
+func TestWithKeepAliveDefaults(t *testing.T) {
+	config := withKeepAliveDefaults(clientv3.Config{})
+	assert.Equal(t, DefaultDialKeepAliveTime, config.DialKeepAliveTime)
+	assert.Equal(t, DefaultDialKeepAliveTimeout, config.DialKeepAliveTimeout)
+
+	config = withKeepAliveDefaults(clientv3.Config{
+		DialKeepAliveTime:    time.Minute,
+		DialKeepAliveTimeout: time.Second,
+	})
+	assert.Equal(t, time.Minute, config.DialKeepAliveTime)
+	assert.Equal(t, time.Second, config.DialKeepAliveTimeout)
+}
+
+// newTestStore returns a store of its own under a fresh prefix, so a test
+// can change its settings and scan its keys without affecting the others.
+func newTestStore(t testing.TB) *EtcdStore {
+	prefix := fmt.Sprintf("/test/%s/%d", t.Name(), time.Now().UnixNano())
+	s, err := NewEtcdStore(clientv3.Config{Endpoints: []string{_defaultEtcd}}, context.Background(), prefix, []byte("secret"))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		store.Client.Delete(context.Background(), prefix+"/", clientv3.WithPrefix())
+		s.Close()
+	})
+	return s
+}
+
+// newSavedSession saves a fresh session holding values and returns it.
+func newSavedSession(t *testing.T, s *EtcdStore, values map[interface{}]interface{}) *sessions.Session {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	return session
+}
+
+// loadByID reads back the session stored under id.
+func loadByID(s *EtcdStore, id string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, "_session")
+	session.ID = id
+	return session, s.load(context.Background(), session)
+}
+
+func TestEtcdStore_MoveSession(t *testing.T) {
+	ctx := context.Background()
+
+	// move to a fresh ID
+	guest := newSavedSession(t, store, map[interface{}]interface{}{"cart": "apple"})
+	oldResp, err := store.Client.Get(ctx, store.key(guest.ID))
+	assert.Nil(t, err)
+
+	err = store.MoveSession(ctx, "_session", guest.ID, "moved-"+guest.ID)
+	assert.Nil(t, err)
+
+	_, err = loadByID(store, guest.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	moved, err := loadByID(store, "moved-"+guest.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "apple", moved.Values["cart"])
+
+	newResp, err := store.Client.Get(ctx, store.key(moved.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, oldResp.Kvs[0].Lease, newResp.Kvs[0].Lease, "lease is kept")
+
+	// merge into an existing session
+	guest = newSavedSession(t, store, map[interface{}]interface{}{"cart": "pear"})
+	user := newSavedSession(t, store, map[interface{}]interface{}{"user": "alice"})
+	store.MergeValues = func(dst, src map[interface{}]interface{}) {
+		for k, v := range src {
+			dst[k] = v
+		}
+	}
+	defer func() { store.MergeValues = nil }()
+
+	err = store.MoveSession(ctx, "_session", guest.ID, user.ID)
+	assert.Nil(t, err)
+	merged, err := loadByID(store, user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "pear", merged.Values["cart"])
+	assert.Equal(t, "alice", merged.Values["user"])
+
+	// the old session is gone
+	err = store.MoveSession(ctx, "_session", guest.ID, user.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_MoveSessionAux(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	sink := make(ChanSink, 10)
+	s.Events = sink
+
+	guest := newSavedSession(t, s, map[interface{}]interface{}{"cart": "apple"})
+	assert.Nil(t, SetLabel(guest, "region", "eu"))
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), guest))
+	assert.Nil(t, s.PutAux(ctx, guest, "csrf", []byte("token")))
+	user := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	assert.Nil(t, SetLabel(user, "plan", "pro"))
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), user))
+	assert.Nil(t, s.PutAux(ctx, user, "remember", []byte("me")))
+	for len(sink) > 0 {
+		<-sink
+	}
+
+	s.MergeValues = func(dst, src map[interface{}]interface{}) {
+		dst["cart"] = src["cart"]
+	}
+	assert.Nil(t, s.MoveSession(ctx, "_session", guest.ID, user.ID))
+	merged, err := loadByID(s, user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"region": "eu", "plan": "pro"}, Labels(merged))
+	for suffix, want := range map[string]string{"csrf": "token", "remember": "me"} {
+		value, err := s.GetAux(ctx, merged, suffix)
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(value))
+	}
+	resp, err := store.Client.Get(ctx, s.auxPrefix(guest.ID), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Zero(t, resp.Count, "old aux deleted")
+	main, err := store.Client.Get(ctx, s.key(user.ID))
+	assert.Nil(t, err)
+	aux, err := store.Client.Get(ctx, s.auxPrefix(user.ID), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	for _, kv := range aux.Kvs {
+		assert.Equal(t, main.Kvs[0].Lease, kv.Lease, "on the moved lease")
+	}
+	assert.Equal(t, EventDeleted, (<-sink).Type)
+	event := <-sink
+	assert.Equal(t, EventSaved, event.Type)
+	assert.Equal(t, user.ID[:eventIDLength], event.ID)
+
+	// replaced, the other session's aux goes
+	s.MergeValues = nil
+	other := newSavedSession(t, s, nil)
+	assert.Nil(t, s.MoveSession(ctx, "_session", other.ID, user.ID))
+	replaced, err := loadByID(s, user.ID)
+	assert.Nil(t, err)
+	_, err = s.GetAux(ctx, replaced, "remember")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// an ID reaching other keys is refused
+	err = s.MoveSession(ctx, "_session", user.ID, "x"+s.keyDelimiter+"y")
+	assert.NotNil(t, err)
+	_, err = loadByID(s, user.ID)
+	assert.Nil(t, err)
+}
+
+func TestEtcdStore_OpOptions(t *testing.T) {
+	defer func() {
+		store.GetOptions = nil
+		store.PutOptions = nil
+	}()
+
+	store.GetOptions = []clientv3.OpOption{clientv3.WithSerializable()}
+	store.PutOptions = []clientv3.OpOption{clientv3.WithPrevKV()}
+	session := newSavedSession(t, store, map[interface{}]interface{}{"foo": "bar"})
+	loaded, err := loadByID(store, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	store.GetOptions = []clientv3.OpOption{clientv3.WithPrefix()}
+	_, err = loadByID(store, session.ID)
+	assert.True(t, errors.Is(err, ErrInvalidOpOption))
+
+	store.GetOptions = []clientv3.OpOption{clientv3.WithCountOnly()}
+	_, err = loadByID(store, session.ID)
+	assert.True(t, errors.Is(err, ErrInvalidOpOption))
+
+	store.PutOptions = []clientv3.OpOption{clientv3.WithPrefix()}
+	err = store.save(context.Background(), session, false)
+	assert.True(t, errors.Is(err, ErrInvalidOpOption))
+}
+
+func TestEtcdStore_DeleteCorrupt(t *testing.T) {
+	session := newSavedSession(t, store, map[interface{}]interface{}{"foo": "bar"})
+	key := store.key(session.ID)
+	_, err := store.Client.Put(context.Background(), key, "truncated")
+	assert.Nil(t, err)
+
+	encoded, err := securecookie.EncodeMulti("_session", session.ID, store.Codecs...)
+	assert.Nil(t, err)
+	newReq := func() *http.Request {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+		assert.Nil(t, err, "http new request")
+		req.AddCookie(&http.Cookie{Name: "_session", Value: encoded})
+		return req
+	}
+
+	// the decode error is returned by default
+	_, err = store.New(newReq(), "_session")
+	var codecErr securecookie.Error
+	assert.True(t, errors.As(err, &codecErr))
+
+	var corrupted []string
+	store.DeleteCorrupt = true
+	store.OnCorrupt = func(key string) { corrupted = append(corrupted, key) }
+	defer func() {
+		store.DeleteCorrupt = false
+		store.OnCorrupt = nil
+	}()
+
+	fresh, err := store.New(newReq(), "_session")
+	assert.Nil(t, err)
+	assert.True(t, fresh.IsNew)
+	assert.Empty(t, fresh.ID)
+	assert.Len(t, fresh.Values, 0)
+	assert.Equal(t, []string{key}, corrupted)
+
+	resp, err := store.Client.Get(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Zero(t, resp.Count, "corrupt key is deleted")
+}
+
+func TestEtcdStore_Hooks(t *testing.T) {
+	s := newTestStore(t)
+	s.BeforeSave = func(session *sessions.Session) {
+		delete(session.Values, "transient")
+	}
+	s.AfterLoad = func(session *sessions.Session) {
+		session.Values["transient"] = "recomputed for " + session.Values["user"].(string)
+	}
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice", "transient": "large blob"})
+
+	resp, err := s.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+	stored := make(map[interface{}]interface{})
+	assert.Nil(t, s.decode("_session", session.ID, resp.Kvs[0].Value, &stored))
+	assert.Equal(t, map[interface{}]interface{}{"user": "alice"}, stored)
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "recomputed for alice", loaded.Values["transient"])
+}
+
+func TestEtcdStore_SetKeyDelimiter(t *testing.T) {
+	s := newTestStore(t)
+	assert.Nil(t, s.SetKeyDelimiter(":"))
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := s.Client.Get(context.Background(), s.keyPrefix+":"+session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	ids, err := s.FilterSessions(context.Background(), "_session", func(*sessions.Session) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids)
+
+	for _, delim := range []string{"", "\x00", "\n", "A", "-2-", "="} {
+		err := s.SetKeyDelimiter(delim)
+		assert.True(t, errors.Is(err, ErrInvalidKeyDelimiter), delim)
+	}
+	assert.Equal(t, ":", s.keyDelimiter)
+}
+
+func TestEtcdStore_SaveEntropyFailure(t *testing.T) {
+	generateRandomKey = func(int) []byte { return nil }
+	defer func() { generateRandomKey = securecookie.GenerateRandomKey }()
+
+	s := newTestStore(t)
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+
+	rsp := httptest.NewRecorder()
+	err = s.Save(req, rsp, session)
+	assert.True(t, errors.Is(err, ErrEntropyFailure))
+	assert.Equal(t, "", session.ID)
+	assert.Len(t, rsp.Result().Cookies(), 0, "no cookie for a broken session")
+
+	resp, err := store.Client.Get(context.Background(), s.keyPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "nothing written")
+}
+
+func TestEtcdStore_RejectIDCollision(t *testing.T) {
+	// IDs are made of the next byte of seq
+	var seq []byte
+	generateRandomKey = func(n int) []byte {
+		b := seq[0]
+		seq = seq[1:]
+		return bytes.Repeat([]byte{b}, n)
+	}
+	defer func() { generateRandomKey = securecookie.GenerateRandomKey }()
+
+	for _, configure := range []func(*EtcdStore){
+		func(*EtcdStore) {},
+		func(s *EtcdStore) { s.Buckets = 1 },
+		func(s *EtcdStore) { s.UserIDKey = "user" },
+	} {
+		s := newTestStore(t)
+		configure(s)
+		seq = []byte{0, 0, 0, 1, 0, 1}
+		first := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+
+		// without the option, the second session takes over the first's ID
+		second := newSavedSession(t, s, map[interface{}]interface{}{"user": "bob"})
+		assert.Equal(t, first.ID, second.ID)
+
+		s.RejectIDCollision = true
+		s.IDCollisionRetries = 1
+		third := newSavedSession(t, s, map[interface{}]interface{}{"user": "carol"})
+		assert.NotEqual(t, first.ID, third.ID, "a fresh ID after the collision")
+		loaded, err := loadByID(s, third.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "carol", loaded.Values["user"])
+
+		// the retry collides too
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+		assert.Nil(t, err, "http new request")
+		session, err := s.New(req, "_session")
+		assert.Nil(t, err)
+		session.Values["user"] = "dave"
+		assert.ErrorIs(t, s.Save(req, httptest.NewRecorder(), session), ErrIDCollision)
+		loaded, err = loadByID(s, first.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", loaded.Values["user"], "not overwritten")
+	}
+}
+
+func TestEtcdStore_WithPrefix(t *testing.T) {
+	s := newTestStore(t)
+	web := s.WithPrefix(s.keyPrefix + "/web/")
+	api := s.WithPrefix(s.keyPrefix + "/api")
+	assert.Equal(t, s.keyPrefix+"/web", web.keyPrefix, "normalized")
+
+	session := newSavedSession(t, web, map[interface{}]interface{}{"foo": "bar"})
+	loaded, err := loadByID(web, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	_, err = loadByID(api, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "isolated")
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// configured apart, and closed without the client
+	api.Options.MaxAge = 60
+	assert.Equal(t, 86400*30, web.Options.MaxAge)
+	assert.Nil(t, web.Close())
+	newSavedSession(t, api, nil)
+}
+
+func TestEtcdStore_DeleteWhenEmpty(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.DeleteWhenEmpty = true
+	s.TrackCreation = true
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"cart": "apple"})
+	delete(session.Values, "cart")
+	w := httptest.NewRecorder()
+	assert.Nil(t, s.Save(nil, w, session))
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "key gone")
+	if cookies := w.Result().Cookies(); assert.Len(t, cookies, 1) {
+		assert.Equal(t, "", cookies[0].Value)
+		assert.True(t, cookies[0].MaxAge < 0, "cookie expired")
+	}
+
+	// saved again, e.g. by a later middleware, or never stored at all
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	fresh, err := s.New(httptest.NewRequest("GET", "/", nil), "_session")
+	assert.Nil(t, err)
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), fresh))
+	assert.Equal(t, "", fresh.ID, "not written")
+}
@@ -0,0 +1,155 @@
+package etcdstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEventQueueSize is how many events wait for the sink when Events is
+// set and EventQueueSize isn't.
+const DefaultEventQueueSize = 1024
+
+// EventType is what happened to a session.
+type EventType int
+
+const (
+	// EventCreated is published when a new session is first saved.
+	EventCreated EventType = iota + 1
+	// EventSaved is published when an existing session is saved.
+	EventSaved
+	// EventDeleted is published when Save deletes a session because of a
+	// negative MaxAge, MaxSessionsPerUser evicts it, or MoveSession moves it
+	// to another ID.
+	EventDeleted
+	// EventExpired is published when the store expires a session itself:
+	// past its IdleTimeout, or by ExpireAt with a time already past. Leases
+	// running out are etcd's doing and aren't reported.
+	EventExpired
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventSaved:
+		return "saved"
+	case EventDeleted:
+		return "deleted"
+	case EventExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// eventIDLength is how much of the session ID events carry.
+const eventIDLength = 8
+
+// Event is a session lifecycle event.
+type Event struct {
+	Type EventType
+	Name string
+
+	// ID is the start of the session ID, enough to correlate events of a
+	// session but not to take it over.
+	ID string
+
+	Time time.Time
+}
+
+// EventSink receives the events of a store, e.g. to forward them to Kafka,
+// NSQ or a webhook. Publish is called from a single goroutine, in order.
+type EventSink interface {
+	Publish(event Event)
+}
+
+// NopSink drops every event.
+type NopSink struct{}
+
+func (NopSink) Publish(Event) {}
+
+// ChanSink sends events on the channel. A send blocks until it is received,
+// and the store's queue fills meanwhile, so give the channel a buffer or
+// keep reading it.
+type ChanSink chan Event
+
+func (c ChanSink) Publish(event Event) {
+	c <- event
+}
+
+// events queues the events of a store for its sink.
+type events struct {
+	mu      sync.RWMutex
+	queue   chan Event
+	closed  bool
+	start   sync.Once
+	dropped uint64
+}
+
+// publish queues an event of type t for the session called name, without
+// waiting: when the queue is full, the event is dropped.
+func (s *EtcdStore) publish(t EventType, name, id string) {
+	if s.Events == nil {
+		return
+	}
+
+	e := &s.events
+	e.start.Do(func() {
+		size := s.EventQueueSize
+		if size <= 0 {
+			size = DefaultEventQueueSize
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.closed {
+			return
+		}
+		e.queue = make(chan Event, size)
+		go func(sink EventSink, queue <-chan Event, done <-chan struct{}) {
+			for {
+				select {
+				case event, ok := <-queue:
+					if !ok {
+						return
+					}
+					sink.Publish(event)
+				case <-done:
+					return
+				}
+			}
+		}(s.Events, e.queue, s.background().Done())
+	})
+
+	if len(id) > eventIDLength {
+		id = id[:eventIDLength]
+	}
+	event := Event{Type: t, Name: name, ID: id, Time: s.clock()}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- event:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// close stops queueing events. Those already queued are still published.
+func (e *events) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.queue != nil && !e.closed {
+		close(e.queue)
+	}
+	e.closed = true
+}
+
+// DroppedEvents returns how many events were dropped because the queue for
+// the sink was full.
+func (s *EtcdStore) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&s.events.dropped)
+}
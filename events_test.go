@@ -0,0 +1,66 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_Events(t *testing.T) {
+	s := newTestStore(t)
+	sink := make(ChanSink, 10)
+	s.Events = sink
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	next := func() Event {
+		select {
+		case event := <-sink:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	event := next()
+	assert.Equal(t, Event{Type: EventCreated, Name: "_session", ID: session.ID[:eventIDLength], Time: now}, event)
+
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	assert.Equal(t, EventSaved, next().Type)
+
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	assert.Equal(t, EventDeleted, next().Type)
+
+	s.IdleTimeout = time.Minute
+	idle := newSavedSession(t, s, nil)
+	assert.Equal(t, EventCreated, next().Type)
+	now = now.Add(time.Hour)
+	_, err := loadByID(s, idle.ID)
+	assert.ErrorIs(t, err, ErrIdleTimeout)
+	event = next()
+	assert.Equal(t, EventExpired, event.Type)
+	assert.Equal(t, "expired", event.Type.String())
+}
+
+func TestEtcdStore_EventsDontBlock(t *testing.T) {
+	s := newTestStore(t)
+	s.Events = make(ChanSink) // never read
+	s.EventQueueSize = 1
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	assert.Eventually(t, func() bool { return len(s.events.queue) == 0 }, time.Second, time.Millisecond, "stuck in the sink")
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	}
+	assert.Equal(t, uint64(3), s.DroppedEvents(), "one waits in the queue")
+}
@@ -0,0 +1,51 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Exists reports whether a session is stored under id, with a count-only
+// Get: the value is neither fetched nor decoded, so it is cheaper than a
+// load and doesn't need the codec keys, e.g. for an auth gate that only
+// checks that a session ID is still valid. An ID that can't name a session
+// isn't an error, it just doesn't exist. For a bucketed session, its
+// bucket is read, though the session still isn't decoded.
+//
+// Only the key's lease is checked: a session past IdleTimeout still exists
+// until it is loaded.
+func (s *EtcdStore) Exists(ctx context.Context, id string) (bool, error) {
+	if s.checkPlainID(id) != nil {
+		return false, nil
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+
+	var found bool
+	if s.Buckets > 0 {
+		err := s.retry(ctx, func() error {
+			_, err := s.loadBucketed(ctx, id)
+			found = err == nil
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		})
+		return found, err
+	}
+
+	err = s.retry(ctx, func() error {
+		resp, err := s.get(ctx, s.key(id), s.readOpts(ReadLoad, clientv3.WithCountOnly())...)
+		if err != nil {
+			return err
+		}
+		found = resp.Count > 0
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,46 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_Exists(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, nil)
+
+	found, err := s.Exists(ctx, session.ID)
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	// no need for the value to decode
+	_, err = store.Client.Put(ctx, s.key("garbage"), "not a session")
+	assert.Nil(t, err)
+	found, err = s.Exists(ctx, "garbage")
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	for _, id := range []string{"missing", "", "_users", "a/b"} {
+		found, err = s.Exists(ctx, id)
+		assert.Nil(t, err)
+		assert.False(t, found, id)
+	}
+
+	s.Buckets = 1
+	bucketed := newSavedSession(t, s, nil)
+	found, err = s.Exists(ctx, bucketed.ID)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	found, err = s.Exists(ctx, session.ID)
+	assert.Nil(t, err)
+	assert.False(t, found, "looked for in its bucket")
+	_, err = store.Client.Delete(ctx, s.bucketKey(bucketed.ID), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	found, err = s.Exists(ctx, bucketed.ID)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,78 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// ExpireAt makes session expire at t, e.g. at the end of a maintenance
+// window, regardless of its MaxAge. Its value is kept and moved to a new
+// lease of the remaining time, rounded up to the second and at least one,
+// along with its auxiliary data and user index entry. A session whose t has
+// already passed is deleted right away.
+//
+// A later Save moves the session back to a lease of its MaxAge.
+func (s *EtcdStore) ExpireAt(ctx context.Context, session *sessions.Session, t time.Time) error {
+	return s.sessionError("expire", session.Name(), s.expireAt(ctx, session, t))
+}
+
+func (s *EtcdStore) expireAt(ctx context.Context, session *sessions.Session, t time.Time) error {
+	now := s.clock()
+	if !t.After(now) {
+		if err := s.delete(ctx, session); err != nil {
+			return err
+		}
+		s.publish(EventExpired, session.Name(), session.ID)
+		return nil
+	}
+
+	ttl := int64((t.Sub(now) + time.Second - 1) / time.Second)
+	if s.Buckets > 0 {
+		return s.updateBucket(ctx, session.ID, func(entries map[string]bucketEntry) error {
+			entry, ok := entries[session.ID]
+			if !ok {
+				return fmt.Errorf("key: %s/%s is %w", s.bucketKey(session.ID), session.ID, ErrNotFound)
+			}
+			entry.Expires = now.Add(time.Duration(ttl) * time.Second).UnixNano()
+			entries[session.ID] = entry
+			return nil
+		})
+	}
+
+	// a lease of its own: a pooled one wouldn't expire on time
+	grant, err := s.grant(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	lease := grant.ID
+
+	key := s.key(session.ID)
+	s.uncache(key)
+	ops := append([]clientv3.Op{clientv3.OpPut(key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease))},
+		append(s.auxLeaseOps(session, lease), s.fieldLeaseOps(session, lease)...)...)
+	if userID, _ := session.Values[indexedUserKey].(string); userID != "" {
+		index := s.userIndexKey(userID, session.ID)
+		ops = append(ops, clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(index), ">", 0)},
+			[]clientv3.Op{clientv3.OpPut(index, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease))},
+			nil))
+	}
+
+	resp, err := s.commit(s.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(ops...))
+	if err != nil {
+		// the txn may have gone through, so the lease can't be revoked
+		return err
+	}
+	if !resp.Succeeded {
+		s.revoke(s.Context, lease)
+		return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+	}
+	session.Values[leaseKey] = int64(lease)
+	return nil
+}
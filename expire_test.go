@@ -0,0 +1,53 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_ExpireAt(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	ttlOf := func(id string) int64 {
+		resp, err := store.Client.Get(ctx, s.key(id))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), resp.Count)
+		lease, err := store.Client.TimeToLive(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+		assert.Nil(t, err)
+		return lease.GrantedTTL
+	}
+
+	// future: the value stays, on a lease of the remaining time
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+	assert.Nil(t, s.ExpireAt(ctx, session, now.Add(90*time.Minute+time.Millisecond)))
+	assert.Equal(t, int64(90*60+1), ttlOf(session.ID))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	aux, err := store.Client.Get(ctx, s.auxPrefix(session.ID)+"csrf")
+	assert.Nil(t, err)
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, resp.Kvs[0].Lease, aux.Kvs[0].Lease, "aux data follows")
+
+	// near now: at least a second, which etcd rounds up to its minimum
+	// lease TTL
+	assert.Nil(t, s.ExpireAt(ctx, session, now.Add(time.Millisecond)))
+	assert.LessOrEqual(t, ttlOf(session.ID), int64(2))
+
+	// past: deleted right away
+	other := newSavedSession(t, s, nil)
+	assert.Nil(t, s.ExpireAt(ctx, other, now.Add(-time.Second)))
+	_, err = loadByID(s, other.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, s.ExpireAt(ctx, other, now.Add(time.Hour)), ErrNotFound)
+}
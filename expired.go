@@ -0,0 +1,65 @@
+package etcdstore
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// expiredBufferSize is how many IDs ExpiredSessions holds for a consumer
+// that falls behind.
+const expiredBufferSize = 64
+
+// ExpiredSessions watches the prefix and sends the ID of each session
+// removed from etcd from then on, as its lease expires or it is deleted,
+// until ctx is done, then closes the channel. The channel is also closed if
+// the watch fails, e.g. because it fell behind a compaction.
+//
+// The channel buffers a few IDs; those that arrive while it is full are
+// dropped, as counted by DroppedExpired, so that a slow consumer can't
+// hold up the watch. It isn't available on gateway stores.
+func (s *EtcdStore) ExpiredSessions(ctx context.Context) (<-chan string, error) {
+	if s.Client == nil {
+		return nil, ErrGatewayUnsupported
+	}
+
+	watch := s.Client.Watch(ctx, s.key(""), clientv3.WithPrefix(), clientv3.WithFilterPut(), clientv3.WithCreatedNotify())
+	// wait for the watch, so that no removal after the return is missed
+	created, ok := <-watch
+	if !ok {
+		return nil, ctx.Err()
+	}
+	if err := created.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make(chan string, expiredBufferSize)
+	go func() {
+		defer close(ids)
+		for resp := range watch {
+			if resp.Err() != nil {
+				return
+			}
+			for _, ev := range resp.Events {
+				id := s.idFromKey(ev.Kv.Key)
+				if strings.HasPrefix(id, reservedPrefix) || strings.Contains(id, s.keyDelimiter) {
+					continue
+				}
+				select {
+				case ids <- id:
+				default:
+					atomic.AddUint64(&s.expiredDropped, 1)
+				}
+			}
+		}
+	}()
+	return ids, nil
+}
+
+// DroppedExpired returns how many IDs ExpiredSessions dropped because the
+// consumer didn't keep up.
+func (s *EtcdStore) DroppedExpired() uint64 {
+	return atomic.LoadUint64(&s.expiredDropped)
+}
@@ -0,0 +1,38 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_ExpiredSessions(t *testing.T) {
+	s := newTestStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ids, err := s.ExpiredSessions(ctx)
+	assert.Nil(t, err)
+
+	session := newSavedSession(t, s, nil)
+	assert.Nil(t, s.PutAux(context.Background(), session, "csrf", []byte("token")))
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+
+	select {
+	case id := <-ids:
+		assert.Equal(t, session.ID, id, "aux data left out")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expired session")
+	}
+
+	cancel()
+	for range ids {
+	}
+
+	memory := NewInMemoryStore("/sessions")
+	_, err = memory.ExpiredSessions(context.Background())
+	assert.True(t, errors.Is(err, ErrGatewayUnsupported))
+}
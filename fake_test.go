@@ -0,0 +1,278 @@
+package etcdstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// faultyKV passes requests through to the wrapped KV, unless err is set, in
+// which case they fail with err without reaching etcd.
+type faultyKV struct {
+	clientv3.KV
+	err   error
+	calls int
+}
+
+func (f *faultyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.KV.Get(ctx, key, opts...)
+}
+
+func (f *faultyKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.KV.Put(ctx, key, val, opts...)
+}
+
+func (f *faultyKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.KV.Delete(ctx, key, opts...)
+}
+
+// countingLease counts the leases granted through the wrapped Lease.
+type countingLease struct {
+	clientv3.Lease
+	mu     sync.Mutex
+	grants int
+}
+
+func (c *countingLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	c.mu.Lock()
+	c.grants++
+	c.mu.Unlock()
+	return c.Lease.Grant(ctx, ttl)
+}
+
+func (c *countingLease) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.grants
+}
+
+// stallingLease grants the first given leases, then holds the grants that
+// follow until their context is done and reports why on done.
+type stallingLease struct {
+	clientv3.Lease
+	mu    sync.Mutex
+	given int
+	done  chan error
+}
+
+func (l *stallingLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	l.mu.Lock()
+	l.given--
+	stall := l.given < 0
+	l.mu.Unlock()
+	if !stall {
+		return l.Lease.Grant(ctx, ttl)
+	}
+	<-ctx.Done()
+	l.done <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+// lateKV applies puts to the wrapped KV but then reports them as timed out,
+// like a client giving up just as etcd commits the write.
+type lateKV struct {
+	clientv3.KV
+}
+
+func (l lateKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	if _, err := l.KV.Put(ctx, key, val, opts...); err != nil {
+		return nil, err
+	}
+	return nil, context.DeadlineExceeded
+}
+
+// slowKV delays puts by delay, signalling started as each one begins.
+type slowKV struct {
+	clientv3.KV
+	delay   time.Duration
+	started chan struct{}
+}
+
+func (s slowKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	s.started <- struct{}{}
+	time.Sleep(s.delay)
+	return s.KV.Put(ctx, key, val, opts...)
+}
+
+// blockingKV holds gets until release is closed, signalling started as
+// each one begins.
+type blockingKV struct {
+	clientv3.KV
+	started chan<- struct{}
+	release <-chan struct{}
+}
+
+func (b blockingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.KV.Get(ctx, key, opts...)
+}
+
+// tickingKV calls tick ahead of each get, e.g. to move a fake clock as if
+// it took that long.
+type tickingKV struct {
+	clientv3.KV
+	tick func()
+}
+
+func (t tickingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	t.tick()
+	return t.KV.Get(ctx, key, opts...)
+}
+
+// blindKV applies puts but fails every get, like a member that lost read
+// permission on the prefix.
+type blindKV struct {
+	clientv3.KV
+}
+
+func (b blindKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return nil, rpctypes.ErrPermissionDenied
+}
+
+// laggingKV serves gets at a fixed revision, like a member that fell
+// behind.
+type laggingKV struct {
+	clientv3.KV
+	rev int64
+}
+
+func (l laggingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return l.KV.Get(ctx, key, append(opts, clientv3.WithRev(l.rev))...)
+}
+
+// flakyKV fails the first failures requests with err.
+type flakyKV struct {
+	clientv3.KV
+	err      error
+	failures int
+	calls    int
+}
+
+func (f *flakyKV) fail() bool {
+	f.calls++
+	return f.calls <= f.failures
+}
+
+func (f *flakyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if f.fail() {
+		return nil, f.err
+	}
+	return f.KV.Get(ctx, key, opts...)
+}
+
+func (f *flakyKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	if f.fail() {
+		return nil, f.err
+	}
+	return f.KV.Put(ctx, key, val, opts...)
+}
+
+func (f *flakyKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	if f.fail() {
+		return nil, f.err
+	}
+	return f.KV.Delete(ctx, key, opts...)
+}
+
+// consistencyKV records whether the last get of each key was serializable.
+type consistencyKV struct {
+	clientv3.KV
+	mu           sync.Mutex
+	serializable map[string]bool
+}
+
+func (c *consistencyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	c.mu.Lock()
+	if c.serializable == nil {
+		c.serializable = make(map[string]bool)
+	}
+	c.serializable[key] = clientv3.OpGet(key, opts...).IsSerializable()
+	c.mu.Unlock()
+	return c.KV.Get(ctx, key, opts...)
+}
+
+func (c *consistencyKV) wasSerializable(key string) (serializable, read bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	serializable, read = c.serializable[key]
+	return serializable, read
+}
+
+// pagingKV records how many keys each ranged get returned.
+type pagingKV struct {
+	clientv3.KV
+	mu    sync.Mutex
+	pages []int
+}
+
+func (p *pagingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := p.KV.Get(ctx, key, opts...)
+	if err == nil && len(clientv3.OpGet(key, opts...).RangeBytes()) > 0 {
+		p.mu.Lock()
+		p.pages = append(p.pages, len(resp.Kvs))
+		p.mu.Unlock()
+	}
+	return resp, err
+}
+
+// countingKV counts the requests sent through the wrapped KV, by kind.
+type countingKV struct {
+	clientv3.KV
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingKV) add(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[kind]++
+}
+
+func (c *countingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	c.add("get")
+	return c.KV.Get(ctx, key, opts...)
+}
+
+func (c *countingKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	c.add("put")
+	return c.KV.Put(ctx, key, val, opts...)
+}
+
+func (c *countingKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	c.add("delete")
+	return c.KV.Delete(ctx, key, opts...)
+}
+
+func (c *countingKV) Txn(ctx context.Context) clientv3.Txn {
+	c.add("txn")
+	return c.KV.Txn(ctx)
+}
+
+func (c *countingKV) snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls := make(map[string]int, len(c.calls))
+	for kind, n := range c.calls {
+		calls[kind] = n
+	}
+	return calls
+}
@@ -0,0 +1,284 @@
+package etcdstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// fieldSegment starts, below a session's key, the keys its values are
+// stored under with FieldKeys, apart from its auxiliary data.
+const fieldSegment = reservedPrefix + "field"
+
+// fieldKeys reports whether values are stored in field keys: FieldKeys is
+// set and the store uses per-session keys and its own encoding.
+func (s *EtcdStore) fieldKeys() bool {
+	return s.FieldKeys && s.Buckets == 0 && s.RedistoreFormat == nil
+}
+
+// isField reports whether the values key k is stored in a field key rather
+// than in the session's key.
+func isField(k interface{}) bool {
+	name, ok := k.(string)
+	return ok && !strings.HasPrefix(name, ReservedValuesPrefix)
+}
+
+// fieldPrefix returns the prefix of the field keys of session id.
+func (s *EtcdStore) fieldPrefix(id string) string {
+	return s.auxPrefix(id) + fieldSegment + s.keyDelimiter
+}
+
+// fieldDigests returns the digests recorded in session's values.
+func fieldDigests(session *sessions.Session) map[string]string {
+	digests, _ := session.Values[fieldsKey].(map[string]string)
+	return digests
+}
+
+// fieldDigest returns the digest of value stored under field, over its
+// serialized form.
+func (s *EtcdStore) fieldDigest(field string, value interface{}) (string, error) {
+	b, err := s.valueSerializer().Serialize(map[interface{}]interface{}{field: value})
+	if err != nil {
+		return "", fmt.Errorf("%w: key %q holds %T: %v", ErrUnencodableValue, field, value, err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fieldWrite is what Save does to a field key: put value, or with keep
+// move it to the session's lease as it is, or with remove delete it.
+type fieldWrite struct {
+	field  string
+	value  string
+	keep   bool
+	remove bool
+}
+
+// fieldWrites returns the writes bringing the field keys of session in line
+// with its values, along with the digests to record once they are done.
+// Only the values changed since the last load or save are encoded.
+func (s *EtcdStore) fieldWrites(session *sessions.Session) ([]fieldWrite, map[string]string, error) {
+	prev := fieldDigests(session)
+	digests := make(map[string]string)
+	var writes []fieldWrite
+	for k, v := range session.Values {
+		if !isField(k) {
+			continue
+		}
+		field := k.(string)
+		digest, err := s.fieldDigest(field, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		digests[field] = digest
+		if prev[field] == digest {
+			writes = append(writes, fieldWrite{field: field, keep: true})
+			continue
+		}
+		encoded, err := s.sealField(session.Name(), session.ID, field, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		writes = append(writes, fieldWrite{field: field, value: encoded})
+	}
+	for field := range prev {
+		if _, ok := digests[field]; !ok {
+			writes = append(writes, fieldWrite{field: field, remove: true})
+		}
+	}
+	return writes, digests, nil
+}
+
+// sealField returns what the field key of field holds for value, in
+// session id called name.
+func (s *EtcdStore) sealField(name, id, field string, value interface{}) (string, error) {
+	stored := map[interface{}]interface{}{field: value}
+	if s.EmbedID {
+		stored[idKey] = id
+	}
+	return s.seal(name, stored)
+}
+
+// fieldOps returns the ops carrying out writes for session id on lease.
+// Moves are guarded, so a value deleted meanwhile isn't recreated and
+// doesn't fail the save.
+func (s *EtcdStore) fieldOps(id string, writes []fieldWrite, lease clientv3.LeaseID) []clientv3.Op {
+	ops := make([]clientv3.Op, 0, len(writes))
+	for _, w := range writes {
+		key := s.fieldPrefix(id) + w.field
+		switch {
+		case w.remove:
+			ops = append(ops, clientv3.OpDelete(key))
+		case w.keep:
+			ops = append(ops, clientv3.OpTxn(
+				[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(key), ">", 0)},
+				[]clientv3.Op{clientv3.OpPut(key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease))},
+				nil))
+		default:
+			ops = append(ops, clientv3.OpPut(key, w.value, clientv3.WithLease(lease)))
+		}
+	}
+	return ops
+}
+
+// rewriteFieldOps returns the ops bringing the field keys of session on
+// lease in line with its values, for the calls writing it whole from
+// session.Values: the field keys it holds no value for are deleted, whether
+// it was loaded with them or not. It also returns the digests to record
+// once they are done.
+func (s *EtcdStore) rewriteFieldOps(ctx context.Context, session *sessions.Session, lease clientv3.LeaseID) ([]clientv3.Op, map[string]string, error) {
+	writes, digests, err := s.fieldWrites(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := s.fieldPrefix(session.ID)
+	resp, err := s.get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, nil, err
+	}
+	prev := fieldDigests(session)
+	for _, kv := range resp.Kvs {
+		field := strings.TrimPrefix(string(kv.Key), prefix)
+		_, kept := digests[field]
+		if _, removed := prev[field]; !kept && !removed {
+			writes = append(writes, fieldWrite{field: field, remove: true})
+		}
+	}
+	return s.fieldOps(session.ID, writes, lease), digests, nil
+}
+
+// setFieldDigests records digests in the values of session, once its field
+// keys hold the values they were computed from.
+func setFieldDigests(session *sessions.Session, digests map[string]string) {
+	if len(digests) > 0 {
+		session.Values[fieldsKey] = digests
+	} else {
+		delete(session.Values, fieldsKey)
+	}
+}
+
+// fieldLeaseOps returns the ops moving the field keys of session recorded
+// by its last load or save to lease, like auxLeaseOps.
+func (s *EtcdStore) fieldLeaseOps(session *sessions.Session, lease clientv3.LeaseID) []clientv3.Op {
+	writes := make([]fieldWrite, 0, len(fieldDigests(session)))
+	for field := range fieldDigests(session) {
+		writes = append(writes, fieldWrite{field: field, keep: true})
+	}
+	return s.fieldOps(session.ID, writes, lease)
+}
+
+// loadFields reads the field keys of session into its values and records
+// their digests.
+func (s *EtcdStore) loadFields(ctx context.Context, session *sessions.Session) error {
+	var resp *clientv3.GetResponse
+	err := s.retry(ctx, func() (err error) {
+		resp, err = s.get(ctx, s.fieldPrefix(session.ID), s.readOpts(ReadLoad, clientv3.WithPrefix())...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	digests, err := s.readFields(session.Name(), session.ID, resp.Kvs, session.Values)
+	if err != nil {
+		return err
+	}
+	setFieldDigests(session, digests)
+	return nil
+}
+
+// readFields decodes kvs, field keys of session id called name, into
+// values and returns their digests.
+func (s *EtcdStore) readFields(name, id string, kvs []*mvccpb.KeyValue, values map[interface{}]interface{}) (map[string]string, error) {
+	prefix := s.fieldPrefix(id)
+	digests := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		field := strings.TrimPrefix(string(kv.Key), prefix)
+		var stored map[interface{}]interface{}
+		if err := s.decode(name, id, kv.Value, &stored); err != nil {
+			return nil, err
+		}
+		value, ok := stored[field]
+		if !ok || len(stored) != 1 {
+			return nil, fmt.Errorf("%w: field key %s holds another value", ErrCorruptValue, kv.Key)
+		}
+		digest, err := s.fieldDigest(field, value)
+		if err != nil {
+			return nil, err
+		}
+		values[field] = value
+		digests[field] = digest
+	}
+	return digests, nil
+}
+
+// storedValues returns the values of session id called name, decoded from
+// value, the session's key, and from its field keys, which it returns too.
+func (s *EtcdStore) storedValues(ctx context.Context, name, id string, value []byte) (map[interface{}]interface{}, []*mvccpb.KeyValue, error) {
+	values := make(map[interface{}]interface{})
+	if err := s.decode(name, id, value, &values); err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.get(ctx, s.fieldPrefix(id), clientv3.WithPrefix())
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := s.readFields(name, id, resp.Kvs, values); err != nil {
+		return nil, nil, err
+	}
+	return values, resp.Kvs, nil
+}
+
+// moveFields is MoveSession under FieldKeys, for the session called name
+// stored in src moving from oldID to newID, which dst holds unless nil. It
+// returns the value to store under newID, with the ops writing its field
+// keys there on lease and deleting those of newID it no longer has a value
+// for; those of oldID go with its auxiliary data, see moveAuxOps.
+func (s *EtcdStore) moveFields(ctx context.Context, name, oldID, newID string, src, dst *mvccpb.KeyValue, lease clientv3.LeaseID) (string, []clientv3.Op, error) {
+	values, _, err := s.storedValues(ctx, name, oldID, src.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	var replaced []*mvccpb.KeyValue
+	if dst != nil {
+		dstValues, fields, err := s.storedValues(ctx, name, newID, dst.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		if s.MergeValues != nil {
+			s.MergeValues(dstValues, values)
+			mergeKept(dstValues, values)
+			values = dstValues
+		}
+		replaced = fields
+	}
+
+	encoded, err := s.encode(name, newID, values)
+	if err != nil {
+		return "", nil, err
+	}
+	prefix := s.fieldPrefix(newID)
+	var ops []clientv3.Op
+	for k, v := range values {
+		if !isField(k) {
+			continue
+		}
+		field, err := s.sealField(name, newID, k.(string), v)
+		if err != nil {
+			return "", nil, err
+		}
+		ops = append(ops, clientv3.OpPut(prefix+k.(string), field, clientv3.WithLease(lease)))
+	}
+	for _, kv := range replaced {
+		if _, ok := values[strings.TrimPrefix(string(kv.Key), prefix)]; !ok {
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+		}
+	}
+	return encoded, ops, nil
+}
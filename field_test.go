@@ -0,0 +1,222 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_FieldKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.RotateKeys([]byte("secret"), []byte("0123456789abcdef"))
+	s.serializer = JSONSerializer{}
+	setSerializer(s, s.serializer)
+	s.FieldKeys = true
+
+	large := strings.Repeat("x", 1024)
+	session := newSavedSession(t, s, map[interface{}]interface{}{
+		"large": large,
+		"cart":  []interface{}{"apple"},
+		"prefs": map[string]interface{}{"theme": "dark"},
+	})
+	fields := func() map[string]*mvccpb.KeyValue {
+		resp, err := store.Client.Get(ctx, s.fieldPrefix(session.ID), clientv3.WithPrefix())
+		assert.Nil(t, err)
+		byField := make(map[string]*mvccpb.KeyValue)
+		for _, kv := range resp.Kvs {
+			byField[strings.TrimPrefix(string(kv.Key), s.fieldPrefix(session.ID))] = kv
+		}
+		return byField
+	}
+	stored := fields()
+	assert.Len(t, stored, 3, "a key per value")
+
+	main, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Less(t, len(main.Kvs[0].Value), len(large), "values left out of the session's key")
+	for _, kv := range stored {
+		assert.Equal(t, main.Kvs[0].Lease, kv.Lease, "on the session's lease")
+	}
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, large, loaded.Values["large"])
+	assert.Equal(t, []interface{}{"apple"}, loaded.Values["cart"])
+	assert.Equal(t, map[string]interface{}{"theme": "dark"}, loaded.Values["prefs"])
+
+	// only the changed value is written again
+	loaded.Options.MaxAge = 60
+	loaded.Values["cart"] = []interface{}{"apple", "pear"}
+	delete(loaded.Values, "prefs")
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+	saved := fields()
+	assert.Len(t, saved, 2, "removed value deleted")
+	assert.Equal(t, stored["large"].Value, saved["large"].Value, "unchanged value kept")
+	assert.NotEqual(t, stored["cart"].Value, saved["cart"].Value)
+	main, err = store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	for _, kv := range saved {
+		assert.Equal(t, main.Kvs[0].Lease, kv.Lease, "moved to the new lease")
+	}
+
+	reloaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"apple", "pear"}, reloaded.Values["cart"])
+	assert.NotContains(t, reloaded.Values, "prefs")
+
+	// a value moved to another field key is rejected
+	_, err = store.Client.Put(ctx, s.fieldPrefix(session.ID)+"other", string(saved["cart"].Value))
+	assert.Nil(t, err)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrCorruptValue), "%v", err)
+	_, err = store.Client.Delete(ctx, s.fieldPrefix(session.ID)+"other")
+	assert.Nil(t, err)
+
+	// and follow the session to a new lease
+	assert.Nil(t, s.ExpireAt(ctx, reloaded, time.Now().Add(time.Hour)))
+	main, err = store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	for _, kv := range fields() {
+		assert.Equal(t, main.Kvs[0].Lease, kv.Lease, "moved by ExpireAt")
+	}
+
+	// deleting the session deletes its values
+	reloaded.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), reloaded))
+	assert.Len(t, fields(), 0)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_FieldKeysTurnedOn(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar", 1: "int key"})
+
+	s.FieldKeys = true
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"], "read from the session's key")
+	loaded.Options.MaxAge = 60
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+
+	resp, err := store.Client.Get(ctx, s.fieldPrefix(session.ID), clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count, "moved to a field key")
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, "int key", loaded.Values[1], "other keys stay in the session's key")
+}
+
+func TestEtcdStore_FieldKeysIncrAndSwap(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.FieldKeys = true
+	session := newSavedSession(t, s, map[interface{}]interface{}{"hits": int64(1), "cart": "apple"})
+	before, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+
+	n, err := s.IncrValue(ctx, session, "hits", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), loaded.Values["hits"], "written to its field key")
+	after, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, before.Kvs[0].Value, after.Kvs[0].Value)
+	assert.Greater(t, after.Kvs[0].ModRevision, before.Kvs[0].ModRevision, "the session's revision moves")
+
+	// a stale revision is refused, the current one swaps every field
+	swapped, err := s.CompareAndSwap(ctx, loaded, before.Kvs[0].ModRevision)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+	rebuilt := sessions.NewSession(s, "_session")
+	rebuilt.ID = session.ID
+	rebuilt.Values["cart"] = "pear"
+	swapped, err = s.CompareAndSwap(ctx, rebuilt, after.Kvs[0].ModRevision)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "pear", loaded.Values["cart"])
+	assert.NotContains(t, loaded.Values, "hits", "dropped field deleted")
+
+	s.UserIDKey = "user"
+	assert.Nil(t, s.ReassignUser(ctx, loaded, "bob"))
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", loaded.Values["user"], "reassigned in its field key")
+	assert.Equal(t, "pear", loaded.Values["cart"])
+}
+
+func TestEtcdStore_FieldKeysMoveSession(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.FieldKeys = true
+	s.EmbedID = true
+
+	guest := newSavedSession(t, s, map[interface{}]interface{}{"cart": "apple"})
+	assert.Nil(t, s.MoveSession(ctx, "_session", guest.ID, "moved"))
+	moved, err := loadByID(s, "moved")
+	assert.Nil(t, err)
+	assert.Equal(t, "apple", moved.Values["cart"])
+	resp, err := store.Client.Get(ctx, s.auxPrefix(guest.ID), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Zero(t, resp.Count, "old field keys deleted")
+
+	// merged into a session of its own, whose other fields go
+	s.MergeValues = func(dst, src map[interface{}]interface{}) {
+		dst["cart"] = src["cart"]
+		delete(dst, "theme")
+	}
+	other := newSavedSession(t, s, map[interface{}]interface{}{"cart": "pear"})
+	user := newSavedSession(t, s, map[interface{}]interface{}{"theme": "dark", "lang": "en"})
+	assert.Nil(t, s.MoveSession(ctx, "_session", other.ID, user.ID))
+	merged, err := loadByID(s, user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "pear", merged.Values["cart"])
+	assert.Equal(t, "en", merged.Values["lang"])
+	assert.NotContains(t, merged.Values, "theme")
+}
+
+func TestEtcdStore_FieldKeysConsumeAndUndelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.FieldKeys = true
+
+	token := newSavedSession(t, s, map[interface{}]interface{}{"email": "a@example.com"})
+	consumed, err := s.Consume(ctx, "_session", token.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "a@example.com", consumed.Values["email"])
+	resp, err := store.Client.Get(ctx, s.auxPrefix(token.ID), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Zero(t, resp.Count)
+
+	s.SoftDeleteGrace = time.Minute
+	session := newSavedSession(t, s, map[interface{}]interface{}{"cart": "apple"})
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Nil(t, s.Undelete(ctx, session.ID))
+	restored, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "apple", restored.Values["cart"], "field keys restored")
+
+	ids, err := s.FilterSessions(ctx, "_session", func(session *sessions.Session) bool {
+		return session.Values["cart"] == "apple"
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids)
+}
@@ -0,0 +1,179 @@
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrGatewayUnsupported is returned for lease keep-alives, which the store
+// can't run over the HTTP gateway.
+var ErrGatewayUnsupported = errors.New("etcdstore: operation not supported over the etcd gateway")
+
+// NewEtcdGatewayStore returns a store that talks to etcd through its v3
+// HTTP/JSON gateway at endpoint (e.g. "http://127.0.0.1:2379") instead of
+// gRPC, for environments where only the gateway is reachable. A nil
+// httpClient means http.DefaultClient.
+//
+// The store behaves as one returned by NewEtcdStore, except that its Client
+// field is nil and lease keep-alives fail with ErrGatewayUnsupported.
+func NewEtcdGatewayStore(endpoint string, httpClient *http.Client, ctx context.Context, prefix string, keyPairs ...[]byte) (*EtcdStore, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("etcdstore: invalid gateway endpoint %q", endpoint)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	gw := &gateway{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   httpClient,
+	}
+	return newStore(nil, clientv3.NewKVFromKVClient(gw, nil), gw, ctx, prefix, keyPairs...), nil
+}
+
+// gateway implements the etcd KV service and the store's lease needs on top
+// of the gRPC gateway's JSON API.
+type gateway struct {
+	endpoint string
+	client   *http.Client
+}
+
+// call posts req as JSON to the gateway path and decodes the reply into resp.
+// Gateway errors are turned back into gRPC status errors so clientv3 maps
+// them to the same errors as over gRPC.
+func (g *gateway) call(ctx context.Context, path string, req, resp proto.Message) error {
+	var body bytes.Buffer
+	if err := (&jsonpb.Marshaler{OrigName: true}).Marshal(&body, req); err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, g.endpoint+path, &body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := g.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		var gwErr struct {
+			Code    codes.Code `json:"code"`
+			Message string     `json:"message"`
+		}
+		if err := json.Unmarshal(data, &gwErr); err != nil || gwErr.Message == "" {
+			return fmt.Errorf("etcdstore: gateway returned %s", httpResp.Status)
+		}
+		return status.Error(gwErr.Code, gwErr.Message)
+	}
+
+	return (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(data), resp)
+}
+
+func (g *gateway) Range(ctx context.Context, in *pb.RangeRequest, _ ...grpc.CallOption) (*pb.RangeResponse, error) {
+	resp := &pb.RangeResponse{}
+	return resp, g.call(ctx, "/v3/kv/range", in, resp)
+}
+
+func (g *gateway) Put(ctx context.Context, in *pb.PutRequest, _ ...grpc.CallOption) (*pb.PutResponse, error) {
+	resp := &pb.PutResponse{}
+	return resp, g.call(ctx, "/v3/kv/put", in, resp)
+}
+
+func (g *gateway) DeleteRange(ctx context.Context, in *pb.DeleteRangeRequest, _ ...grpc.CallOption) (*pb.DeleteRangeResponse, error) {
+	resp := &pb.DeleteRangeResponse{}
+	return resp, g.call(ctx, "/v3/kv/deleterange", in, resp)
+}
+
+func (g *gateway) Txn(ctx context.Context, in *pb.TxnRequest, _ ...grpc.CallOption) (*pb.TxnResponse, error) {
+	resp := &pb.TxnResponse{}
+	return resp, g.call(ctx, "/v3/kv/txn", in, resp)
+}
+
+func (g *gateway) Compact(ctx context.Context, in *pb.CompactionRequest, _ ...grpc.CallOption) (*pb.CompactionResponse, error) {
+	resp := &pb.CompactionResponse{}
+	return resp, g.call(ctx, "/v3/kv/compaction", in, resp)
+}
+
+func (g *gateway) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	resp := &pb.LeaseGrantResponse{}
+	if err := g.call(ctx, "/v3/lease/grant", &pb.LeaseGrantRequest{TTL: ttl}, resp); err != nil {
+		return nil, err
+	}
+	return &clientv3.LeaseGrantResponse{
+		ResponseHeader: resp.Header,
+		ID:             clientv3.LeaseID(resp.ID),
+		TTL:            resp.TTL,
+		Error:          resp.Error,
+	}, nil
+}
+
+func (g *gateway) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	resp := &pb.LeaseRevokeResponse{}
+	if err := g.call(ctx, "/v3/lease/revoke", &pb.LeaseRevokeRequest{ID: int64(id)}, resp); err != nil {
+		return nil, err
+	}
+	return (*clientv3.LeaseRevokeResponse)(resp), nil
+}
+
+// TimeToLive always asks for the attached keys, as the gateway can't tell
+// whether clientv3.WithAttachedKeys was passed.
+func (g *gateway) TimeToLive(ctx context.Context, id clientv3.LeaseID, _ ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	resp := &pb.LeaseTimeToLiveResponse{}
+	if err := g.call(ctx, "/v3/lease/timetolive", &pb.LeaseTimeToLiveRequest{ID: int64(id), Keys: true}, resp); err != nil {
+		return nil, err
+	}
+	return &clientv3.LeaseTimeToLiveResponse{
+		ResponseHeader: resp.Header,
+		ID:             clientv3.LeaseID(resp.ID),
+		TTL:            resp.TTL,
+		GrantedTTL:     resp.GrantedTTL,
+		Keys:           resp.Keys,
+	}, nil
+}
+
+func (g *gateway) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	resp := &pb.LeaseLeasesResponse{}
+	if err := g.call(ctx, "/v3/lease/leases", &pb.LeaseLeasesRequest{}, resp); err != nil {
+		return nil, err
+	}
+	leases := make([]clientv3.LeaseStatus, len(resp.Leases))
+	for i := range resp.Leases {
+		leases[i].ID = clientv3.LeaseID(resp.Leases[i].ID)
+	}
+	return &clientv3.LeaseLeasesResponse{ResponseHeader: resp.Header, Leases: leases}, nil
+}
+
+func (g *gateway) KeepAlive(context.Context, clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	return nil, ErrGatewayUnsupported
+}
+
+func (g *gateway) KeepAliveOnce(context.Context, clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	return nil, ErrGatewayUnsupported
+}
+
+func (g *gateway) Close() error {
+	return nil
+}
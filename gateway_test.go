@@ -0,0 +1,55 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdGatewayStore(t *testing.T) {
+	gw, err := NewEtcdGatewayStore(_defaultEtcd, nil, context.Background(), "/sessions", []byte("secret"))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	// write through the gateway, read through gRPC
+	session := newSavedSession(t, gw, map[interface{}]interface{}{"foo": "bar"})
+	loaded, err := loadByID(store, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	resp, err := store.Client.Get(context.Background(), store.key(session.ID))
+	assert.Nil(t, err)
+	assert.NotZero(t, resp.Kvs[0].Lease, "session is leased")
+
+	// and the other way around, through a transaction
+	other := newSavedSession(t, store, map[interface{}]interface{}{"foo": "baz"})
+	err = gw.MoveSession(context.Background(), "_session", other.ID, "gw-"+other.ID)
+	assert.Nil(t, err)
+	loaded, err = loadByID(gw, "gw-"+other.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", loaded.Values["foo"])
+
+	// delete
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	loaded.Options.MaxAge = -1
+	assert.Nil(t, gw.Save(req, httptest.NewRecorder(), loaded))
+	_, err = loadByID(gw, loaded.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// gateway errors map to the usual etcd errors
+	_, err = gw.kv.Put(context.Background(), "/sessions/gw-orphan", "", clientv3.WithLease(1))
+	assert.Equal(t, rpctypes.ErrLeaseNotFound, err)
+
+	_, err = gw.lease.KeepAliveOnce(context.Background(), 1)
+	assert.True(t, errors.Is(err, ErrGatewayUnsupported))
+
+	_, err = NewEtcdGatewayStore("127.0.0.1:2379", nil, context.Background(), "")
+	assert.NotNil(t, err)
+}
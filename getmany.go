@@ -0,0 +1,120 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// getManyBatch is how many keys GetMany reads per transaction, etcd's
+// default --max-txn-ops.
+const getManyBatch = 128
+
+// GetResult is the outcome of loading one session in GetMany.
+type GetResult struct {
+	ID string
+
+	// Session is the loaded session, nil if Err is set.
+	Session *sessions.Session
+
+	// Err is why the session couldn't be loaded, a *SessionError: e.g.
+	// wrapping ErrNotFound for a missing or expired session, a decode
+	// error for a corrupt value, or ErrIdleTimeout.
+	Err error
+}
+
+// GetMany loads the sessions called name stored under ids, reading their
+// keys in as few transactions as possible, e.g. for an admin view of many
+// sessions. Results come in the order of ids, one each.
+//
+// A session that can't be loaded doesn't fail the others: its result holds
+// the error instead, whether it is missing, its ID is invalid or its value
+// can't be decoded. The error GetMany returns itself is for failures to
+// read etcd at all, and then no result is returned. Loading a session goes
+// as for New otherwise, with IdleTimeout, DeleteCorrupt and AfterLoad,
+// except that the cache isn't used. Bucketed sessions are read from their
+// buckets one by one, and failing to read one is reported in its result.
+func (s *EtcdStore) GetMany(ctx context.Context, name string, ids []string) ([]GetResult, error) {
+	if err := checkGetOptions(s.GetOptions); err != nil {
+		return nil, err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	results := make([]GetResult, len(ids))
+	var pending []int
+	for i, id := range ids {
+		results[i].ID = id
+		if err := s.checkPlainID(id); err != nil {
+			results[i].Err = s.sessionError("load", name, err)
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	if s.Buckets > 0 {
+		for _, i := range pending {
+			s.loadResult(name, &results[i], func(session *sessions.Session) error {
+				entry, err := s.loadBucketed(ctx, session.ID)
+				if err != nil {
+					return err
+				}
+				return s.loaded(ctx, session, nil, entry.Value, entry.Expires, nil)
+			})
+		}
+		return results, nil
+	}
+
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > getManyBatch {
+			batch = batch[:getManyBatch]
+		}
+		pending = pending[len(batch):]
+
+		ops := make([]clientv3.Op, len(batch))
+		for j, i := range batch {
+			ops[j] = clientv3.OpGet(s.key(ids[i]), s.readOpts(ReadLoad, s.GetOptions...)...)
+		}
+		var resp *clientv3.TxnResponse
+		err := s.retry(ctx, func() (err error) {
+			resp, err = s.commit(s.kv.Txn(ctx).Then(ops...))
+			return err
+		})
+		if err != nil {
+			return nil, s.sessionError("load", name, err)
+		}
+
+		for j, i := range batch {
+			r := resp.Responses[j].GetResponseRange()
+			s.loadResult(name, &results[i], func(session *sessions.Session) error {
+				if len(r.Kvs) == 0 {
+					return fmt.Errorf("key: %s is %w", s.key(session.ID), ErrNotFound)
+				}
+				return s.loaded(ctx, session, r.Kvs[0], r.Kvs[0].Value, 0, nil)
+			})
+		}
+	}
+	return results, nil
+}
+
+// loadResult fills result with the session load returns, or its error.
+func (s *EtcdStore) loadResult(name string, result *GetResult, load func(session *sessions.Session) error) {
+	session := sessions.NewSession(s, name)
+	session.ID = result.ID
+	s.mu.RLock()
+	options := *s.Options
+	s.mu.RUnlock()
+	session.Options = &options
+
+	if err := load(session); err != nil {
+		result.Err = s.sessionError("load", name, err)
+		return
+	}
+	result.Session = session
+}
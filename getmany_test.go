@@ -0,0 +1,55 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_GetMany(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	alice := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	corrupt := newSavedSession(t, s, map[interface{}]interface{}{"user": "mallory"})
+	bob := newSavedSession(t, s, map[interface{}]interface{}{"user": "bob"})
+	_, err := s.Client.Put(ctx, s.key(corrupt.ID), "garbage")
+	assert.Nil(t, err)
+
+	results, err := s.GetMany(ctx, "_session", []string{alice.ID, corrupt.ID, "missing", bob.ID, "_reserved"})
+	assert.Nil(t, err)
+	if !assert.Len(t, results, 5) {
+		return
+	}
+
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, alice.ID, results[0].Session.ID)
+	assert.Equal(t, "alice", results[0].Session.Values["user"])
+	assert.False(t, results[0].Session.IsNew)
+
+	assert.Nil(t, results[1].Session)
+	var sessionErr *SessionError
+	assert.True(t, errors.As(results[1].Err, &sessionErr), "corrupt")
+
+	assert.Nil(t, results[2].Session)
+	assert.True(t, errors.Is(results[2].Err, ErrNotFound))
+
+	assert.Nil(t, results[3].Err)
+	assert.Equal(t, "bob", results[3].Session.Values["user"])
+
+	assert.NotNil(t, results[4].Err, "invalid ID")
+	assert.Equal(t, "_reserved", results[4].ID)
+}
+
+func TestEtcdStore_GetManyTransport(t *testing.T) {
+	s := newTestStore(t)
+	session := newSavedSession(t, s, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := s.GetMany(ctx, "_session", []string{session.ID})
+	assert.NotNil(t, err)
+	assert.Nil(t, results)
+}
@@ -3,8 +3,11 @@ module github.com/api7/etcdstore
 go 1.16
 
 require (
+	github.com/gogo/protobuf v1.3.2
 	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/sessions v1.2.1
 	github.com/stretchr/testify v1.7.0
+	go.etcd.io/etcd/api/v3 v3.5.4
 	go.etcd.io/etcd/client/v3 v3.5.4
+	google.golang.org/grpc v1.38.0
 )
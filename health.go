@@ -0,0 +1,62 @@
+package etcdstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EndpointHealth is the outcome of probing one etcd endpoint.
+type EndpointHealth struct {
+	Endpoint string
+	Healthy  bool
+	Latency  time.Duration
+
+	// Err is why the probe failed. Errors lists the alarms the member
+	// reported, e.g. NOSPACE when its quota is exceeded.
+	Err    error
+	Errors []string
+}
+
+// EndpointHealth probes each endpoint of the client with a Status request
+// and returns the results healthiest first: responsive members without
+// alarms, by latency, then the others.
+//
+// etcd's client balancer already moves away from endpoints it can't reach;
+// this is for spotting degraded ones it keeps using, e.g. to pass the
+// healthy endpoints to Client.SetEndpoints. Probes bypass the circuit
+// breaker, so a single bad endpoint doesn't open it. It isn't available on
+// gateway stores.
+func (s *EtcdStore) EndpointHealth(ctx context.Context) ([]EndpointHealth, error) {
+	if s.Client == nil {
+		return nil, ErrGatewayUnsupported
+	}
+
+	endpoints := s.Client.Endpoints()
+	health := make([]EndpointHealth, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(h *EndpointHealth, endpoint string) {
+			defer wg.Done()
+
+			start := time.Now()
+			resp, err := s.Client.Status(ctx, endpoint)
+			*h = EndpointHealth{Endpoint: endpoint, Latency: time.Since(start), Err: err}
+			if err == nil {
+				h.Errors = resp.Errors
+				h.Healthy = len(resp.Errors) == 0
+			}
+		}(&health[i], endpoint)
+	}
+	wg.Wait()
+
+	sort.SliceStable(health, func(i, j int) bool {
+		if health[i].Healthy != health[j].Healthy {
+			return health[i].Healthy
+		}
+		return health[i].Healthy && health[i].Latency < health[j].Latency
+	})
+	return health, nil
+}
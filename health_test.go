@@ -0,0 +1,31 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_EndpointHealth(t *testing.T) {
+	s, err := NewEtcdStore(clientv3.Config{Endpoints: []string{"http://127.0.0.1:1", _defaultEtcd}}, context.Background(), "/sessions", []byte("secret"))
+	assert.Nil(t, err)
+	defer s.Close()
+	s.BreakerThreshold = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	health, err := s.EndpointHealth(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, health, 2)
+
+	assert.Equal(t, _defaultEtcd, health[0].Endpoint, "healthy first")
+	assert.True(t, health[0].Healthy)
+	assert.Nil(t, health[0].Err)
+	assert.Equal(t, "http://127.0.0.1:1", health[1].Endpoint)
+	assert.False(t, health[1].Healthy)
+	assert.NotNil(t, health[1].Err)
+	assert.Equal(t, CircuitClosed, s.CircuitState(), "probes bypass the breaker")
+}
@@ -0,0 +1,132 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// ErrIdleTimeout is returned when a session has not been accessed within
+// IdleTimeout. The session is deleted from etcd.
+var ErrIdleTimeout = errors.New("etcdstore: session idle timeout exceeded")
+
+// checkIdle deletes session, loaded from value, read as kv unless
+// bucketed, and returns ErrIdleTimeout if it has been idle for longer than
+// IdleTimeout plus SkewTolerance, and touches it otherwise. Under MaxTTL
+// alone, it just touches it. A session served stale is neither touched nor
+// deleted, as etcd can't be reached, but is still refused once idle.
+func (s *EtcdStore) checkIdle(ctx context.Context, session *sessions.Session, kv *mvccpb.KeyValue, value []byte) error {
+	stale := IsStale(session)
+	if s.IdleTimeout <= 0 {
+		if s.MaxTTL > 0 && s.Buckets == 0 && !stale {
+			return s.sessionError("touch", session.Name(), s.touch(ctx, session, kv, value))
+		}
+		return nil
+	}
+
+	if last, ok := session.Values[lastAccessKey].(int64); ok {
+		if s.clock().Sub(time.Unix(0, last)) > s.IdleTimeout+s.SkewTolerance {
+			if stale {
+				return fmt.Errorf("%w: key %s", ErrIdleTimeout, s.key(session.ID))
+			}
+			if err := s.delete(ctx, session); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			s.publish(EventExpired, session.Name(), session.ID)
+			return fmt.Errorf("%w: key %s", ErrIdleTimeout, s.key(session.ID))
+		}
+	}
+	if stale {
+		return nil
+	}
+
+	return s.sessionError("touch", session.Name(), s.touch(ctx, session, kv, value))
+}
+
+// Touch records the current time as the session's last access and writes
+// it to etcd, keeping the session's lease, or its expiry in its bucket
+// under Buckets, or under MaxTTL moving it to a lease of its new lifetime.
+// load calls it on every read when IdleTimeout or MaxTTL is set, which
+// costs one extra Put per load, and a Grant under MaxTTL.
+func (s *EtcdStore) Touch(ctx context.Context, session *sessions.Session) error {
+	return s.sessionError("touch", session.Name(), s.touch(ctx, session, nil, nil))
+}
+
+// touch is Touch, for session loaded from value, read as kv unless
+// bucketed, if value is set: it then leaves the session alone once written
+// since, as the write recorded a later access.
+func (s *EtcdStore) touch(ctx context.Context, session *sessions.Session, kv *mvccpb.KeyValue, value []byte) error {
+	if s.MaxTTL > 0 && s.Buckets == 0 {
+		return s.touchAdaptive(ctx, session, kv)
+	}
+	session.Values[lastAccessKey] = s.clock().UnixNano()
+
+	encoded, err := s.encode(session.Name(), session.ID, session.Values)
+	if err != nil {
+		return err
+	}
+
+	if s.Buckets > 0 {
+		return s.touchBucketed(ctx, session.ID, encoded, value)
+	}
+
+	key := s.key(session.ID)
+	prev, cached := s.cached(key)
+	s.uncache(key)
+	rev, err := s.writeTouch(ctx, key, encoded, kv)
+	if err != nil || rev == 0 {
+		return err
+	}
+
+	if cached {
+		// keep serving loads from the cache under IdleTimeout
+		s.cacheValue(&mvccpb.KeyValue{
+			Key:            prev.Key,
+			Value:          []byte(encoded),
+			CreateRevision: prev.CreateRevision,
+			ModRevision:    rev,
+			Lease:          prev.Lease,
+		})
+	}
+	return nil
+}
+
+// writeTouch stores encoded under key, keeping its lease, and returns the
+// revision written, or 0 if key was read as kv and written since.
+func (s *EtcdStore) writeTouch(ctx context.Context, key, encoded string, kv *mvccpb.KeyValue) (int64, error) {
+	if kv == nil {
+		resp, err := s.put(ctx, key, encoded, clientv3.WithIgnoreLease())
+		if err != nil {
+			return 0, err
+		}
+		return resp.Header.Revision, nil
+	}
+
+	txn, err := s.commit(s.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+		Then(clientv3.OpPut(key, encoded, clientv3.WithIgnoreLease())).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())))
+	if err != nil {
+		return 0, err
+	}
+	if !txn.Succeeded {
+		if txn.Responses[0].GetResponseRange().Count == 0 {
+			return 0, fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+		return 0, nil
+	}
+	return txn.Header.Revision, nil
+}
+
+// clock returns the current time from the store's clock.
+func (s *EtcdStore) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
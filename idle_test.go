@@ -0,0 +1,139 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_IdleTimeout(t *testing.T) {
+	now := time.Now()
+	store.IdleTimeout = 10 * time.Minute
+	store.now = func() time.Time { return now }
+	defer func() {
+		store.IdleTimeout = 0
+		store.now = time.Now
+	}()
+
+	session := newSavedSession(t, store, map[interface{}]interface{}{"foo": "bar"})
+
+	// an active session is touched on every load
+	now = now.Add(9 * time.Minute)
+	loaded, err := loadByID(store, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, now.UnixNano(), loaded.Values[lastAccessKey])
+
+	now = now.Add(9 * time.Minute)
+	_, err = loadByID(store, session.ID)
+	assert.Nil(t, err, "the previous load reset the idle timer")
+
+	// an idle session is rejected and removed
+	now = now.Add(11 * time.Minute)
+	_, err = loadByID(store, session.ID)
+	assert.True(t, errors.Is(err, ErrIdleTimeout))
+
+	_, err = loadByID(store, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_IdleTimeoutBuckets(t *testing.T) {
+	s := newTestStore(t)
+	s.Buckets = 1
+	s.IdleTimeout = 10 * time.Minute
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	now = now.Add(9 * time.Minute)
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, now.UnixNano(), loaded.Values[lastAccessKey])
+
+	now = now.Add(9 * time.Minute)
+	_, err = loadByID(s, session.ID)
+	assert.Nil(t, err, "the previous load reset the idle timer")
+
+	now = now.Add(11 * time.Minute)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrIdleTimeout))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_SkewTolerance(t *testing.T) {
+	s := newTestStore(t)
+	s.IdleTimeout = 10 * time.Minute
+	s.SkewTolerance = time.Minute
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	// stamped by a node running 30s behind, read by one on time
+	now = now.Add(-30 * time.Second)
+	behind := newSavedSession(t, s, nil)
+	now = now.Add(30*time.Second + 10*time.Minute)
+	_, err := loadByID(s, behind.ID)
+	assert.Nil(t, err, "within the tolerance")
+
+	// stamped by a node running 30s ahead, read by one on time
+	now = now.Add(30 * time.Second)
+	ahead := newSavedSession(t, s, nil)
+	now = now.Add(-30*time.Second + 10*time.Minute)
+	_, err = loadByID(s, ahead.ID)
+	assert.Nil(t, err)
+
+	// beyond the tolerance
+	now = now.Add(11*time.Minute + time.Second)
+	_, err = loadByID(s, ahead.ID)
+	assert.True(t, errors.Is(err, ErrIdleTimeout))
+
+	// bucketed sessions too
+	s.Buckets = 1
+	now = now.Add(30 * time.Second)
+	idle := newSavedSession(t, s, nil)
+	now = now.Add(-30*time.Second + 10*time.Minute)
+	_, err = loadByID(s, idle.ID)
+	assert.Nil(t, err, "within the tolerance")
+	now = now.Add(11*time.Minute + time.Second)
+	_, err = loadByID(s, idle.ID)
+	assert.True(t, errors.Is(err, ErrIdleTimeout))
+
+	// and so is their expiry
+	s.IdleTimeout = 0
+	session := newSavedSession(t, s, nil)
+	maxAge := time.Duration(session.Options.MaxAge) * time.Second
+	now = now.Add(maxAge + 30*time.Second)
+	_, err = loadByID(s, session.ID)
+	assert.Nil(t, err, "within the tolerance")
+	now = now.Add(31 * time.Second)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_TouchAfterSave(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.IdleTimeout = time.Hour
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	read := resp.Kvs[0]
+
+	// saved by another request between the read and its touch
+	session.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	loaded := sessions.NewSession(s, "_session")
+	loaded.ID = session.ID
+	assert.Nil(t, s.decode("_session", session.ID, read.Value, &loaded.Values))
+	assert.Nil(t, s.checkIdle(ctx, loaded, read, read.Value))
+
+	reloaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", reloaded.Values["foo"], "the save isn't overwritten")
+}
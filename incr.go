@@ -0,0 +1,147 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// IncrValue atomically adds delta to the integer stored under key in the
+// session's values and returns the result. A missing value counts as 0.
+//
+// The stored session is read, changed and written back in a transaction,
+// retried until no concurrent write got in between, so concurrent calls
+// never lose an update, and up to Retries times on errors worth retrying,
+// like Load. Under FieldKeys, only the field key of the value
+// is written. session.Values[key] is set to the new value; other values in
+// session are left as they are.
+func (s *EtcdStore) IncrValue(ctx context.Context, session *sessions.Session, key string, delta int64) (int64, error) {
+	n, err := s.incrValue(ctx, session, key, delta)
+	return n, s.sessionError("incr", session.Name(), err)
+}
+
+func (s *EtcdStore) incrValue(ctx context.Context, session *sessions.Session, key string, delta int64) (int64, error) {
+	done, err := s.inflight.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	var n int64
+	err = s.retry(ctx, func() (err error) {
+		if s.fieldKeys() && isField(key) {
+			n, err = s.incrField(ctx, session, key, delta)
+		} else {
+			n, err = s.incrKey(ctx, session, key, delta)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	session.Values[key] = n
+	return n, nil
+}
+
+// incrKey is incrValue for a value stored in the session's key.
+func (s *EtcdStore) incrKey(ctx context.Context, session *sessions.Session, key string, delta int64) (int64, error) {
+	etcdKey := s.key(session.ID)
+	s.uncache(etcdKey)
+	for {
+		resp, err := s.get(ctx, etcdKey)
+		if err != nil {
+			return 0, err
+		}
+		if resp.Count == 0 {
+			return 0, fmt.Errorf("key: %s is %w", etcdKey, ErrNotFound)
+		}
+
+		kv := resp.Kvs[0]
+		values := make(map[interface{}]interface{})
+		if err := s.decode(session.Name(), session.ID, kv.Value, &values); err != nil {
+			return 0, err
+		}
+
+		n, err := addInt(values[key], key, delta)
+		if err != nil {
+			return 0, err
+		}
+		values[key] = n
+
+		encoded, err := s.encode(session.Name(), session.ID, values)
+		if err != nil {
+			return 0, err
+		}
+
+		txn, err := s.commit(s.kv.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(etcdKey), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(etcdKey, encoded, clientv3.WithIgnoreLease())))
+		if err != nil {
+			return 0, err
+		}
+		if txn.Succeeded {
+			return n, nil
+		}
+	}
+}
+
+// incrField is incrValue for a value stored in a field key. The session's
+// key is written again as it is, so that its revision still moves with
+// every change to the session, as CompareAndSwap expects.
+func (s *EtcdStore) incrField(ctx context.Context, session *sessions.Session, key string, delta int64) (int64, error) {
+	etcdKey, fieldKey := s.key(session.ID), s.fieldPrefix(session.ID)+key
+	s.uncache(etcdKey)
+	for {
+		resp, err := s.commit(s.kv.Txn(ctx).Then(
+			clientv3.OpGet(etcdKey, clientv3.WithKeysOnly()),
+			clientv3.OpGet(fieldKey)))
+		if err != nil {
+			return 0, err
+		}
+		stored, field := resp.Responses[0].GetResponseRange(), resp.Responses[1].GetResponseRange()
+		if stored.Count == 0 {
+			return 0, fmt.Errorf("key: %s is %w", etcdKey, ErrNotFound)
+		}
+
+		values := make(map[interface{}]interface{})
+		if _, err := s.readFields(session.Name(), session.ID, field.Kvs, values); err != nil {
+			return 0, err
+		}
+		n, err := addInt(values[key], key, delta)
+		if err != nil {
+			return 0, err
+		}
+		encoded, err := s.sealField(session.Name(), session.ID, key, n)
+		if err != nil {
+			return 0, err
+		}
+
+		kv := stored.Kvs[0]
+		txn, err := s.commit(s.kv.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(etcdKey), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(etcdKey, "", clientv3.WithIgnoreValue(), clientv3.WithIgnoreLease()),
+				clientv3.OpPut(fieldKey, encoded, clientv3.WithLease(clientv3.LeaseID(kv.Lease)))))
+		if err != nil {
+			return 0, err
+		}
+		if txn.Succeeded {
+			return n, nil
+		}
+	}
+}
+
+// addInt returns delta added to v, the integer stored under key, 0 if nil.
+func addInt(v interface{}, key string, delta int64) (int64, error) {
+	switch v := v.(type) {
+	case nil:
+		return delta, nil
+	case int64:
+		return v + delta, nil
+	case int:
+		return int64(v) + delta, nil
+	default:
+		return 0, fmt.Errorf("etcdstore: value %q is a %T, not an integer", key, v)
+	}
+}
@@ -0,0 +1,71 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+func TestEtcdStore_IncrValue(t *testing.T) {
+	ctx := context.Background()
+	session := newSavedSession(t, store, map[interface{}]interface{}{"name": "alice"})
+
+	n, err := store.IncrValue(ctx, session, "failed_logins", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, int64(2), session.Values["failed_logins"])
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each goroutine holds its own copy, as concurrent requests would
+			mine := sessions.NewSession(store, "_session")
+			mine.ID = session.ID
+			for j := 0; j < 5; j++ {
+				_, err := store.IncrValue(ctx, mine, "failed_logins", 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	loaded, err := loadByID(store, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(52), loaded.Values["failed_logins"])
+	assert.Equal(t, "alice", loaded.Values["name"])
+
+	_, err = store.IncrValue(ctx, session, "name", 1)
+	assert.NotNil(t, err)
+
+	missing := sessions.NewSession(store, "_session")
+	missing.ID = "missing"
+	_, err = store.IncrValue(ctx, missing, "failed_logins", 1)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEtcdStore_IncrValueRetries(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, nil)
+
+	s.Retries = 2
+	s.Backoff = FixedBackoff(0)
+	kv := &flakyKV{KV: s.kv, err: rpctypes.ErrNoLeader, failures: 2}
+	s.kv = kv
+	n, err := s.IncrValue(ctx, session, "hits", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, 3, kv.calls)
+
+	assert.Nil(t, s.CloseGraceful(time.Second))
+	_, err = s.IncrValue(ctx, session, "hits", 1)
+	assert.True(t, errors.Is(err, ErrClosed), "refused once closed")
+}
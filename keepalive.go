@@ -0,0 +1,224 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+var (
+	// ErrNoLease is returned by StartKeepAlive for a session without a
+	// lease: a new or bucketed one.
+	ErrNoLease = errors.New("etcdstore: session has no lease")
+
+	// ErrTooManyKeepAlives is returned by StartKeepAlive when it would run
+	// more than MaxKeepAlives keep-alives.
+	ErrTooManyKeepAlives = errors.New("etcdstore: too many keep-alives")
+)
+
+// keepAlives runs the keep-alives started by StartKeepAlive, one per lease
+// however many sessions share it.
+type keepAlives struct {
+	mu       sync.Mutex
+	sessions map[keepAliveKey]clientv3.LeaseID
+	leases   map[clientv3.LeaseID]*leaseKeepAlive
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// keepAliveKey identifies a session kept alive, across the copies loaded
+// for each of its connections.
+type keepAliveKey struct {
+	name, id string
+}
+
+type leaseKeepAlive struct {
+	refs    int
+	cancel  context.CancelFunc
+	started time.Time
+}
+
+// keepAliveCheckInterval is how often a keep-alive checks whether it ran
+// for MaxKeepAliveDuration.
+const keepAliveCheckInterval = time.Second
+
+// StartKeepAlive keeps the lease of session alive until StopKeepAlive or
+// Close, so that a session held by a long-lived connection, e.g. a
+// WebSocket, outlives its MaxAge without being saved over and over.
+// Starting it again for the same session, or a copy of it loaded by another
+// connection, is a no-op.
+//
+// Sessions on the same lease, as LeaseID reports it, share its keep-alive,
+// and clientv3 sends those of all leases over a single stream per client:
+// thousands of sessions cost one stream and a goroutine per distinct lease,
+// instead of a stream each. A Save may move the session to another lease;
+// start the keep-alive again after it.
+//
+// Under MaxKeepAliveDuration, the keep-alive gives up after running for
+// that long, checking every second, and OnKeepAliveStopped is called for
+// each session on the lease; sessions joining a running keep-alive share
+// its deadline. Starting it again afterwards starts over.
+func (s *EtcdStore) StartKeepAlive(session *sessions.Session) error {
+	lease, ok := LeaseID(session)
+	if !ok {
+		return s.sessionError("keepalive", session.Name(), fmt.Errorf("%w: key %s", ErrNoLease, s.key(session.ID)))
+	}
+	return s.sessionError("keepalive", session.Name(), s.keepAlives.start(s, keepAliveKey{session.Name(), session.ID}, lease))
+}
+
+// StopKeepAlive stops what StartKeepAlive started for session. The lease is
+// no longer kept alive once no session using it is, and then expires after
+// its TTL as usual.
+func (s *EtcdStore) StopKeepAlive(session *sessions.Session) {
+	s.keepAlives.stop(keepAliveKey{session.Name(), session.ID})
+}
+
+// ActiveKeepAlives returns how many keep-alives StartKeepAlive is running,
+// one per distinct lease, each with its goroutine.
+func (s *EtcdStore) ActiveKeepAlives() int {
+	s.keepAlives.mu.Lock()
+	defer s.keepAlives.mu.Unlock()
+
+	return len(s.keepAlives.leases)
+}
+
+func (k *keepAlives) start(s *EtcdStore, key keepAliveKey, lease clientv3.LeaseID) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return ErrClosed
+	}
+	if k.sessions == nil {
+		k.sessions = make(map[keepAliveKey]clientv3.LeaseID)
+		k.leases = make(map[clientv3.LeaseID]*leaseKeepAlive)
+	}
+	if prev, ok := k.sessions[key]; ok {
+		if prev == lease {
+			return nil
+		}
+		delete(k.sessions, key)
+		k.release(prev)
+	}
+
+	if running, ok := k.leases[lease]; ok {
+		running.refs++
+		k.sessions[key] = lease
+		return nil
+	}
+
+	if s.MaxKeepAlives > 0 && len(k.leases) >= s.MaxKeepAlives {
+		return fmt.Errorf("%w: %d running", ErrTooManyKeepAlives, len(k.leases))
+	}
+	if err := s.background().Err(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(s.background())
+	ch, err := s.lease.KeepAlive(ctx, lease)
+	if err != nil {
+		cancel()
+		return err
+	}
+	running := &leaseKeepAlive{refs: 1, cancel: cancel, started: s.clock()}
+	k.leases[lease] = running
+	k.sessions[key] = lease
+
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		capped := k.run(s, ch, running)
+
+		// stopped, capped, or the lease is gone
+		var stopped []keepAliveKey
+		k.mu.Lock()
+		if k.leases[lease] == running {
+			for key, other := range k.sessions {
+				if other == lease {
+					stopped = append(stopped, key)
+				}
+			}
+			k.forget(lease)
+		}
+		k.mu.Unlock()
+
+		if capped && s.OnKeepAliveStopped != nil {
+			for _, key := range stopped {
+				s.OnKeepAliveStopped(key.name, key.id)
+			}
+		}
+	}()
+	return nil
+}
+
+// run consumes the keep-alive responses of running until ch is closed, or
+// reports true once it ran for MaxKeepAliveDuration.
+func (k *keepAlives) run(s *EtcdStore, ch <-chan *clientv3.LeaseKeepAliveResponse, running *leaseKeepAlive) bool {
+	var check <-chan time.Time
+	if s.MaxKeepAliveDuration > 0 {
+		ticker := time.NewTicker(keepAliveCheckInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return false
+			}
+		case <-check:
+		}
+		if s.MaxKeepAliveDuration > 0 && s.clock().Sub(running.started) >= s.MaxKeepAliveDuration {
+			return true
+		}
+	}
+}
+
+func (k *keepAlives) stop(key keepAliveKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if lease, ok := k.sessions[key]; ok {
+		delete(k.sessions, key)
+		k.release(lease)
+	}
+}
+
+// release drops a session's reference to the keep-alive of lease, stopping
+// it after the last one. k.mu must be held.
+func (k *keepAlives) release(lease clientv3.LeaseID) {
+	running := k.leases[lease]
+	if running.refs--; running.refs == 0 {
+		running.cancel()
+		delete(k.leases, lease)
+	}
+}
+
+// forget drops the keep-alive of lease along with its sessions. k.mu must
+// be held.
+func (k *keepAlives) forget(lease clientv3.LeaseID) {
+	k.leases[lease].cancel()
+	delete(k.leases, lease)
+	for key, other := range k.sessions {
+		if other == lease {
+			delete(k.sessions, key)
+		}
+	}
+}
+
+// close stops every keep-alive and waits for them to wind down.
+func (k *keepAlives) close() {
+	k.mu.Lock()
+	k.closed = true
+	for lease := range k.leases {
+		k.forget(lease)
+	}
+	k.mu.Unlock()
+
+	k.wg.Wait()
+}
@@ -0,0 +1,185 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_KeepAlive(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	// many sessions on a few leases, each loaded by several connections
+	var leases []clientv3.LeaseID
+	for i := 0; i < 3; i++ {
+		grant, err := store.Client.Grant(ctx, 60)
+		assert.Nil(t, err)
+		leases = append(leases, grant.ID)
+	}
+	var ids []string
+	for i := 0; i < 60; i++ {
+		session := newSavedSession(t, s, nil)
+		_, err := store.Client.Put(ctx, s.key(session.ID), "", clientv3.WithIgnoreValue(), clientv3.WithLease(leases[i%3]))
+		assert.Nil(t, err)
+		ids = append(ids, session.ID)
+	}
+
+	// the first keep-alive starts the client's own loops
+	first, err := loadByID(s, ids[0])
+	assert.Nil(t, err)
+	assert.Nil(t, s.StartKeepAlive(first))
+	goroutines := runtime.NumGoroutine()
+
+	conns := []*sessions.Session{first}
+	for _, id := range ids {
+		for i := 0; i < 4; i++ {
+			conn, err := loadByID(s, id)
+			assert.Nil(t, err)
+			assert.Nil(t, s.StartKeepAlive(conn))
+			conns = append(conns, conn)
+		}
+	}
+	s.keepAlives.mu.Lock()
+	assert.Len(t, s.keepAlives.leases, 3, "one keep-alive per lease")
+	assert.Len(t, s.keepAlives.sessions, 60)
+	s.keepAlives.mu.Unlock()
+	// two goroutines per lease, ours and clientv3's, rather than one per session
+	assert.LessOrEqual(t, runtime.NumGoroutine()-goroutines, 2*2+4, "goroutines bounded by leases")
+
+	// stopping a lease's last session stops its keep-alive
+	for _, conn := range conns {
+		if lease, _ := LeaseID(conn); lease == leases[0] {
+			s.StopKeepAlive(conn)
+		}
+	}
+	s.keepAlives.mu.Lock()
+	assert.Len(t, s.keepAlives.leases, 2)
+	s.keepAlives.mu.Unlock()
+
+	assert.True(t, errors.Is(s.StartKeepAlive(sessions.NewSession(s, "_session")), ErrNoLease))
+
+	// revoked leases drop out, and Close stops the rest
+	_, err = store.Client.Revoke(ctx, leases[1])
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		s.keepAlives.mu.Lock()
+		defer s.keepAlives.mu.Unlock()
+		return len(s.keepAlives.leases) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	s.keepAlives.close()
+	assert.Len(t, s.keepAlives.leases, 0)
+	assert.True(t, errors.Is(s.StartKeepAlive(conns[len(conns)-1]), ErrClosed))
+}
+
+func TestEtcdStore_MaxKeepAlives(t *testing.T) {
+	s := newTestStore(t)
+	s.MaxKeepAlives = 2
+
+	first := newSavedSession(t, s, nil)
+	second := newSavedSession(t, s, nil)
+	third := newSavedSession(t, s, nil)
+	assert.Nil(t, s.StartKeepAlive(first))
+	assert.Nil(t, s.StartKeepAlive(second))
+	assert.Equal(t, 2, s.ActiveKeepAlives())
+
+	err := s.StartKeepAlive(third)
+	assert.True(t, errors.Is(err, ErrTooManyKeepAlives))
+	assert.Equal(t, 2, s.ActiveKeepAlives())
+
+	// a copy of a session kept alive shares its keep-alive
+	copied, err := loadByID(s, first.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, s.StartKeepAlive(copied))
+	assert.Equal(t, 2, s.ActiveKeepAlives())
+
+	s.StopKeepAlive(second)
+	assert.Equal(t, 1, s.ActiveKeepAlives())
+	assert.Nil(t, s.StartKeepAlive(third))
+	assert.Equal(t, 2, s.ActiveKeepAlives())
+
+	s.StopKeepAlive(first)
+	s.StopKeepAlive(third)
+	assert.Equal(t, 0, s.ActiveKeepAlives())
+}
+
+func TestEtcdStore_MaxKeepAliveDuration(t *testing.T) {
+	s := newTestStore(t)
+	var mu sync.Mutex
+	now := time.Now()
+	s.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	s.MaxKeepAliveDuration = time.Hour
+	stopped := make(chan string, 2)
+	s.OnKeepAliveStopped = func(name, id string) { stopped <- id }
+
+	session := newSavedSession(t, s, nil)
+	assert.Nil(t, s.StartKeepAlive(session))
+	time.Sleep(keepAliveCheckInterval + 100*time.Millisecond)
+	assert.Equal(t, 1, s.ActiveKeepAlives(), "before the cap")
+
+	mu.Lock()
+	now = now.Add(time.Hour)
+	mu.Unlock()
+	select {
+	case id := <-stopped:
+		assert.Equal(t, session.ID, id)
+	case <-time.After(3 * keepAliveCheckInterval):
+		t.Fatal("keep-alive not stopped at the cap")
+	}
+	assert.Equal(t, 0, s.ActiveKeepAlives())
+}
+
+// storeGoroutines counts the goroutines running the store's background work.
+func storeGoroutines() int {
+	buf := make([]byte, 1<<20)
+	stacks := string(buf[:runtime.Stack(buf, true)])
+	n := 0
+	for _, stack := range strings.Split(stacks, "\n\n") {
+		for _, fn := range []string{"(*leasePool).run(", "(*keepAlives).start.func1(", "(*EtcdStore).publish.func1.1("} {
+			if strings.Contains(stack, "etcdstore."+fn) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestEtcdStore_BackgroundContext(t *testing.T) {
+	background, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestStore(t)
+	s.BackgroundContext = background
+	s.LeasePoolSize = 2
+	s.Events = NopSink{}
+	before := storeGoroutines()
+
+	session := newSavedSession(t, s, nil)
+	newSavedSession(t, s, nil)
+	assert.Nil(t, s.StartKeepAlive(session))
+	assert.Eventually(t, func() bool { return storeGoroutines()-before == 3 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Eventually(t, func() bool { return storeGoroutines() == before }, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, s.ActiveKeepAlives())
+	assert.ErrorIs(t, s.StartKeepAlive(session), context.Canceled)
+
+	// while the store still serves requests
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	loaded.Options.MaxAge = 60
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+}
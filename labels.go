@@ -0,0 +1,111 @@
+package etcdstore
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// MaxLabels is how many labels a session may carry.
+const MaxLabels = 16
+
+// ErrTooManyLabels is returned by SetLabel for a session carrying MaxLabels
+// labels already.
+var ErrTooManyLabels = errors.New("etcdstore: too many session labels")
+
+func init() {
+	gob.Register(map[string]string(nil))
+	RegisterJSON(map[string]string(nil))
+}
+
+// SetLabel tags session with key=value, e.g. "region"="eu", for
+// ListSessionIDsByLabel and DeleteByLabel to pick it out of the others. An
+// empty value removes the label. Labels are stored with the session values,
+// so they take effect on the next Save.
+func SetLabel(session *sessions.Session, key, value string) error {
+	if key == "" {
+		return errors.New("etcdstore: empty label key")
+	}
+
+	labels := Labels(session)
+	if value == "" {
+		delete(labels, key)
+	} else {
+		if _, ok := labels[key]; !ok && len(labels) >= MaxLabels {
+			return fmt.Errorf("%w: at most %d", ErrTooManyLabels, MaxLabels)
+		}
+		labels[key] = value
+	}
+
+	if len(labels) == 0 {
+		delete(session.Values, labelsKey)
+	} else {
+		session.Values[labelsKey] = labels
+	}
+	return nil
+}
+
+// Labels returns a copy of the labels of session.
+func Labels(session *sessions.Session) map[string]string {
+	stored, _ := session.Values[labelsKey].(map[string]string)
+	labels := make(map[string]string, len(stored))
+	for k, v := range stored {
+		labels[k] = v
+	}
+	return labels
+}
+
+// ListSessionIDsByLabel returns the IDs of the sessions called name labelled
+// key=value by SetLabel.
+//
+// etcd keys carry no labels, so like FilterSessions this pages through
+// every key under the prefix and decodes each value to filter on them
+// client-side. It doesn't see bucketed sessions.
+func (s *EtcdStore) ListSessionIDsByLabel(ctx context.Context, name, key, value string) ([]string, error) {
+	return s.FilterSessions(ctx, name, func(session *sessions.Session) bool {
+		return hasLabel(session, key, value)
+	})
+}
+
+// DeleteByLabel deletes the sessions called name labelled key=value, along
+// with their auxiliary data and user index entries, and returns how many it
+// deleted. It scans the prefix as ListSessionIDsByLabel does, then deletes
+// the matching sessions one by one, stopping at the first error. Sessions
+// gone meanwhile are skipped.
+func (s *EtcdStore) DeleteByLabel(ctx context.Context, name, key, value string) (int, error) {
+	var matched []*sessions.Session
+	err := s.scan(ctx, func(kv *mvccpb.KeyValue) error {
+		session := sessions.NewSession(s, name)
+		session.ID = s.idFromKey(kv.Key)
+		if err := s.decode(name, session.ID, kv.Value, &session.Values); err == nil && hasLabel(session, key, value) {
+			matched = append(matched, session)
+		}
+		return nil
+	}, s.readOpts(ReadScan)...)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, session := range matched {
+		if err := s.delete(ctx, session); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return deleted, err
+		}
+		s.publish(EventDeleted, name, session.ID)
+		deleted++
+	}
+	return deleted, nil
+}
+
+func hasLabel(session *sessions.Session, key, value string) bool {
+	labels, _ := session.Values[labelsKey].(map[string]string)
+	v, ok := labels[key]
+	return ok && v == value
+}
@@ -0,0 +1,73 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_Labels(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	var eu, us []string
+	for i := 0; i < 3; i++ {
+		for _, region := range []string{"eu", "us"} {
+			session := newSavedSession(t, s, nil)
+			assert.Nil(t, SetLabel(session, "region", region))
+			assert.Nil(t, SetLabel(session, "cohort", fmt.Sprint(i)))
+			assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+			if region == "eu" {
+				eu = append(eu, session.ID)
+			} else {
+				us = append(us, session.ID)
+			}
+		}
+	}
+	unlabelled := newSavedSession(t, s, nil)
+
+	loaded, err := loadByID(s, eu[0])
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"region": "eu", "cohort": "0"}, Labels(loaded))
+
+	ids, err := s.ListSessionIDsByLabel(ctx, "_session", "region", "eu")
+	assert.Nil(t, err)
+	sort.Strings(ids)
+	sort.Strings(eu)
+	assert.Equal(t, eu, ids)
+
+	deleted, err := s.DeleteByLabel(ctx, "_session", "region", "us")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, deleted)
+	for _, id := range us {
+		_, err := loadByID(s, id)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+	for _, id := range append(eu, unlabelled.ID) {
+		_, err := loadByID(s, id)
+		assert.Nil(t, err)
+	}
+
+	// removing a label
+	assert.Nil(t, SetLabel(loaded, "region", ""))
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+	ids, err = s.ListSessionIDsByLabel(ctx, "_session", "region", "eu")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 2)
+}
+
+func TestSetLabel_Max(t *testing.T) {
+	session := sessions.NewSession(nil, "_session")
+	for i := 0; i < MaxLabels; i++ {
+		assert.Nil(t, SetLabel(session, fmt.Sprint(i), "x"))
+	}
+	assert.True(t, errors.Is(SetLabel(session, "one more", "x"), ErrTooManyLabels))
+	assert.Nil(t, SetLabel(session, "0", "y"), "replacing")
+	assert.NotNil(t, SetLabel(session, "", "x"))
+}
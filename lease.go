@@ -0,0 +1,97 @@
+package etcdstore
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// LeaseID returns the ID of the lease the session was attached to when it
+// was last loaded or saved, for callers running their own keep-alives, e.g.
+// for sessions of long-lived connections. It reports false for new and
+// bucketed sessions. The lease may be shared by other sessions under
+// LeasePoolSize, and a later Save may move the session to another one.
+func LeaseID(session *sessions.Session) (clientv3.LeaseID, bool) {
+	id, ok := session.Values[leaseKey].(int64)
+	return clientv3.LeaseID(id), ok
+}
+
+// ListByLease returns the IDs of the sessions under the store's prefix
+// attached to lease, e.g. to track down a leak of sessions on a pooled or
+// request-shared lease. Keys of other prefixes on the lease, and the
+// store's own keys such as auxiliary data, are left out.
+func (s *EtcdStore) ListByLease(ctx context.Context, lease clientv3.LeaseID) ([]string, error) {
+	resp, err := s.timeToLive(ctx, lease, clientv3.WithAttachedKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := s.key("")
+	var ids []string
+	for _, key := range resp.Keys {
+		if !strings.HasPrefix(string(key), prefix) {
+			continue
+		}
+		if id := s.idFromKey(key); !strings.HasPrefix(id, reservedPrefix) && !strings.Contains(id, s.keyDelimiter) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// RevokeLease revokes lease, which deletes every key attached to it at
+// once: all the sessions sharing it along with their auxiliary data and
+// user index entries, and any key of another prefix or application put on
+// it too. Revoking a lease that already expired returns
+// rpctypes.ErrLeaseNotFound.
+func (s *EtcdStore) RevokeLease(ctx context.Context, lease clientv3.LeaseID) error {
+	if s.CacheSize > 0 {
+		s.cache.forgetLease(int64(lease))
+	}
+	_, err := s.revoke(ctx, lease)
+	return err
+}
+
+// AuditLeases returns the IDs of the sessions under the store's prefix that
+// will never expire: stored without a lease, or on one etcd no longer
+// knows, e.g. because of a bug that wrote them with a plain put. It only
+// reports them, leaving them to the caller to delete or Save again.
+//
+// Like CountSessions, it pages through every key under the prefix without
+// fetching the values, and asks etcd about each lease once per
+// DefaultPageSize distinct leases at most. Bucketed sessions, which expire
+// on their own timestamps, aren't reported. It stops when ctx is done.
+func (s *EtcdStore) AuditLeases(ctx context.Context) ([]string, error) {
+	var leaseless []string
+	alive := make(map[clientv3.LeaseID]bool)
+	err := s.scan(ctx, func(kv *mvccpb.KeyValue) error {
+		lease := clientv3.LeaseID(kv.Lease)
+		if lease == clientv3.NoLease {
+			leaseless = append(leaseless, s.idFromKey(kv.Key))
+			return nil
+		}
+
+		ok, checked := alive[lease]
+		if !checked {
+			resp, err := s.timeToLive(ctx, lease)
+			if err != nil {
+				return err
+			}
+			ok = resp.TTL > 0
+			if len(alive) >= DefaultPageSize {
+				alive = make(map[clientv3.LeaseID]bool)
+			}
+			alive[lease] = ok
+		}
+		if !ok {
+			leaseless = append(leaseless, s.idFromKey(kv.Key))
+		}
+		return nil
+	}, s.readOpts(ReadScan, clientv3.WithKeysOnly())...)
+	return leaseless, err
+}
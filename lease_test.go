@@ -0,0 +1,112 @@
+package etcdstore
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_ListByLeaseAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.CacheSize = 10
+	first := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	second := newSavedSession(t, s, map[interface{}]interface{}{"foo": "baz"})
+	third := newSavedSession(t, s, nil)
+	assert.Nil(t, s.PutAux(ctx, first, "csrf", []byte("token")))
+
+	// share a lease, along with a key of another prefix
+	grant, err := store.Client.Grant(ctx, 60)
+	assert.Nil(t, err)
+	foreign := s.keyPrefix + "-other/key"
+	defer store.Client.Delete(ctx, foreign)
+	for _, key := range []string{s.key(first.ID), s.key(second.ID), s.auxPrefix(first.ID) + "csrf"} {
+		_, err := store.Client.Put(ctx, key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(grant.ID))
+		assert.Nil(t, err)
+	}
+	_, err = store.Client.Put(ctx, foreign, "x", clientv3.WithLease(grant.ID))
+	assert.Nil(t, err)
+
+	ids, err := s.ListByLease(ctx, grant.ID)
+	assert.Nil(t, err)
+	want := []string{first.ID, second.ID}
+	sort.Strings(want)
+	assert.Equal(t, want, ids)
+
+	_, err = loadByID(s, first.ID)
+	assert.Nil(t, err, "cached")
+	assert.Nil(t, s.RevokeLease(ctx, grant.ID))
+	for _, id := range want {
+		_, err := loadByID(s, id)
+		assert.ErrorIs(t, err, ErrNotFound, "not served from the cache")
+	}
+	resp, err := store.Client.Get(ctx, foreign)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "revoking deletes every key on the lease")
+
+	_, err = loadByID(s, third.ID)
+	assert.Nil(t, err, "on its own lease")
+}
+
+func TestEtcdStore_LeaseID(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	saved, ok := LeaseID(session)
+	assert.True(t, ok)
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, clientv3.LeaseID(resp.Kvs[0].Lease), saved)
+	assert.NotContains(t, string(resp.Kvs[0].Value), leaseKey, "not stored")
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	lease, ok := LeaseID(loaded)
+	assert.True(t, ok)
+	assert.Equal(t, saved, lease)
+
+	// callers can keep it alive themselves
+	_, err = store.Client.KeepAliveOnce(ctx, lease)
+	assert.Nil(t, err)
+
+	_, ok = LeaseID(sessions.NewSession(s, "_session"))
+	assert.False(t, ok)
+}
+
+func TestEtcdStore_AuditLeases(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.PageSize = 2
+	for i := 0; i < 3; i++ {
+		newSavedSession(t, s, nil)
+	}
+	leased := newSavedSession(t, s, nil)
+	ids, err := s.AuditLeases(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+
+	// a plain put, and aux data and reserved keys that aren't sessions
+	_, err = store.Client.Put(ctx, s.key("LEASELESS"), "x")
+	assert.Nil(t, err)
+	_, err = store.Client.Put(ctx, s.auxPrefix(leased.ID)+"plain", "x")
+	assert.Nil(t, err)
+	_, err = store.Client.Put(ctx, s.key(reservedPrefix+"own"), "x")
+	assert.Nil(t, err)
+
+	ids, err = s.AuditLeases(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"LEASELESS"}, ids)
+	resp, err := store.Client.Get(ctx, s.key("LEASELESS"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count, "nothing is deleted")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.AuditLeases(cancelled)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,145 @@
+package etcdstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// leasePoolMaxAge is how long a pre-granted lease stays in the pool. Older
+// leases are revoked rather than handed out, which bounds how much shorter
+// than MaxAge a session drawing from the pool may live.
+const leasePoolMaxAge = time.Minute
+
+// leasePool keeps leases granted ahead of time for the store's default TTL.
+type leasePool struct {
+	mu     sync.Mutex
+	leases []pooledLease
+	closed bool
+	refill chan struct{}
+	stop   chan struct{}
+	start  sync.Once
+}
+
+type pooledLease struct {
+	id        clientv3.LeaseID
+	ttl       int64
+	grantedAt time.Time
+}
+
+// leaseFor returns a lease of ttl seconds, drawn from the pool when
+// LeasePoolSize is set and the pool holds one, or granted on the spot.
+func (s *EtcdStore) leaseFor(ctx context.Context, ttl int64) (clientv3.LeaseID, error) {
+	if s.LeasePoolSize > 0 {
+		if id, ok := s.leasePool.take(s, ttl); ok {
+			return id, nil
+		}
+	}
+
+	grant, err := s.grant(ctx, ttl)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	return grant.ID, nil
+}
+
+// defaultTTL is the lease TTL of a session saved with the store's Options.
+func (s *EtcdStore) defaultTTL() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(s.Options.MaxAge + 1)
+}
+
+// take pops a fresh lease of ttl seconds and asks for the pool to be
+// refilled. Stale leases found on the way are revoked. Once the pool is
+// closed it hands out nothing and is no longer refilled.
+func (p *leasePool) take(s *EtcdStore, ttl int64) (clientv3.LeaseID, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return clientv3.NoLease, false
+	}
+	p.start.Do(func() {
+		p.refill = make(chan struct{}, 1)
+		p.stop = make(chan struct{})
+		go p.run(s, p.refill, p.stop)
+	})
+	defer p.signal()
+
+	for len(p.leases) > 0 {
+		lease := p.leases[0]
+		p.leases = p.leases[1:]
+		if lease.ttl == ttl && s.clock().Sub(lease.grantedAt) < leasePoolMaxAge {
+			return lease.id, true
+		}
+		go s.revoke(s.Context, lease.id)
+	}
+	return clientv3.NoLease, false
+}
+
+func (p *leasePool) signal() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run tops the pool up to LeasePoolSize leases of the default TTL whenever
+// it is signalled, until close is called or BackgroundContext is done.
+func (p *leasePool) run(s *EtcdStore, refill, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.background().Done():
+			return
+		case <-refill:
+		}
+
+		for {
+			p.mu.Lock()
+			full := len(p.leases) >= s.LeasePoolSize
+			p.mu.Unlock()
+			if full {
+				break
+			}
+
+			ttl := s.defaultTTL()
+			grant, err := s.grant(s.background(), ttl)
+			if err != nil {
+				break
+			}
+
+			p.mu.Lock()
+			if p.closed {
+				p.mu.Unlock()
+				s.revoke(s.Context, grant.ID)
+				return
+			}
+			p.leases = append(p.leases, pooledLease{id: grant.ID, ttl: ttl, grantedAt: s.clock()})
+			p.mu.Unlock()
+		}
+	}
+}
+
+// close stops the refill goroutine and revokes the leases still pooled.
+func (p *leasePool) close(s *EtcdStore) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil && !p.closed {
+		close(p.stop)
+	}
+	p.closed = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, lease := range p.leases {
+		s.revoke(ctx, lease.id)
+	}
+	p.leases = nil
+}
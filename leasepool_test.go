@@ -0,0 +1,113 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_LeasePool(t *testing.T) {
+	s := newTestStore(t)
+	var mu sync.Mutex
+	now := time.Now()
+	s.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	s.LeasePoolSize = 2
+	leases := &countingLease{Lease: s.lease}
+	s.lease = leases
+
+	pooled := func() []clientv3.LeaseID {
+		s.leasePool.mu.Lock()
+		defer s.leasePool.mu.Unlock()
+		var ids []clientv3.LeaseID
+		for _, lease := range s.leasePool.leases {
+			ids = append(ids, lease.id)
+		}
+		return ids
+	}
+	leaseOf := func(id string) clientv3.LeaseID {
+		resp, err := s.Client.Get(context.Background(), s.key(id))
+		assert.Nil(t, err)
+		return clientv3.LeaseID(resp.Kvs[0].Lease)
+	}
+	full := func() bool { return len(pooled()) == 2 }
+
+	// the pool starts empty, so the first save grants synchronously
+	newSavedSession(t, s, nil)
+	assert.Eventually(t, full, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, leases.count())
+
+	// then saves draw from the pool
+	ids := pooled()
+	session := newSavedSession(t, s, nil)
+	assert.Contains(t, ids, leaseOf(session.ID))
+	assert.Eventually(t, full, time.Second, 10*time.Millisecond)
+
+	// stale leases are revoked rather than handed out
+	ids = pooled()
+	mu.Lock()
+	now = now.Add(leasePoolMaxAge)
+	mu.Unlock()
+	session = newSavedSession(t, s, nil)
+	assert.NotContains(t, ids, leaseOf(session.ID))
+	assert.Eventually(t, func() bool {
+		resp, err := s.Client.TimeToLive(context.Background(), ids[0])
+		return err == nil && resp.TTL == -1
+	}, time.Second, 10*time.Millisecond)
+
+	// sessions with another MaxAge don't use the pool
+	assert.Eventually(t, full, time.Second, 10*time.Millisecond)
+	ids = pooled()
+	session = newSavedSession(t, s, nil)
+	session.Options.MaxAge = 60
+	assert.Nil(t, s.save(context.Background(), session, false))
+	assert.NotContains(t, ids, leaseOf(session.ID))
+
+	// closing revokes what's left
+	assert.Eventually(t, full, time.Second, 10*time.Millisecond)
+	ids = pooled()
+	s.leasePool.close(s)
+	for _, id := range ids {
+		resp, err := s.Client.TimeToLive(context.Background(), id)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(-1), resp.TTL)
+	}
+
+	// a pool closed before its first use is never filled
+	s = newTestStore(t)
+	s.LeasePoolSize = 2
+	leases = &countingLease{Lease: s.lease}
+	s.lease = leases
+	s.leasePool.close(s)
+	newSavedSession(t, s, nil)
+	assert.Never(t, func() bool { return leases.count() > 1 }, 100*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestEtcdStore_LeasePoolBackground(t *testing.T) {
+	s := newTestStore(t)
+	s.LeasePoolSize = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.BackgroundContext = ctx
+	lease := &stallingLease{Lease: s.lease, given: 1, done: make(chan error, 1)}
+	s.lease = lease
+
+	// the first lease is granted on the spot, the refill's stall
+	_, err := s.leaseFor(context.Background(), s.defaultTTL())
+	assert.Nil(t, err)
+	cancel()
+	select {
+	case err := <-lease.done:
+		assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	case <-time.After(time.Second):
+		t.Error("refill outlived BackgroundContext")
+	}
+}
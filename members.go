@@ -0,0 +1,48 @@
+package etcdstore
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Member describes an etcd cluster member.
+type Member struct {
+	ID         uint64
+	Name       string
+	PeerURLs   []string
+	ClientURLs []string
+	IsLearner  bool
+}
+
+// Members lists the etcd cluster members as reported by the member the
+// client is currently connected to, for diagnosing endpoint configuration.
+// The client only switches to newly added members if the store was created
+// with clientv3.Config.AutoSyncInterval set, so the endpoints it uses may
+// lag behind this list. It isn't available on gateway stores.
+func (s *EtcdStore) Members(ctx context.Context) ([]Member, error) {
+	if s.Client == nil {
+		return nil, ErrGatewayUnsupported
+	}
+
+	var resp *clientv3.MemberListResponse
+	err := s.call(func() (err error) {
+		resp, err = s.Client.MemberList(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]Member, 0, len(resp.Members))
+	for _, m := range resp.Members {
+		members = append(members, Member{
+			ID:         m.ID,
+			Name:       m.Name,
+			PeerURLs:   m.PeerURLs,
+			ClientURLs: m.ClientURLs,
+			IsLearner:  m.IsLearner,
+		})
+	}
+	return members, nil
+}
@@ -0,0 +1,24 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_Members(t *testing.T) {
+	members, err := store.Members(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, members, 1)
+	assert.NotZero(t, members[0].ID)
+	assert.Contains(t, members[0].ClientURLs, _defaultEtcd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = store.Members(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
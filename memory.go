@@ -0,0 +1,478 @@
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// ErrInMemoryUnsupported is returned for lease keep-alives, which the store
+// returned by NewInMemoryStore doesn't implement.
+var ErrInMemoryUnsupported = errors.New("etcdstore: operation not supported by the in-memory store")
+
+// NewInMemoryStore returns a store keeping its sessions in memory instead
+// of etcd, for unit testing handlers without running etcd. FOR TESTS ONLY:
+// nothing is persisted or shared between processes, and memory is only
+// reclaimed as leases expire.
+//
+// The memory backend implements the etcd KV and lease semantics the store
+// relies on: revisions, transactions with compares, prefix ranges and
+// leases expiring after their TTL, measured with the store's clock. It
+// keeps no history, so reads at a past revision fail with
+// rpctypes.ErrCompacted. Its Client field is nil and lease keep-alives fail
+// with ErrInMemoryUnsupported.
+func NewInMemoryStore(prefix string, keyPairs ...[]byte) *EtcdStore {
+	m := &memory{
+		kvs:    make(map[string]*mvccpb.KeyValue),
+		leases: make(map[clientv3.LeaseID]*memoryLease),
+	}
+	s := newStore(nil, clientv3.NewKVFromKVClient(m, nil), m, context.Background(), prefix, keyPairs...)
+	m.now = s.clock
+	return s
+}
+
+// memory implements the etcd KV service and the store's lease needs in
+// memory.
+type memory struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	rev       int64
+	kvs       map[string]*mvccpb.KeyValue
+	leases    map[clientv3.LeaseID]*memoryLease
+	lastLease clientv3.LeaseID
+}
+
+type memoryLease struct {
+	ttl     int64
+	expires time.Time
+	keys    map[string]bool
+}
+
+func (m *memory) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: m.rev}
+}
+
+// expire deletes the keys of the leases past their TTL. m.mu must be held.
+func (m *memory) expire() {
+	now := m.now()
+	for id, lease := range m.leases {
+		if !now.Before(lease.expires) {
+			m.revoke(id)
+		}
+	}
+}
+
+// revoke deletes lease and its keys in a revision of their own. m.mu must
+// be held.
+func (m *memory) revoke(id clientv3.LeaseID) {
+	lease := m.leases[id]
+	delete(m.leases, id)
+	if len(lease.keys) == 0 {
+		return
+	}
+
+	m.rev++
+	for key := range lease.keys {
+		delete(m.kvs, key)
+	}
+}
+
+// inRange reports whether key is in the range of a request for key and
+// rangeEnd, as etcd interprets them.
+func inRange(key, start, rangeEnd []byte) bool {
+	switch {
+	case len(rangeEnd) == 0:
+		return bytes.Equal(key, start)
+	case len(rangeEnd) == 1 && rangeEnd[0] == 0:
+		return bytes.Compare(key, start) >= 0
+	}
+	return bytes.Compare(key, start) >= 0 && bytes.Compare(key, rangeEnd) < 0
+}
+
+// keysIn returns the keys in the range, sorted. m.mu must be held.
+func (m *memory) keysIn(start, rangeEnd []byte) []string {
+	if len(rangeEnd) == 0 {
+		if _, ok := m.kvs[string(start)]; ok {
+			return []string{string(start)}
+		}
+		return nil
+	}
+
+	var keys []string
+	for key := range m.kvs {
+		if inRange([]byte(key), start, rangeEnd) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *memory) Range(ctx context.Context, in *pb.RangeRequest, _ ...grpc.CallOption) (*pb.RangeResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	return m.doRange(in)
+}
+
+func (m *memory) doRange(in *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if in.Revision > 0 && in.Revision < m.rev {
+		return nil, rpctypes.ErrGRPCCompacted
+	}
+	if in.Revision > m.rev {
+		return nil, rpctypes.ErrGRPCFutureRev
+	}
+
+	keys := m.keysIn(in.Key, in.RangeEnd)
+	if in.SortOrder == pb.RangeRequest_DESCEND {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+
+	resp := &pb.RangeResponse{Header: m.header(), Count: int64(len(keys))}
+	if in.CountOnly {
+		return resp, nil
+	}
+	if in.Limit > 0 && int64(len(keys)) > in.Limit {
+		keys, resp.More = keys[:in.Limit], true
+	}
+	for _, key := range keys {
+		kv := *m.kvs[key]
+		if in.KeysOnly {
+			kv.Value = nil
+		}
+		resp.Kvs = append(resp.Kvs, &kv)
+	}
+	return resp, nil
+}
+
+func (m *memory) Put(ctx context.Context, in *pb.PutRequest, _ ...grpc.CallOption) (*pb.PutResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if err := m.checkPut(in); err != nil {
+		return nil, err
+	}
+	m.rev++
+	return m.doPut(in), nil
+}
+
+// checkPut returns the error etcd rejects in with, if any. m.mu must be
+// held.
+func (m *memory) checkPut(in *pb.PutRequest) error {
+	if len(in.Key) == 0 {
+		return rpctypes.ErrGRPCEmptyKey
+	}
+	if _, ok := m.kvs[string(in.Key)]; !ok && (in.IgnoreValue || in.IgnoreLease) {
+		return rpctypes.ErrGRPCKeyNotFound
+	}
+	if in.Lease != 0 && !in.IgnoreLease {
+		if _, ok := m.leases[clientv3.LeaseID(in.Lease)]; !ok {
+			return rpctypes.ErrGRPCLeaseNotFound
+		}
+	}
+	return nil
+}
+
+// doPut applies a checked Put at revision m.rev. m.mu must be held.
+func (m *memory) doPut(in *pb.PutRequest) *pb.PutResponse {
+	key := string(in.Key)
+	resp := &pb.PutResponse{Header: m.header()}
+
+	kv := &mvccpb.KeyValue{Key: in.Key, CreateRevision: m.rev, Value: in.Value, Lease: in.Lease}
+	if prev, ok := m.kvs[key]; ok {
+		if in.PrevKv {
+			prevKV := *prev
+			resp.PrevKv = &prevKV
+		}
+		kv.CreateRevision, kv.Version = prev.CreateRevision, prev.Version
+		if in.IgnoreValue {
+			kv.Value = prev.Value
+		}
+		if in.IgnoreLease {
+			kv.Lease = prev.Lease
+		}
+		if lease, ok := m.leases[clientv3.LeaseID(prev.Lease)]; ok && prev.Lease != kv.Lease {
+			delete(lease.keys, key)
+		}
+	}
+	kv.ModRevision = m.rev
+	kv.Version++
+	if lease, ok := m.leases[clientv3.LeaseID(kv.Lease)]; ok {
+		lease.keys[key] = true
+	}
+	m.kvs[key] = kv
+	return resp
+}
+
+func (m *memory) DeleteRange(ctx context.Context, in *pb.DeleteRangeRequest, _ ...grpc.CallOption) (*pb.DeleteRangeResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if len(m.keysIn(in.Key, in.RangeEnd)) == 0 {
+		return &pb.DeleteRangeResponse{Header: m.header()}, nil
+	}
+	m.rev++
+	return m.doDelete(in), nil
+}
+
+// doDelete applies a DeleteRange at revision m.rev. m.mu must be held.
+func (m *memory) doDelete(in *pb.DeleteRangeRequest) *pb.DeleteRangeResponse {
+	resp := &pb.DeleteRangeResponse{Header: m.header()}
+	for _, key := range m.keysIn(in.Key, in.RangeEnd) {
+		kv := m.kvs[key]
+		if lease, ok := m.leases[clientv3.LeaseID(kv.Lease)]; ok {
+			delete(lease.keys, key)
+		}
+		delete(m.kvs, key)
+		resp.Deleted++
+		if in.PrevKv {
+			resp.PrevKvs = append(resp.PrevKvs, kv)
+		}
+	}
+	return resp
+}
+
+func (m *memory) Txn(ctx context.Context, in *pb.TxnRequest, _ ...grpc.CallOption) (*pb.TxnResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if err := m.checkTxn(in, make(map[string]bool), nil); err != nil {
+		return nil, err
+	}
+
+	// writes take a single revision, which reads within the txn already see
+	m.rev++
+	resp, wrote := m.doTxn(in)
+	if !wrote {
+		m.rev--
+	}
+	resp.Header = m.header()
+	return resp, nil
+}
+
+// checkTxn returns the error etcd rejects in with, if any: an invalid Put
+// in the branch the compares pick, or a key written twice. puts and deletes
+// collect the keys written so far. m.mu must be held.
+func (m *memory) checkTxn(in *pb.TxnRequest, puts map[string]bool, deletes []*pb.DeleteRangeRequest) error {
+	ops := in.Failure
+	if m.compare(in.Compare) {
+		ops = in.Success
+	}
+
+	for _, op := range ops {
+		switch {
+		case op.GetRequestPut() != nil:
+			put := op.GetRequestPut()
+			if err := m.checkPut(put); err != nil {
+				return err
+			}
+			if puts[string(put.Key)] {
+				return rpctypes.ErrGRPCDuplicateKey
+			}
+			puts[string(put.Key)] = true
+		case op.GetRequestDeleteRange() != nil:
+			deletes = append(deletes, op.GetRequestDeleteRange())
+		case op.GetRequestTxn() != nil:
+			if err := m.checkTxn(op.GetRequestTxn(), puts, deletes); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key := range puts {
+		for _, del := range deletes {
+			if inRange([]byte(key), del.Key, del.RangeEnd) {
+				return rpctypes.ErrGRPCDuplicateKey
+			}
+		}
+	}
+	return nil
+}
+
+// doTxn applies a checked txn at revision m.rev, and reports whether it
+// wrote anything. m.mu must be held.
+func (m *memory) doTxn(in *pb.TxnRequest) (*pb.TxnResponse, bool) {
+	resp := &pb.TxnResponse{Succeeded: m.compare(in.Compare)}
+	ops := in.Failure
+	if resp.Succeeded {
+		ops = in.Success
+	}
+
+	var wrote bool
+	for _, op := range ops {
+		switch {
+		case op.GetRequestRange() != nil:
+			m.rev--
+			r, err := m.doRange(op.GetRequestRange())
+			m.rev++
+			if err != nil {
+				r = &pb.RangeResponse{}
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: r}})
+		case op.GetRequestPut() != nil:
+			wrote = true
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: m.doPut(op.GetRequestPut())}})
+		case op.GetRequestDeleteRange() != nil:
+			r := m.doDelete(op.GetRequestDeleteRange())
+			wrote = wrote || r.Deleted > 0
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: r}})
+		case op.GetRequestTxn() != nil:
+			r, w := m.doTxn(op.GetRequestTxn())
+			wrote = wrote || w
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: r}})
+		}
+	}
+	return resp, wrote
+}
+
+// compare reports whether every compare holds. A compare over a range holds
+// if it does for every key in it, or for a missing key if there are none.
+// m.mu must be held.
+func (m *memory) compare(cmps []*pb.Compare) bool {
+	for _, cmp := range cmps {
+		keys := m.keysIn(cmp.Key, cmp.RangeEnd)
+		if len(keys) == 0 {
+			if cmp.Target == pb.Compare_VALUE || !compareKV(cmp, &mvccpb.KeyValue{}) {
+				return false
+			}
+		}
+		for _, key := range keys {
+			if !compareKV(cmp, m.kvs[key]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareKV(cmp *pb.Compare, kv *mvccpb.KeyValue) bool {
+	var result int
+	switch cmp.Target {
+	case pb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, cmp.GetValue())
+	case pb.Compare_VERSION:
+		result = compareInt(kv.Version, cmp.GetVersion())
+	case pb.Compare_CREATE:
+		result = compareInt(kv.CreateRevision, cmp.GetCreateRevision())
+	case pb.Compare_MOD:
+		result = compareInt(kv.ModRevision, cmp.GetModRevision())
+	case pb.Compare_LEASE:
+		result = compareInt(kv.Lease, cmp.GetLease())
+	}
+
+	switch cmp.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	case pb.Compare_LESS:
+		return result < 0
+	}
+	return false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (m *memory) Compact(ctx context.Context, in *pb.CompactionRequest, _ ...grpc.CallOption) (*pb.CompactionResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return &pb.CompactionResponse{Header: m.header()}, nil
+}
+
+func (m *memory) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	m.lastLease++
+	m.leases[m.lastLease] = &memoryLease{
+		ttl:     ttl,
+		expires: m.now().Add(time.Duration(ttl) * time.Second),
+		keys:    make(map[string]bool),
+	}
+	return &clientv3.LeaseGrantResponse{ResponseHeader: m.header(), ID: m.lastLease, TTL: ttl}, nil
+}
+
+func (m *memory) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if _, ok := m.leases[id]; !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	m.revoke(id)
+	return &clientv3.LeaseRevokeResponse{Header: m.header()}, nil
+}
+
+// TimeToLive always returns the attached keys, as clientv3.WithAttachedKeys
+// can't be told apart. As in etcd, a lease that doesn't exist has a TTL of
+// -1.
+func (m *memory) TimeToLive(ctx context.Context, id clientv3.LeaseID, _ ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	resp := &clientv3.LeaseTimeToLiveResponse{ResponseHeader: m.header(), ID: id, TTL: -1}
+	lease, ok := m.leases[id]
+	if !ok {
+		return resp, nil
+	}
+
+	resp.TTL = int64(lease.expires.Sub(m.now()) / time.Second)
+	resp.GrantedTTL = lease.ttl
+	for key := range lease.keys {
+		resp.Keys = append(resp.Keys, []byte(key))
+	}
+	sort.Slice(resp.Keys, func(i, j int) bool { return bytes.Compare(resp.Keys[i], resp.Keys[j]) < 0 })
+	return resp, nil
+}
+
+func (m *memory) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	resp := &clientv3.LeaseLeasesResponse{ResponseHeader: m.header()}
+	for id := range m.leases {
+		resp.Leases = append(resp.Leases, clientv3.LeaseStatus{ID: id})
+	}
+	sort.Slice(resp.Leases, func(i, j int) bool { return resp.Leases[i].ID < resp.Leases[j].ID })
+	return resp, nil
+}
+
+func (m *memory) KeepAlive(context.Context, clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	return nil, ErrInMemoryUnsupported
+}
+
+func (m *memory) KeepAliveOnce(context.Context, clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	return nil, ErrInMemoryUnsupported
+}
+
+func (m *memory) Close() error {
+	return nil
+}
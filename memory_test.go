@@ -0,0 +1,108 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStore("/sessions", []byte("secret"))
+	defer s.Close()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	// round-trip through the cookie
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	session.Values["foo"] = "bar"
+	session.Options.MaxAge = 60
+	w := httptest.NewRecorder()
+	assert.Nil(t, s.Save(req, w, session))
+
+	req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	loaded, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	// move, then let the lease expire
+	assert.Nil(t, s.MoveSession(ctx, "_session", session.ID, "moved-"+session.ID))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	moved, err := loadByID(s, "moved-"+session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", moved.Values["foo"])
+
+	now = now.Add(61 * time.Second)
+	_, err = loadByID(s, moved.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// delete
+	session = newSavedSession(t, s, nil)
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// etcd's errors
+	_, err = s.kv.Put(ctx, "/sessions/orphan", "", clientv3.WithLease(1234))
+	assert.Equal(t, rpctypes.ErrLeaseNotFound, err)
+	_, err = s.kv.Put(ctx, "/sessions/orphan", "", clientv3.WithIgnoreValue())
+	assert.Equal(t, rpctypes.ErrKeyNotFound, err)
+	_, err = s.lease.KeepAliveOnce(ctx, 1)
+	assert.True(t, errors.Is(err, ErrInMemoryUnsupported))
+}
+
+func TestInMemoryStore_Features(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStore("/sessions", []byte("secret"))
+	defer s.Close()
+	s.now = tickingClock()
+	s.UserIDKey = "user"
+	s.MaxSessionsPerUser = 2
+
+	first := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	second := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	third := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{second.ID, third.ID}, ids)
+	_, err = loadByID(s, first.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "oldest evicted")
+
+	// aux data shares the session's lease
+	assert.Nil(t, s.PutAux(ctx, second, "token", []byte("t0k3n")))
+	value, err := s.GetAux(ctx, second, "token")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("t0k3n"), value)
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), second))
+	value, err = s.GetAux(ctx, second, "token")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("t0k3n"), value)
+
+	ids, err = s.FilterSessions(ctx, "_session", func(session *sessions.Session) bool {
+		return session.ID == third.ID
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{third.ID}, ids)
+
+	// creation is exclusive
+	session := sessions.NewSession(s, "_session")
+	session.ID = third.ID
+	session.Options = &sessions.Options{MaxAge: 60}
+	created, err := s.CreateIfAbsent(ctx, session)
+	assert.Nil(t, err)
+	assert.False(t, created)
+}
@@ -0,0 +1,96 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// SessionMeta describes how a session is stored, as returned by
+// GetWithMeta. etcd keeps no creation time, CreateRevision orders sessions
+// by creation instead. The fields are zero for a new session.
+type SessionMeta struct {
+	// CreateRevision and ModRevision are the etcd revisions at which the
+	// session key was created and last written. Both are zero for bucketed
+	// sessions.
+	CreateRevision int64
+	ModRevision    int64
+
+	// LastAccess is when the session was last loaded or saved, tracked only
+	// under IdleTimeout, and zero otherwise.
+	LastAccess time.Time
+
+	// TTL is how long the session has left to live.
+	TTL time.Duration
+
+	// Size is the length of the stored value in bytes.
+	Size int
+}
+
+// GetWithMeta is Get, also returning metadata about the stored session.
+// The metadata of a session the registry already holds for r is read from
+// etcd again.
+func (s *EtcdStore) GetWithMeta(r *http.Request, name string) (*sessions.Session, SessionMeta, error) {
+	var meta SessionMeta
+	s.track(r, name)
+	session, err := s.registryGet(r, metaStore{s, &meta}, name)
+	if errors.Is(err, ErrUnavailable) {
+		return nil, SessionMeta{}, err
+	}
+	// stored values are never empty, so Size is only zero when the registry
+	// already held the session
+	if session.IsNew || meta.Size != 0 {
+		return session, meta, err
+	}
+
+	loaded := sessions.NewSession(s, name)
+	loaded.ID = session.ID
+	if err := s.loadWithMeta(s.Context, loaded, &meta); err != nil {
+		return session, SessionMeta{}, s.sessionError("load", name, err)
+	}
+	return session, meta, err
+}
+
+// metaStore hands the gorilla registry sessions loaded with their metadata.
+type metaStore struct {
+	*EtcdStore
+	meta *SessionMeta
+}
+
+func (s metaStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := s.newSession(r, name, s.meta)
+	if session == nil {
+		// see strictStore
+		session = sessions.NewSession(s.EtcdStore, name)
+	}
+	return session, s.sessionError("load", name, err)
+}
+
+// fillMeta sets meta from what load read for session: kv, or for a
+// bucketed session the value's size and expiry time.
+func (s *EtcdStore) fillMeta(ctx context.Context, meta *SessionMeta, session *sessions.Session, kv *mvccpb.KeyValue, size int, expires int64) error {
+	*meta = SessionMeta{Size: size}
+	if last, ok := session.Values[lastAccessKey].(int64); ok {
+		meta.LastAccess = time.Unix(0, last)
+	}
+
+	if kv == nil {
+		meta.TTL = time.Unix(0, expires).Sub(s.clock())
+		return nil
+	}
+
+	meta.CreateRevision, meta.ModRevision = kv.CreateRevision, kv.ModRevision
+	if kv.Lease != 0 {
+		resp, err := s.timeToLive(ctx, clientv3.LeaseID(kv.Lease))
+		if err != nil {
+			return err
+		}
+		meta.TTL = time.Duration(resp.TTL) * time.Second
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_GetWithMeta(t *testing.T) {
+	s := newTestStore(t)
+	s.IdleTimeout = time.Hour
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, meta, err := s.GetWithMeta(req, "_session")
+	assert.Nil(t, err)
+	assert.True(t, session.IsNew)
+	assert.Equal(t, SessionMeta{}, meta)
+
+	session.Values["foo"] = "bar"
+	rsp := httptest.NewRecorder()
+	assert.Nil(t, s.Save(req, rsp, session))
+	cookie := rsp.Result().Cookies()[0]
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(cookie)
+	loaded, meta, err := s.GetWithMeta(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.NotZero(t, meta.CreateRevision)
+	assert.GreaterOrEqual(t, meta.ModRevision, meta.CreateRevision)
+	assert.Greater(t, meta.Size, 0)
+	assert.Greater(t, meta.TTL, time.Duration(0))
+	assert.LessOrEqual(t, meta.TTL, time.Duration(session.Options.MaxAge+1)*time.Second)
+	assert.WithinDuration(t, time.Now(), meta.LastAccess, time.Minute)
+
+	// the registry holds it now, the metadata is read on its own
+	again, metaAgain, err := s.GetWithMeta(req, "_session")
+	assert.Nil(t, err)
+	assert.Same(t, loaded, again)
+	assert.Equal(t, meta.CreateRevision, metaAgain.CreateRevision)
+	assert.Greater(t, metaAgain.Size, 0)
+}
@@ -0,0 +1,21 @@
+package etcdstore
+
+import (
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Middleware wraps the etcd KV and lease clients the store runs its
+// requests through, e.g. to count or time RPCs, or to attach metadata to
+// their contexts. The returned clients must delegate to kv and lease.
+//
+// Every Get, Put, Delete and Txn goes through clientv3.KV; a transaction
+// is sent by Commit on the clientv3.Txn that KV.Txn returns. Grants,
+// revokes, TimeToLive and keep-alives go through clientv3.Lease. Only
+// EndpointHealth and Members use the Client directly.
+type Middleware func(kv clientv3.KV, lease clientv3.Lease) (clientv3.KV, clientv3.Lease)
+
+// Use wraps the clients of the store with m, on top of the middleware used
+// before. It must be called before the store is used.
+func (s *EtcdStore) Use(m Middleware) {
+	s.kv, s.lease = m(s.kv, s.lease)
+}
@@ -0,0 +1,45 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_Use(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	kv, lease := &countingKV{}, &countingLease{}
+	var outer int
+	s.Use(func(inner clientv3.KV, innerLease clientv3.Lease) (clientv3.KV, clientv3.Lease) {
+		kv.KV, lease.Lease = inner, innerLease
+		return kv, lease
+	})
+	s.Use(func(inner clientv3.KV, innerLease clientv3.Lease) (clientv3.KV, clientv3.Lease) {
+		outer++
+		assert.Same(t, kv, inner, "stacked")
+		return inner, innerLease
+	})
+	assert.Equal(t, 1, outer)
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	assert.Equal(t, map[string]int{"put": 1}, kv.snapshot())
+	assert.Equal(t, 1, lease.count())
+
+	_, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"put": 1, "get": 1}, kv.snapshot())
+
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+	assert.Equal(t, map[string]int{"put": 1, "get": 2, "txn": 1}, kv.snapshot())
+
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	assert.Equal(t, map[string]int{"put": 1, "get": 2, "txn": 2}, kv.snapshot(), "deleted with its aux data")
+
+	assert.Nil(t, s.DeleteRaw(ctx, "raw"))
+	assert.Equal(t, 1, kv.snapshot()["delete"])
+}
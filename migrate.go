@@ -0,0 +1,58 @@
+package etcdstore
+
+import (
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// MigrateSession moves legacy, a session decoded by another store such as a
+// sessions.CookieStore, into etcd under a new ID and sets the cookie for it,
+// so that the following requests are served from etcd. It returns the
+// store's session for r, now holding the legacy values; a session already
+// loaded from etcd is returned unchanged.
+//
+// To migrate from a CookieStore, keep it around during the transition and,
+// for each request, try the etcd store first. The legacy session must be
+// decoded with New, as the registry behind Get holds one session per name:
+//
+//	session, _ := etcdStore.Get(r, "session")
+//	if session.IsNew {
+//		legacy, err := cookieStore.New(r, "session")
+//		if err == nil && !legacy.IsNew {
+//			session, err = etcdStore.MigrateSession(r, w, legacy)
+//		}
+//	}
+//
+// The new cookie has the legacy session's name, so it replaces the legacy
+// cookie in the browser. Should the legacy cookie use a different path or
+// domain than the store's Options, it is expired explicitly. The legacy
+// cookie remains valid for its own store until its keys are rotated, though:
+// a copy kept by the client can be migrated again, so drop the CookieStore
+// once the transition is over.
+func (s *EtcdStore) MigrateSession(r *http.Request, w http.ResponseWriter, legacy *sessions.Session) (*sessions.Session, error) {
+	// the legacy cookie fails to decode here when it has the same name,
+	// which is expected
+	session, err := s.Get(r, legacy.Name())
+	if session == nil {
+		return nil, err
+	}
+	if !session.IsNew {
+		return session, nil
+	}
+
+	session.ID = ""
+	for k, v := range legacy.Values {
+		session.Values[k] = v
+	}
+	if err := s.Save(r, w, session); err != nil {
+		return nil, err
+	}
+
+	if old, options := legacy.Options, session.Options; old != nil && (old.Path != options.Path || old.Domain != options.Domain) {
+		expired := *old
+		expired.MaxAge = -1
+		s.setCookie(w, sessions.NewCookie(legacy.Name(), "", &expired))
+	}
+	return session, nil
+}
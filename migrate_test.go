@@ -0,0 +1,65 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_MigrateSession(t *testing.T) {
+	s := newTestStore(t)
+	cookieStore := sessions.NewCookieStore([]byte("legacy-secret"))
+	cookieStore.Options.Path = "/app"
+
+	// a session held in a legacy cookie
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	legacy, err := cookieStore.New(req, "_session")
+	assert.Nil(t, err)
+	legacy.Values["user"] = "alice"
+	rsp := httptest.NewRecorder()
+	assert.Nil(t, legacy.Save(req, rsp))
+	legacyCookie := rsp.Result().Cookies()[0]
+
+	// the next request carries it and gets upgraded
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(legacyCookie)
+
+	session, _ := s.Get(req, "_session")
+	assert.True(t, session.IsNew, "legacy cookie isn't an etcd session")
+	legacy, err = cookieStore.New(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, legacy.IsNew)
+
+	rsp = httptest.NewRecorder()
+	migrated, err := s.MigrateSession(req, rsp, legacy)
+	assert.Nil(t, err)
+	assert.Same(t, session, migrated, "the registry's session is migrated")
+	assert.NotEqual(t, "", migrated.ID)
+
+	cookies := rsp.Result().Cookies()
+	assert.Len(t, cookies, 2)
+	assert.Equal(t, "/", cookies[0].Path)
+	assert.Equal(t, "/app", cookies[1].Path)
+	assert.Equal(t, -1, cookies[1].MaxAge, "legacy cookie expired")
+
+	// the request after that is served from etcd
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(cookies[0])
+	loaded, err := s.Get(req, "_session")
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, migrated.ID, loaded.ID)
+	assert.Equal(t, "alice", loaded.Values["user"])
+
+	rsp = httptest.NewRecorder()
+	again, err := s.MigrateSession(req, rsp, legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, loaded.ID, again.ID, "etcd sessions aren't migrated again")
+	assert.Len(t, rsp.Result().Cookies(), 0)
+}
@@ -0,0 +1,47 @@
+package etcdstore
+
+import (
+	"errors"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// ErrStorageFull is returned by Save when etcd rejected the write because
+// its database exceeded its space quota and raised the NOSPACE alarm. etcd
+// refuses writes until space is reclaimed by compaction and
+// defragmentation and the alarm is disarmed. The etcd error is wrapped as
+// well.
+var ErrStorageFull = errors.New("etcdstore: etcd storage quota exceeded")
+
+// storageFullError wraps the etcd error behind an ErrStorageFull.
+type storageFullError struct {
+	err error
+}
+
+func (e storageFullError) Error() string {
+	return ErrStorageFull.Error() + ": " + e.err.Error()
+}
+
+func (e storageFullError) Is(target error) bool {
+	return target == ErrStorageFull
+}
+
+func (e storageFullError) Unwrap() error {
+	return e.err
+}
+
+// saveError returns the error save reports for a failed write: an
+// ErrSaveUncertain for a timeout, and an ErrStorageFull, after calling
+// OnQuotaExceeded, when etcd is out of space.
+func (s *EtcdStore) saveError(err error) error {
+	switch {
+	case isTimeout(err):
+		return uncertainError{err}
+	case errors.Is(err, rpctypes.ErrNoSpace):
+		if s.OnQuotaExceeded != nil {
+			s.OnQuotaExceeded(err)
+		}
+		return storageFullError{err}
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package etcdstore
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+func TestEtcdStore_StorageFull(t *testing.T) {
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	var alerts []error
+	s.OnQuotaExceeded = func(err error) { alerts = append(alerts, err) }
+	s.kv = &faultyKV{KV: s.kv, err: rpctypes.ErrNoSpace}
+
+	err := s.Save(nil, httptest.NewRecorder(), session)
+	assert.ErrorIs(t, err, ErrStorageFull)
+	assert.ErrorIs(t, err, rpctypes.ErrNoSpace, "the etcd error is kept")
+	assert.NotErrorIs(t, err, ErrSaveUncertain)
+	assert.Equal(t, []error{rpctypes.ErrNoSpace}, alerts)
+
+	// other failures aren't mistaken for it
+	s.kv.(*faultyKV).err = rpctypes.ErrPermissionDenied
+	err = s.Save(nil, httptest.NewRecorder(), session)
+	assert.NotErrorIs(t, err, ErrStorageFull)
+	assert.Len(t, alerts, 1)
+}
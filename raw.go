@@ -0,0 +1,89 @@
+package etcdstore
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// FindRaw returns the bytes stored by CommitRaw under token, and whether
+// there are any. Raw values are opaque to the store: they aren't encoded,
+// cached, bucketed or indexed, which lets session managers other than
+// gorilla/sessions keep their own format under the prefix, see the
+// scsstore package.
+func (s *EtcdStore) FindRaw(ctx context.Context, token string) ([]byte, bool, error) {
+	if err := s.checkPlainID(token); err != nil {
+		return nil, false, err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer done()
+
+	var value []byte
+	var found bool
+	err = s.retry(ctx, func() error {
+		resp, err := s.get(ctx, s.key(token), s.readOpts(ReadLoad, s.GetOptions...)...)
+		if err != nil {
+			return err
+		}
+		if found = resp.Count > 0; found {
+			value = resp.Kvs[0].Value
+		}
+		return nil
+	})
+	return value, found, err
+}
+
+// CommitRaw stores b under token on a lease expiring at expiry, rounded up
+// to the second and at least one from now.
+func (s *EtcdStore) CommitRaw(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	if err := s.checkPlainID(token); err != nil {
+		return err
+	}
+	if err := checkPutOptions(s.PutOptions); err != nil {
+		return err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ttl := int64((expiry.Sub(s.clock()) + time.Second - 1) / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	key := s.key(token)
+	s.uncache(key)
+	return s.retry(ctx, func() error {
+		lease, err := s.leaseFor(ctx, ttl)
+		if err != nil {
+			return err
+		}
+		_, err = s.put(ctx, key, string(b), append(append([]clientv3.OpOption{}, s.PutOptions...), clientv3.WithLease(lease))...)
+		return err
+	})
+}
+
+// DeleteRaw deletes what CommitRaw stored under token. It isn't an error if
+// there is nothing.
+func (s *EtcdStore) DeleteRaw(ctx context.Context, token string) error {
+	if err := s.checkPlainID(token); err != nil {
+		return err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	key := s.key(token)
+	s.uncache(key)
+	return s.retry(ctx, func() error {
+		_, err := s.del(ctx, key)
+		return err
+	})
+}
@@ -0,0 +1,121 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// ReassignUser binds session to newUserID, "" for no user, e.g. to hand an
+// admin's session back once they stop impersonating a user. It sets the
+// UserIDKey value and moves the session's user index entry, from the user it
+// was indexed under to newUserID, in the same transaction as it writes the
+// session, keeping its lease: the session is never indexed under both users
+// or neither. MaxSessionsPerUser applies, evicting the oldest sessions of
+// newUserID as Save would.
+//
+// The other values are written as they are in session, which must be the
+// stored one: the session is rewritten whole, as by Touch, and fails with
+// ErrNotFound if it expired. It requires UserIDKey to be set.
+func (s *EtcdStore) ReassignUser(ctx context.Context, session *sessions.Session, newUserID string) error {
+	return s.sessionError("reassign", session.Name(), s.reassignUser(ctx, session, newUserID))
+}
+
+func (s *EtcdStore) reassignUser(ctx context.Context, session *sessions.Session, newUserID string) error {
+	if s.UserIDKey == "" {
+		return errors.New("etcdstore: ReassignUser requires UserIDKey")
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	prevUser, _ := session.Values[indexedUserKey].(string)
+	if newUserID != "" {
+		session.Values[s.UserIDKey] = newUserID
+	} else {
+		delete(session.Values, s.UserIDKey)
+	}
+
+	if s.Buckets > 0 {
+		// bucketed sessions aren't indexed
+		encoded, err := s.encode(session.Name(), session.ID, session.Values)
+		if err != nil {
+			return err
+		}
+		return s.updateBucket(ctx, session.ID, func(entries map[string]bucketEntry) error {
+			entry, ok := entries[session.ID]
+			if !ok {
+				return fmt.Errorf("key: %s/%s is %w", s.bucketKey(session.ID), session.ID, ErrNotFound)
+			}
+			entry.Value = []byte(encoded)
+			entries[session.ID] = entry
+			return nil
+		})
+	}
+
+	if newUserID != "" {
+		session.Values[indexedUserKey] = newUserID
+	} else {
+		delete(session.Values, indexedUserKey)
+	}
+	encoded, err := s.encode(session.Name(), session.ID, session.Values)
+	if err != nil {
+		return err
+	}
+
+	key := s.key(session.ID)
+	s.uncache(key)
+	for {
+		resp, err := s.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if resp.Count == 0 {
+			return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+		}
+		kv := resp.Kvs[0]
+		lease := clientv3.LeaseID(kv.Lease)
+
+		cmps, ops, evicted, err := s.indexTxn(ctx, session, prevUser, newUserID, lease)
+		if err != nil {
+			return err
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+		ops = append(ops, clientv3.OpPut(key, encoded, clientv3.WithIgnoreLease()))
+		var digests map[string]string
+		if s.fieldKeys() {
+			var fieldOps []clientv3.Op
+			if fieldOps, digests, err = s.rewriteFieldOps(ctx, session, lease); err != nil {
+				return err
+			}
+			ops = append(ops, fieldOps...)
+		}
+
+		txn, err := s.commit(s.kv.Txn(ctx).If(cmps...).Then(ops...))
+		if err != nil {
+			return err
+		}
+		if !txn.Succeeded {
+			// the session or the index changed under us, start over
+			continue
+		}
+		if s.fieldKeys() {
+			setFieldDigests(session, digests)
+		}
+
+		for _, id := range evicted {
+			s.uncache(s.key(id))
+			s.publish(EventDeleted, session.Name(), id)
+		}
+		if len(evicted) > 0 && s.OnEvict != nil {
+			s.OnEvict(newUserID, evicted)
+		}
+		s.publish(EventSaved, session.Name(), session.ID)
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_ReassignUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+
+	// an admin impersonating alice
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice", "cart": "apple"})
+	lease, _ := LeaseID(session)
+	assert.Nil(t, s.ReassignUser(ctx, session, "admin"))
+
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+	ids, err = s.UserSessions(ctx, "admin")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids)
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", loaded.Values["user"])
+	assert.Equal(t, "apple", loaded.Values["cart"])
+	resp, err := s.Client.Get(ctx, s.userIndexKey("admin", session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(lease), resp.Kvs[0].Lease, "index entry on the session's lease")
+	moved, _ := LeaseID(loaded)
+	assert.Equal(t, lease, moved, "lease kept")
+
+	// unbound, then bound again from no index entry at all
+	assert.Nil(t, s.ReassignUser(ctx, loaded, ""))
+	ids, err = s.UserSessions(ctx, "admin")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.NotContains(t, loaded.Values, "user")
+	assert.Nil(t, s.ReassignUser(ctx, loaded, "bob"))
+	ids, err = s.UserSessions(ctx, "bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids)
+
+	// gone
+	loaded.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+	assert.True(t, errors.Is(s.ReassignUser(ctx, loaded, "alice"), ErrNotFound))
+	ids, err = s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+}
+
+func TestEtcdStore_ReassignUserWithoutIndex(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	assert.NotNil(t, s.ReassignUser(ctx, session, "bob"), "no UserIDKey")
+
+	s.Buckets = 4
+	s.UserIDKey = "user"
+	bucketed := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	assert.Nil(t, s.ReassignUser(ctx, bucketed, "bob"))
+	loaded, err := loadByID(s, bucketed.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", loaded.Values["user"])
+	ids, err := s.UserSessions(ctx, "bob")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0, "bucketed sessions aren't indexed")
+}
@@ -0,0 +1,95 @@
+package etcdstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+)
+
+// RedistoreSerializer stores session values in the format of redistore
+// (github.com/boj/redistore), whose SessionSerializer it matches, so its
+// serializers can be used as well. Redistore keeps under its key, by default
+// "session_" followed by the session ID, the bytes of Serialize as they
+// are: neither signed, encrypted nor base64 encoded.
+type RedistoreSerializer interface {
+	Serialize(session *sessions.Session) ([]byte, error)
+	Deserialize(data []byte, session *sessions.Session) error
+}
+
+// RedistoreGobSerializer writes session values as redistore's default
+// GobSerializer does: the gob encoding of session.Values.
+type RedistoreGobSerializer struct{}
+
+// Serialize gob encodes session.Values.
+func (RedistoreGobSerializer) Serialize(session *sessions.Session) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Deserialize gob decodes data into session.Values.
+func (RedistoreGobSerializer) Deserialize(data []byte, session *sessions.Session) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values)
+}
+
+// RedistoreJSONSerializer writes session values as redistore's
+// JSONSerializer does: a JSON object, which takes string keys only and
+// restores numbers as float64, as securecookie.JSONEncoder does.
+type RedistoreJSONSerializer struct{}
+
+// Serialize encodes session.Values as a JSON object.
+func (RedistoreJSONSerializer) Serialize(session *sessions.Session) ([]byte, error) {
+	object := make(map[string]interface{}, len(session.Values))
+	for k, v := range session.Values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("etcdstore: redistore json: key %v is %T, not a string", k, k)
+		}
+		object[key] = v
+	}
+	return json.Marshal(object)
+}
+
+// Deserialize decodes the JSON object data into session.Values.
+func (RedistoreJSONSerializer) Deserialize(data []byte, session *sessions.Session) error {
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	if session.Values == nil {
+		session.Values = make(map[interface{}]interface{}, len(object))
+	}
+	for k, v := range object {
+		session.Values[k] = v
+	}
+	return nil
+}
+
+// encodeRedistore is encode for RedistoreFormat.
+func (s *EtcdStore) encodeRedistore(name string, values map[interface{}]interface{}) (string, error) {
+	data, err := s.RedistoreFormat.Serialize(&sessions.Session{Values: values})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeRedistore is decode for RedistoreFormat. A value in the store's own
+// format is still read, so sessions saved before the switch survive it.
+func (s *EtcdStore) decodeRedistore(name string, data []byte, values *map[interface{}]interface{}) error {
+	session := &sessions.Session{Values: *values}
+	err := s.RedistoreFormat.Deserialize(data, session)
+	if err == nil {
+		*values = session.Values
+		return nil
+	}
+	if s.decodeDefault(name, data, values) == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrCorruptValue, err)
+}
@@ -0,0 +1,51 @@
+package etcdstore
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_RedistoreFormat(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	// saved before the switch, in the store's own format
+	before := newSavedSession(t, s, map[interface{}]interface{}{"user": "bob"})
+
+	// what redistore's GobSerializer writes for {"user": "alice"}
+	sample, err := hex.DecodeString("0d7f040102ff800001100110000023ff80000106737472696e670c0600047573657206737472696e670c070005616c696365")
+	assert.Nil(t, err)
+
+	for _, tt := range []struct {
+		name       string
+		serializer RedistoreSerializer
+		sample     []byte
+		user       interface{}
+	}{
+		{"gob", RedistoreGobSerializer{}, sample, "alice"},
+		{"json", RedistoreJSONSerializer{}, []byte(`{"n":3,"user":"alice"}`), "alice"},
+	} {
+		s.RedistoreFormat = tt.serializer
+		id, err := newSessionID()
+		assert.Nil(t, err)
+		_, err = s.Client.Put(ctx, s.key(id), string(tt.sample))
+		assert.Nil(t, err)
+
+		session, err := loadByID(s, id)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.user, session.Values["user"], tt.name)
+
+		session.Options.MaxAge = 60
+		assert.Nil(t, s.Save(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder(), session), tt.name)
+		resp, err := s.Client.Get(ctx, s.key(id))
+		assert.Nil(t, err)
+		assert.Equal(t, string(tt.sample), string(resp.Kvs[0].Value), tt.name)
+
+		old, err := loadByID(s, before.ID)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, "bob", old.Values["user"], tt.name)
+	}
+}
@@ -0,0 +1,140 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// refreshLeaseMemo bounds how many leases RefreshAll remembers having
+// replaced, to move the keys met later on them to the same new lease.
+const refreshLeaseMemo = 4096
+
+// RefreshAll extends by extend the remaining lifetime of every session
+// under scope, e.g. during a maintenance window that would otherwise log
+// users out, and returns how many sessions it extended. Scope is a prefix
+// as passed to WithPrefix, "" for the store's own, and covers the prefixes
+// below it as well, though only the sessions of scope itself are counted.
+//
+// It pages through the keys under scope with PageSize and moves them,
+// auxiliary data and user index entries included, from each lease they are
+// on to a new one of its remaining time plus extend, rounded up to the
+// second. Keys sharing a lease, under LeasePoolSize for instance, or a
+// session and its auxiliary data, move to the same new lease as long as
+// fewer than refreshLeaseMemo other leases come between them in key order,
+// and otherwise to new leases of the same lifetime; the keys outside scope
+// stay on the old one. A key moves only if it is still on its lease: one
+// saved or deleted meanwhile is left alone, and so are keys on a lease that
+// already expired, keys without a lease, such as Buckets, and keep-alives
+// started before, which renew the old lease. A later Save moves a session
+// back to a lease of its MaxAge.
+//
+// It stops when ctx is done, returning the sessions extended so far along
+// with the error.
+func (s *EtcdStore) RefreshAll(ctx context.Context, scope string, extend time.Duration) (int64, error) {
+	if extend <= 0 {
+		return 0, errors.New("etcdstore: RefreshAll requires a positive extend")
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	prefix := s.key("")
+	if scope != "" {
+		prefix = strings.TrimSuffix(scope, s.keyDelimiter) + s.keyDelimiter
+	}
+	seconds := int64((extend + time.Second - 1) / time.Second)
+
+	var count int64
+	replaced := make(map[clientv3.LeaseID]clientv3.LeaseID)
+	err = s.scanPages(ctx, prefix, func(kvs []*mvccpb.KeyValue) error {
+		byLease := make(map[clientv3.LeaseID][]string)
+		var leases []clientv3.LeaseID
+		for _, kv := range kvs {
+			lease := clientv3.LeaseID(kv.Lease)
+			if lease == clientv3.NoLease {
+				continue
+			}
+			if _, ok := byLease[lease]; !ok {
+				leases = append(leases, lease)
+			}
+			byLease[lease] = append(byLease[lease], string(kv.Key))
+		}
+
+		for _, lease := range leases {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if len(replaced) >= refreshLeaseMemo {
+				replaced = make(map[clientv3.LeaseID]clientv3.LeaseID)
+			}
+			n, err := s.refreshLease(ctx, prefix, lease, byLease[lease], seconds, replaced)
+			count += n
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, s.readOpts(ReadScan, clientv3.WithKeysOnly())...)
+	return count, err
+}
+
+// refreshLease moves keys from lease to a new lease of its remaining time
+// plus extend seconds, the one lease was already replaced with if recorded
+// in replaced, and returns how many of them are sessions of prefix.
+func (s *EtcdStore) refreshLease(ctx context.Context, prefix string, lease clientv3.LeaseID, keys []string, extend int64, replaced map[clientv3.LeaseID]clientv3.LeaseID) (int64, error) {
+	next, reused := replaced[lease]
+	if !reused {
+		ttl, err := s.timeToLive(ctx, lease)
+		if err != nil {
+			return 0, err
+		}
+		if ttl.TTL <= 0 {
+			// expired, its keys are gone or about to be
+			return 0, nil
+		}
+
+		grant, err := s.grant(ctx, ttl.TTL+extend)
+		if err != nil {
+			return 0, err
+		}
+		next = grant.ID
+	}
+	ops := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		ops[i] = clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.LeaseValue(key), "=", lease)},
+			[]clientv3.Op{clientv3.OpPut(key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(next))},
+			nil)
+	}
+	s.uncache(keys...)
+	resp, err := s.commit(s.kv.Txn(ctx).Then(ops...))
+	if err != nil {
+		// the txn may have gone through, so the lease can't be revoked
+		return 0, err
+	}
+
+	var moved, sessions int64
+	for i, key := range keys {
+		if !resp.Responses[i].GetResponseTxn().Succeeded {
+			continue
+		}
+		moved++
+		if id := strings.TrimPrefix(key, prefix); !strings.HasPrefix(id, reservedPrefix) && !strings.Contains(id, s.keyDelimiter) {
+			sessions++
+		}
+	}
+	switch {
+	case moved > 0:
+		replaced[lease] = next
+	case !reused:
+		s.revoke(s.Context, next)
+	}
+	return sessions, nil
+}
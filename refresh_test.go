@@ -0,0 +1,74 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_RefreshAll(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.Options.MaxAge = 60
+	other := s.WithPrefix(s.keyPrefix + "/other")
+
+	var saved []string
+	for i := 0; i < 5; i++ {
+		saved = append(saved, newSavedSession(t, s, nil).ID)
+	}
+	withAux := newSavedSession(t, s, nil)
+	assert.Nil(t, s.PutAux(ctx, withAux, "csrf", []byte("token")))
+	saved = append(saved, withAux.ID)
+	nested := newSavedSession(t, other, nil)
+
+	leaseTTL := func(key string) int64 {
+		resp, err := s.Client.Get(ctx, key)
+		assert.Nil(t, err)
+		if !assert.Len(t, resp.Kvs, 1) {
+			return 0
+		}
+		ttl, err := s.Client.TimeToLive(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+		assert.Nil(t, err)
+		return ttl.TTL
+	}
+	leaseOf := func(key string) int64 {
+		resp, err := s.Client.Get(ctx, key)
+		assert.Nil(t, err)
+		return resp.Kvs[0].Lease
+	}
+
+	// in pages of 4, the nested prefix and the aux key moved but uncounted
+	s.PageSize = 4
+	count, err := s.RefreshAll(ctx, "", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(saved)), count)
+	for _, id := range saved {
+		assert.Greater(t, leaseTTL(s.key(id)), int64(3600))
+	}
+	assert.Greater(t, leaseTTL(other.key(nested.ID)), int64(3600))
+	assert.Equal(t, leaseOf(s.key(withAux.ID)), leaseOf(s.auxPrefix(withAux.ID)+"csrf"))
+
+	loaded, err := loadByID(s, withAux.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"csrf"}, loaded.Values[auxKey])
+	lease, _ := LeaseID(loaded)
+	assert.Equal(t, leaseOf(s.key(withAux.ID)), int64(lease))
+
+	// a scope covers its own sessions only
+	count, err = s.RefreshAll(ctx, other.keyPrefix, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Greater(t, leaseTTL(other.key(nested.ID)), int64(7200))
+	assert.LessOrEqual(t, leaseTTL(s.key(saved[0])), int64(3660))
+
+	_, err = s.RefreshAll(ctx, "", 0)
+	assert.NotNil(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.RefreshAll(cancelled, "", time.Hour)
+	assert.NotNil(t, err)
+}
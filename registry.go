@@ -0,0 +1,61 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// registryKey keys, in a request's context, which store claimed each
+// session name in gorilla's registry for that request.
+type registryKey struct{}
+
+type registryNames struct {
+	owners map[string]*EtcdStore
+
+	// kept holds the sessions of stores that found their name claimed by
+	// another store.
+	kept map[keptName]keptSession
+}
+
+type keptName struct {
+	store *EtcdStore
+	name  string
+}
+
+type keptSession struct {
+	session *sessions.Session
+	err     error
+}
+
+// registryGet returns the session called name from gorilla's registry for
+// r, loaded through store, which is s or wraps it.
+//
+// The registry holds a single session per name, whichever store asks for
+// it: two stores serving the same name on one request would get each
+// other's session. So a name is claimed by the first store asking for it,
+// and the others get a session of their own, kept in r's context for the
+// rest of the request instead. Only stores of this package are told apart.
+func (s *EtcdStore) registryGet(r *http.Request, store sessions.Store, name string) (*sessions.Session, error) {
+	names, ok := r.Context().Value(registryKey{}).(*registryNames)
+	if !ok {
+		names = &registryNames{owners: make(map[string]*EtcdStore), kept: make(map[keptName]keptSession)}
+		*r = *r.WithContext(context.WithValue(r.Context(), registryKey{}, names))
+	}
+
+	owner, claimed := names.owners[name]
+	if !claimed {
+		names.owners[name] = s
+	}
+	if !claimed || owner == s {
+		return sessions.GetRegistry(r).Get(store, name)
+	}
+
+	if kept, ok := names.kept[keptName{s, name}]; ok {
+		return kept.session, kept.err
+	}
+	session, err := store.New(r, name)
+	names.kept[keptName{s, name}] = keptSession{session, err}
+	return session, err
+}
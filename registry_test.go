@@ -0,0 +1,51 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_RegistrySharedName(t *testing.T) {
+	s := newTestStore(t)
+	web := s.WithPrefix(s.keyPrefix + "/web")
+	api := s.WithPrefix(s.keyPrefix + "/api")
+	webID := newSavedSession(t, web, map[interface{}]interface{}{"from": "web"}).ID
+	apiID := newSavedSession(t, api, map[interface{}]interface{}{"from": "api"}).ID
+	web.IDExtractor = func(*http.Request, string) (string, bool) { return webID, true }
+	api.IDExtractor = func(*http.Request, string) (string, bool) { return apiID, true }
+	web.InsecurePlainID, api.InsecurePlainID = true, true
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	fromWeb, err := web.Get(req, "_session")
+	assert.Nil(t, err)
+	assert.Equal(t, "web", fromWeb.Values["from"])
+	fromAPI, err := api.Get(req, "_session")
+	assert.Nil(t, err)
+	assert.Equal(t, "api", fromAPI.Values["from"])
+
+	// each store keeps handing out its own session
+	again, err := web.Get(req, "_session")
+	assert.Nil(t, err)
+	assert.Same(t, fromWeb, again)
+	assert.Same(t, web, fromWeb.Store())
+	again, err = api.Get(req, "_session")
+	assert.Nil(t, err)
+	assert.Same(t, fromAPI, again)
+	assert.Same(t, api, fromAPI.Store())
+
+	// and saves it, even outside the registry
+	fromAPI.Values["saved"] = true
+	assert.Nil(t, api.SaveAll(req, httptest.NewRecorder()))
+	loaded, err := loadByID(api, apiID)
+	assert.Nil(t, err)
+	assert.Equal(t, true, loaded.Values["saved"])
+
+	registered, err := sessions.GetRegistry(req).Get(web, "_session")
+	assert.Nil(t, err)
+	assert.Same(t, fromWeb, registered)
+}
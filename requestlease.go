@@ -0,0 +1,72 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// requestLeasesKey keys, in a request's context and in the contexts Save
+// derives from it, the leases granted by a store while serving the request.
+type requestLeasesKey struct {
+	store *EtcdStore
+}
+
+// requestLeases remembers the lease each session was last saved with, so
+// that saving it again within the same request doesn't grant another one.
+type requestLeases struct {
+	mu     sync.Mutex
+	leases map[*sessions.Session]pooledLease
+}
+
+// requestLeases returns the lease cache for r, stashing a new one in r's
+// context the first time, like track.
+func (s *EtcdStore) requestLeases(r *http.Request) *requestLeases {
+	leases, ok := r.Context().Value(requestLeasesKey{s}).(*requestLeases)
+	if !ok {
+		leases = &requestLeases{leases: make(map[*sessions.Session]pooledLease)}
+		*r = *r.WithContext(context.WithValue(r.Context(), requestLeasesKey{s}, leases))
+	}
+	return leases
+}
+
+// sessionLease returns the lease to save session with: the one it was
+// saved with earlier in the request if the TTL is unchanged, or a new one
+// from leaseFor.
+func (s *EtcdStore) sessionLease(ctx context.Context, session *sessions.Session, ttl int64) (clientv3.LeaseID, error) {
+	leases, ok := ctx.Value(requestLeasesKey{s}).(*requestLeases)
+	if !ok {
+		return s.leaseFor(ctx, ttl)
+	}
+
+	leases.mu.Lock()
+	defer leases.mu.Unlock()
+
+	if lease, ok := leases.leases[session]; ok && lease.ttl == ttl {
+		return lease.id, nil
+	}
+
+	id, err := s.leaseFor(ctx, ttl)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	leases.leases[session] = pooledLease{id: id, ttl: ttl, grantedAt: s.clock()}
+	return id, nil
+}
+
+// forgetLease drops the lease sessionLease remembered for session, once
+// saving it on that lease failed.
+func (s *EtcdStore) forgetLease(ctx context.Context, session *sessions.Session) {
+	leases, ok := ctx.Value(requestLeasesKey{s}).(*requestLeases)
+	if !ok {
+		return
+	}
+
+	leases.mu.Lock()
+	defer leases.mu.Unlock()
+
+	delete(leases.leases, session)
+}
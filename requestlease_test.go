@@ -0,0 +1,41 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_SaveReusesRequestLease(t *testing.T) {
+	s := newTestStore(t)
+	leases := &countingLease{Lease: s.lease}
+	s.lease = leases
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	session, err := s.Get(req, "_session")
+	assert.Nil(t, err)
+
+	// e.g. a middleware, then the handler
+	session.Values["foo"] = "bar"
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	session.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	assert.Equal(t, 1, leases.count(), "one grant per request")
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", loaded.Values["foo"])
+
+	session.Options.MaxAge = 60
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	assert.Equal(t, 2, leases.count(), "new TTL, new lease")
+
+	// another request grants its own
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	assert.Equal(t, 3, leases.count())
+}
@@ -0,0 +1,68 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Reset clears session.Values and stores the emptied session under its
+// current ID and lease, so the cookie stays valid and the expiry unchanged,
+// e.g. to drop the data tied to a privilege level without logging out. Its
+// auxiliary data is deleted and it is taken out of the user index. Only the
+// last access time kept for IdleTimeout, the creation time kept for
+// TrackCreation, and the lease ID, survive.
+func (s *EtcdStore) Reset(ctx context.Context, session *sessions.Session) error {
+	return s.sessionError("reset", session.Name(), s.reset(ctx, session))
+}
+
+func (s *EtcdStore) reset(ctx context.Context, session *sessions.Session) error {
+	userID, _ := session.Values[indexedUserKey].(string)
+	for k := range session.Values {
+		switch k {
+		case lastAccessKey, createdKey, leaseKey:
+		default:
+			delete(session.Values, k)
+		}
+	}
+
+	encoded, err := s.encode(session.Name(), session.ID, session.Values)
+	if err != nil {
+		return err
+	}
+
+	if s.Buckets > 0 {
+		return s.updateBucket(ctx, session.ID, func(entries map[string]bucketEntry) error {
+			entry, ok := entries[session.ID]
+			if !ok {
+				return fmt.Errorf("key: %s/%s is %w", s.bucketKey(session.ID), session.ID, ErrNotFound)
+			}
+			entry.Value = []byte(encoded)
+			entries[session.ID] = entry
+			return nil
+		})
+	}
+
+	key := s.key(session.ID)
+	s.uncache(key)
+	ops := []clientv3.Op{
+		clientv3.OpPut(key, encoded, clientv3.WithIgnoreLease()),
+		clientv3.OpDelete(s.auxPrefix(session.ID), clientv3.WithPrefix()),
+	}
+	if userID != "" {
+		ops = append(ops, clientv3.OpDelete(s.userIndexKey(userID, session.ID)))
+	}
+
+	resp, err := s.commit(s.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(ops...))
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_Reset(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice", "role": "admin"})
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+
+	before, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	lease := clientv3.LeaseID(before.Kvs[0].Lease)
+	ttl, err := store.Client.TimeToLive(ctx, lease)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Reset(ctx, session))
+	assert.Equal(t, map[interface{}]interface{}{leaseKey: int64(lease)}, session.Values, "only the lease ID left")
+
+	after, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), after.Count, "key kept")
+	assert.Equal(t, lease, clientv3.LeaseID(after.Kvs[0].Lease), "lease kept")
+	remaining, err := store.Client.TimeToLive(ctx, lease)
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, remaining.TTL, ttl.TTL, "TTL not extended")
+	assert.Greater(t, remaining.TTL, int64(0))
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, map[interface{}]interface{}{leaseKey: int64(lease)}, loaded.Values)
+
+	_, err = s.GetAux(ctx, session, "csrf")
+	assert.True(t, errors.Is(err, ErrNotFound), "aux data wiped")
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0, "no longer indexed")
+
+	missing := sessions.NewSession(s, "_session")
+	missing.ID = "missing"
+	assert.True(t, errors.Is(s.Reset(ctx, missing), ErrNotFound))
+}
+
+func TestEtcdStore_ResetKeepsCreation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.TrackCreation = true
+	session := newSavedSession(t, s, map[interface{}]interface{}{"role": "admin"})
+	created, ok := CreatedAt(session)
+	assert.True(t, ok)
+
+	assert.Nil(t, s.Reset(ctx, session))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	kept, ok := CreatedAt(loaded)
+	assert.True(t, ok, "creation time kept")
+	assert.True(t, created.Equal(kept))
+	assert.NotContains(t, loaded.Values, "role")
+}
@@ -0,0 +1,164 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Backoff decides how long to wait before retrying a failed etcd request.
+// attempt is 1 before the first retry, 2 before the second and so on.
+type Backoff interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// FixedBackoff always waits the same delay, and not at all when zero.
+type FixedBackoff time.Duration
+
+func (b FixedBackoff) NextDelay(int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff waits Initial before the first retry and doubles the
+// delay for each one after, up to Max if set.
+type ExponentialBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// JitteredBackoff waits a random delay between zero and what
+// ExponentialBackoff would, so that clients failing together don't retry
+// together.
+type JitteredBackoff ExponentialBackoff
+
+func (b JitteredBackoff) NextDelay(attempt int) time.Duration {
+	d := ExponentialBackoff(b).NextDelay(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// defaultBackoff is used when Retries is set and Backoff isn't.
+var defaultBackoff = JitteredBackoff{Initial: 50 * time.Millisecond, Max: time.Second}
+
+// retry runs fn, and again up to Retries times while it fails with an error
+// worth retrying, waiting as Backoff says in between. It gives up with
+// ctx's error when ctx is done during a wait.
+func (s *EtcdStore) retry(ctx context.Context, fn func() error) error {
+	backoff := s.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > s.Retries || !isRetryable(err) {
+			return err
+		}
+
+		delay := backoff.NextDelay(attempt)
+		if budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget); ok && !budget.spend(delay) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryBudgetKey keys the retryBudget of a context.
+type retryBudgetKey struct{}
+
+// retryBudget is what is left of the retries allowed by WithRetryBudget.
+type retryBudget struct {
+	mu      sync.Mutex
+	retries int
+	wait    time.Duration
+	timed   bool
+}
+
+// WithRetryBudget returns a copy of ctx allowing at most retries retries in
+// all, through every store and operation using it, waiting at most wait
+// between them unless wait is zero. Once either runs out, failures are
+// returned as they come, which bounds what a flapping cluster adds to the
+// latency of a request. Retries still caps the retries of each operation.
+//
+// For a budget per HTTP request, attach it to the request's context in a
+// middleware, ahead of the handlers loading and saving sessions:
+//
+//	r = r.WithContext(etcdstore.WithRetryBudget(r.Context(), 3, 200*time.Millisecond))
+func WithRetryBudget(ctx context.Context, retries int, wait time.Duration) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, &retryBudget{retries: retries, wait: wait, timed: wait > 0})
+}
+
+// withRetryBudgetOf returns ctx carrying the retry budget of from, if any,
+// for loads, which run under the store's Context rather than the request's.
+func withRetryBudgetOf(ctx, from context.Context) context.Context {
+	if budget, ok := from.Value(retryBudgetKey{}).(*retryBudget); ok {
+		return context.WithValue(ctx, retryBudgetKey{}, budget)
+	}
+	return ctx
+}
+
+// spend takes a retry after a delay from the budget, unless it can't
+// afford it.
+func (b *retryBudget) spend(delay time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.retries <= 0 || b.timed && delay > b.wait {
+		// exhausted for good, even if a shorter wait would fit
+		b.retries = 0
+		return false
+	}
+	b.retries--
+	b.wait -= delay
+	return true
+}
+
+// isAuthTokenExpired reports whether err is etcd rejecting the client's
+// auth token, expired or issued before an auth change, which the client
+// replaces by authenticating again.
+func isAuthTokenExpired(err error) bool {
+	return errors.Is(err, rpctypes.ErrInvalidAuthToken) || errors.Is(err, rpctypes.ErrAuthOldRevision)
+}
+
+// isRetryable reports whether err is a transient etcd failure, after which
+// repeating a load, save or delete is safe. The caller's own deadline isn't
+// one.
+func isRetryable(err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, rpctypes.ErrNoLeader),
+		errors.Is(err, rpctypes.ErrLeaderChanged),
+		errors.Is(err, rpctypes.ErrTimeout),
+		errors.Is(err, rpctypes.ErrTimeoutDueToLeaderFail),
+		errors.Is(err, rpctypes.ErrTimeoutDueToConnectionLost):
+		return true
+	}
+	return status.Code(err) == codes.Unavailable
+}
@@ -0,0 +1,157 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	delays := func(b Backoff) []time.Duration {
+		var d []time.Duration
+		for attempt := 1; attempt <= 5; attempt++ {
+			d = append(d, b.NextDelay(attempt))
+		}
+		return d
+	}
+
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{10 * ms, 10 * ms, 10 * ms, 10 * ms, 10 * ms}, delays(FixedBackoff(10*ms)))
+	assert.Equal(t, []time.Duration{10 * ms, 20 * ms, 40 * ms, 80 * ms, 100 * ms},
+		delays(ExponentialBackoff{Initial: 10 * ms, Max: 100 * ms}))
+	assert.Equal(t, 160*ms, ExponentialBackoff{Initial: 10 * ms}.NextDelay(5), "uncapped")
+
+	jittered := JitteredBackoff{Initial: 10 * ms, Max: 100 * ms}
+	for i, d := range delays(jittered) {
+		assert.GreaterOrEqual(t, int64(d), int64(0))
+		assert.LessOrEqual(t, d, delays(ExponentialBackoff(jittered))[i])
+	}
+	assert.Equal(t, time.Duration(0), JitteredBackoff{}.NextDelay(1))
+}
+
+func TestEtcdStore_Retries(t *testing.T) {
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	s.Retries = 2
+	s.Backoff = FixedBackoff(0)
+	kv := &flakyKV{KV: s.kv, err: rpctypes.ErrNoLeader, failures: 2}
+	s.kv = kv
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, 3, kv.calls)
+
+	kv.calls, kv.err = 0, status.Error(codes.Unavailable, "connection refused")
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	assert.Equal(t, 3, kv.calls)
+
+	kv.calls, kv.failures = 0, 3
+	_, err = loadByID(s, session.ID)
+	assert.Equal(t, codes.Unavailable, status.Code(err), "retries exhausted")
+	assert.Equal(t, 3, kv.calls)
+
+	kv.calls, kv.err = 0, errors.New("permission denied")
+	_, err = loadByID(s, session.ID)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, kv.calls, "not retried")
+}
+
+func TestEtcdStore_RetriesCancelled(t *testing.T) {
+	s := newTestStore(t)
+	s.Retries = 3
+	s.Backoff = FixedBackoff(time.Hour)
+	s.kv = &flakyKV{KV: s.kv, err: rpctypes.ErrNoLeader, failures: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	session := sessions.NewSession(s, "_session")
+	session.ID = "waiting"
+
+	start := time.Now()
+	err := s.load(ctx, session)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second, "wait cut short")
+}
+
+func TestEtcdStore_RetryBudget(t *testing.T) {
+	s := newTestStore(t)
+	saved := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	rsp := httptest.NewRecorder()
+	assert.Nil(t, s.Save(nil, rsp, saved))
+
+	s.Retries = 3
+	s.Backoff = FixedBackoff(0)
+	kv := &flakyKV{KV: s.kv, err: rpctypes.ErrNoLeader}
+	s.kv = kv
+
+	// a load and a save share the request's two retries
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	req.AddCookie(rsp.Result().Cookies()[0])
+	*req = *req.WithContext(WithRetryBudget(req.Context(), 2, 0))
+
+	kv.failures = 1
+	session, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", session.Values["foo"])
+	assert.Equal(t, 2, kv.calls)
+
+	kv.calls, kv.failures = 0, 1
+	assert.Nil(t, s.Save(req, httptest.NewRecorder(), session))
+	assert.Equal(t, 2, kv.calls, "the last retry")
+
+	kv.calls, kv.failures = 0, 1
+	assert.ErrorIs(t, s.Save(req, httptest.NewRecorder(), session), rpctypes.ErrNoLeader, "budget spent")
+	assert.Equal(t, 1, kv.calls)
+
+	// a time budget runs out with the first wait it can't cover
+	s.Backoff = FixedBackoff(10 * time.Millisecond)
+	ctx := WithRetryBudget(context.Background(), 10, 15*time.Millisecond)
+	kv.calls, kv.failures = 0, 2
+	assert.ErrorIs(t, s.load(ctx, session), rpctypes.ErrNoLeader)
+	assert.Equal(t, 2, kv.calls, "no budget for the second retry")
+}
+
+func TestEtcdStore_AuthTokenRetry(t *testing.T) {
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	// without Retries, once for each of load, save and delete
+	kv := &flakyKV{KV: s.kv, err: rpctypes.ErrInvalidAuthToken, failures: 1}
+	s.kv = kv
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+	assert.Equal(t, 2, kv.calls)
+
+	kv.calls = 0
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	assert.Equal(t, 2, kv.calls)
+
+	kv.calls, kv.err = 0, rpctypes.ErrAuthOldRevision
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	assert.Equal(t, 2, kv.calls)
+
+	// once only
+	kv.calls, kv.failures = 0, 2
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, rpctypes.ErrAuthOldRevision))
+	assert.Equal(t, 2, kv.calls)
+
+	s.NoAuthRetry = true
+	kv.calls, kv.failures = 0, 1
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, rpctypes.ErrAuthOldRevision))
+	assert.Equal(t, 1, kv.calls)
+}
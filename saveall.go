@@ -0,0 +1,62 @@
+package etcdstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// trackedKey keys, in a request's context, the names of the sessions a
+// store handed out through Get for that request.
+type trackedKey struct {
+	store *EtcdStore
+}
+
+type trackedNames struct {
+	names []string
+	seen  map[string]bool
+}
+
+// track records that the session called name was requested from s for r.
+// Like gorilla's registry, it stashes its state in r's context.
+func (s *EtcdStore) track(r *http.Request, name string) {
+	tracked, ok := r.Context().Value(trackedKey{s}).(*trackedNames)
+	if !ok {
+		tracked = &trackedNames{seen: make(map[string]bool)}
+		*r = *r.WithContext(context.WithValue(r.Context(), trackedKey{s}, tracked))
+	}
+
+	if !tracked.seen[name] {
+		tracked.seen[name] = true
+		tracked.names = append(tracked.names, name)
+	}
+}
+
+// SaveAll saves every session obtained from this store with Get for r, so
+// none is forgotten when a request uses several. Unlike sessions.Save, it
+// leaves sessions of other stores alone. Errors, each a *SessionError, are
+// collected into a sessions.MultiError.
+func (s *EtcdStore) SaveAll(r *http.Request, w http.ResponseWriter) error {
+	tracked, ok := r.Context().Value(trackedKey{s}).(*trackedNames)
+	if !ok {
+		return nil
+	}
+
+	var errMulti sessions.MultiError
+	for _, name := range tracked.names {
+		session, err := s.Get(r, name)
+		if session == nil {
+			errMulti = append(errMulti, err)
+			continue
+		}
+		if err := s.Save(r, w, session); err != nil {
+			errMulti = append(errMulti, err)
+		}
+	}
+
+	if errMulti != nil {
+		return errMulti
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package etcdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_SaveAll(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+
+	web, err := store.Get(req, "web")
+	assert.Nil(t, err)
+	web.Values["foo"] = "web"
+	admin, err := store.Get(req, "admin")
+	assert.Nil(t, err)
+	admin.Values["foo"] = "admin"
+	// getting a session twice doesn't save it twice
+	_, err = store.Get(req, "web")
+	assert.Nil(t, err)
+
+	rsp := httptest.NewRecorder()
+	assert.Nil(t, store.SaveAll(req, rsp))
+	assert.Len(t, rsp.Result().Cookies(), 2)
+
+	req2, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	for _, cookie := range rsp.Result().Cookies() {
+		req2.AddCookie(cookie)
+	}
+	web, err = store.Get(req2, "web")
+	assert.Nil(t, err)
+	assert.Equal(t, "web", web.Values["foo"])
+	admin, err = store.Get(req2, "admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", admin.Values["foo"])
+
+	// nothing to save for a request that got no session
+	req3, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	rsp = httptest.NewRecorder()
+	assert.Nil(t, store.SaveAll(req3, rsp))
+	assert.Empty(t, rsp.Result().Cookies())
+}
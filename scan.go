@@ -0,0 +1,106 @@
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// DefaultPageSize is how many keys a prefix scan fetches per request when
+// PageSize is zero.
+const DefaultPageSize = 100
+
+// reservedPrefix starts the IDs under which the store keeps keys of its own,
+// such as SelfTest sentinels and the user index. Generated session IDs are
+// base32 and plain IDs starting with it are rejected, so these can't
+// collide with a session.
+const reservedPrefix = "_"
+
+// scan calls fn for every session key under the store's prefix, one page
+// at a time, skipping the store's reserved keys and auxiliary data, and
+// stops at the first error fn returns or when ctx is done.
+func (s *EtcdStore) scan(ctx context.Context, fn func(kv *mvccpb.KeyValue) error, opts ...clientv3.OpOption) error {
+	return s.scanPages(ctx, s.key(""), func(kvs []*mvccpb.KeyValue) error {
+		for _, kv := range kvs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if id := s.idFromKey(kv.Key); strings.HasPrefix(id, reservedPrefix) || strings.Contains(id, s.keyDelimiter) {
+				continue
+			}
+			if err := fn(kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts...)
+}
+
+// scanPages calls fn with every page of PageSize keys under prefix, and
+// stops at the first error fn returns.
+func (s *EtcdStore) scanPages(ctx context.Context, prefix string, fn func(kvs []*mvccpb.KeyValue) error, opts ...clientv3.OpOption) error {
+	pageSize := int64(s.PageSize)
+	switch {
+	case pageSize < 0:
+		return fmt.Errorf("etcdstore: invalid PageSize %d", pageSize)
+	case pageSize == 0:
+		pageSize = DefaultPageSize
+	}
+
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	opts = append([]clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(pageSize)}, opts...)
+
+	for key := prefix; ; {
+		resp, err := s.get(ctx, key, opts...)
+		if err != nil {
+			return err
+		}
+		if err := fn(resp.Kvs); err != nil {
+			return err
+		}
+
+		if !resp.More || len(resp.Kvs) == 0 {
+			return nil
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// idFromKey returns the session ID stored under key.
+func (s *EtcdStore) idFromKey(key []byte) string {
+	return strings.TrimPrefix(string(key), s.key(""))
+}
+
+// FilterSessions returns the IDs of the sessions called name for which
+// predicate returns true. Values that can't be decoded as name are skipped.
+//
+// This is expensive: it pages through every key under the prefix and
+// decodes each value, after reading its field keys under FieldKeys. It
+// stops early when ctx is cancelled.
+func (s *EtcdStore) FilterSessions(ctx context.Context, name string, predicate func(*sessions.Session) bool) ([]string, error) {
+	var ids []string
+	err := s.scan(ctx, func(kv *mvccpb.KeyValue) error {
+		session := sessions.NewSession(s, name)
+		session.ID = s.idFromKey(kv.Key)
+		if err := s.decode(name, session.ID, kv.Value, &session.Values); err != nil {
+			return nil
+		}
+		if s.fieldKeys() {
+			if err := s.loadFields(ctx, session); isCorrupt(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if predicate(session) {
+			ids = append(ids, session.ID)
+		}
+		return nil
+	}, s.readOpts(ReadScan)...)
+	return ids, err
+}
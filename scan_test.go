@@ -0,0 +1,75 @@
+package etcdstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_FilterSessions(t *testing.T) {
+	s := newTestStore(t)
+
+	var admins []string
+	for i := 0; i < DefaultPageSize+10; i++ {
+		role := "user"
+		if i%50 == 0 {
+			role = "admin"
+		}
+		session := newSavedSession(t, s, map[interface{}]interface{}{"role": role})
+		if role == "admin" {
+			admins = append(admins, session.ID)
+		}
+	}
+
+	isAdmin := func(session *sessions.Session) bool {
+		return session.Values["role"] == "admin"
+	}
+	ids, err := s.FilterSessions(context.Background(), "_session", isAdmin)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, admins, ids)
+
+	// values saved under another name don't decode and are skipped
+	ids, err = s.FilterSessions(context.Background(), "other", func(*sessions.Session) bool { return true })
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := 0
+	_, err = s.FilterSessions(ctx, "_session", func(*sessions.Session) bool {
+		seen++
+		cancel()
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, seen)
+}
+
+func TestEtcdStore_PageSize(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	for i := 0; i < 10; i++ {
+		newSavedSession(t, s, nil)
+	}
+	kv := &pagingKV{KV: s.kv}
+	s.kv = kv
+
+	// 10 keys in pages of 4, and of 5
+	s.PageSize = 4
+	count, err := s.CountSessions(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), count)
+	assert.Equal(t, []int{4, 4, 2}, kv.pages)
+
+	kv.pages = nil
+	s.PageSize = 5
+	ids, err := s.FilterSessions(ctx, "_session", func(*sessions.Session) bool { return true })
+	assert.Nil(t, err)
+	assert.Len(t, ids, 10)
+	assert.Equal(t, []int{5, 5}, kv.pages)
+
+	s.PageSize = -1
+	_, err = s.CountSessions(ctx)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,60 @@
+// Package scsstore adapts an etcdstore.EtcdStore to the Store and CtxStore
+// interfaces of github.com/alexedwards/scs/v2, so that services using scs
+// keep their sessions in etcd next to those using gorilla/sessions.
+//
+//	store, err := etcdstore.NewEtcdStore(config, ctx, "/scs", keyPairs...)
+//	...
+//	manager := scs.New()
+//	manager.Store = scsstore.New(store)
+//
+// scs encodes the sessions itself, so they are stored as is, one key per
+// token under the store's prefix, on a lease expiring along with them.
+package scsstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/api7/etcdstore"
+)
+
+// Store is an scs store backed by an EtcdStore.
+type Store struct {
+	store *etcdstore.EtcdStore
+}
+
+// New returns an scs store keeping sessions in store. The methods taking no
+// context run under the store's Context.
+func New(store *etcdstore.EtcdStore) *Store {
+	return &Store{store: store}
+}
+
+// Find returns the data of the session with token, and whether it exists.
+func (s *Store) Find(token string) ([]byte, bool, error) {
+	return s.FindCtx(s.store.Context, token)
+}
+
+// Commit stores the data of the session with token until expiry.
+func (s *Store) Commit(token string, b []byte, expiry time.Time) error {
+	return s.CommitCtx(s.store.Context, token, b, expiry)
+}
+
+// Delete deletes the session with token.
+func (s *Store) Delete(token string) error {
+	return s.DeleteCtx(s.store.Context, token)
+}
+
+// FindCtx is Find under ctx.
+func (s *Store) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	return s.store.FindRaw(ctx, token)
+}
+
+// CommitCtx is Commit under ctx.
+func (s *Store) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	return s.store.CommitRaw(ctx, token, b, expiry)
+}
+
+// DeleteCtx is Delete under ctx.
+func (s *Store) DeleteCtx(ctx context.Context, token string) error {
+	return s.store.DeleteRaw(ctx, token)
+}
@@ -0,0 +1,75 @@
+package scsstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/api7/etcdstore"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// store and ctxStore are the scs interfaces, which this package satisfies
+// without depending on scs.
+type store interface {
+	Find(token string) ([]byte, bool, error)
+	Commit(token string, b []byte, expiry time.Time) error
+	Delete(token string) error
+}
+
+type ctxStore interface {
+	store
+	FindCtx(ctx context.Context, token string) ([]byte, bool, error)
+	CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error
+	DeleteCtx(ctx context.Context, token string) error
+}
+
+var _ ctxStore = (*Store)(nil)
+
+// newTestStore returns a store under a prefix of its own, and the prefix.
+func newTestStore(t *testing.T) (*etcdstore.EtcdStore, string) {
+	prefix := fmt.Sprintf("/test/%s/%d", t.Name(), time.Now().UnixNano())
+	s, err := etcdstore.NewEtcdStore(clientv3.Config{Endpoints: []string{"http://127.0.0.1:2379"}}, context.Background(), prefix, []byte("secret"))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		s.Client.Delete(context.Background(), prefix+"/", clientv3.WithPrefix())
+		s.Close()
+	})
+	return s, prefix
+}
+
+func TestStore(t *testing.T) {
+	etcd, prefix := newTestStore(t)
+	s := New(etcd)
+
+	_, found, err := s.Find("token")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	assert.Nil(t, s.Commit("token", []byte("data"), time.Now().Add(time.Hour)))
+	b, found, err := s.Find("token")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("data"), b)
+
+	// stored as is under the prefix, on a lease of the remaining time
+	resp, err := etcd.Client.Get(context.Background(), prefix+"/token")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+	assert.Equal(t, []byte("data"), resp.Kvs[0].Value)
+	lease, err := etcd.Client.TimeToLive(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3600), lease.GrantedTTL)
+
+	assert.Nil(t, s.Delete("token"))
+	_, found, err = s.Find("token")
+	assert.Nil(t, err)
+	assert.False(t, found)
+	assert.Nil(t, s.Delete("token"), "deleting twice")
+
+	// tokens can't reach outside their key
+	assert.NotNil(t, s.Commit("a/b", []byte("data"), time.Now().Add(time.Hour)))
+	assert.NotNil(t, s.Commit("", []byte("data"), time.Now().Add(time.Hour)))
+}
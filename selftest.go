@@ -0,0 +1,86 @@
+package etcdstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// selfTestSegment starts the keys SelfTest writes.
+	selfTestSegment = reservedPrefix + "healthcheck"
+
+	// selfTestTTL is the lease TTL of the sentinel key, which expires with
+	// it should SelfTest be unable to clean up.
+	selfTestTTL = 10
+
+	// selfTestCleanupTimeout bounds the cleanup after a failed SelfTest.
+	selfTestCleanupTimeout = 5 * time.Second
+)
+
+// ErrSelfTest is returned by SelfTest when etcd accepted the sentinel key
+// but handed back something else.
+var ErrSelfTest = errors.New("etcdstore: self test failed")
+
+// SelfTest writes a sentinel key under the store's prefix, reads it back and
+// deletes it, for readiness checks that must catch what a ping doesn't:
+// exceeded quotas, missing permissions on the prefix and the like.
+//
+// The key is attached to a short lease that is revoked when SelfTest
+// returns, so it is removed even if a step failed.
+func (s *EtcdStore) SelfTest(ctx context.Context) (err error) {
+	done, err := s.inflight.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	random := generateRandomKey(32)
+	if len(random) < 32 {
+		return ErrEntropyFailure
+	}
+	key := s.selfTestPrefix() + hex.EncodeToString(random[:16])
+	value := hex.EncodeToString(random[16:])
+
+	lease, err := s.grant(ctx, selfTestTTL)
+	if err != nil {
+		return fmt.Errorf("etcdstore: self test grant: %w", err)
+	}
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(s.Context, selfTestCleanupTimeout)
+		defer cancel()
+		if _, revokeErr := s.revoke(cleanupCtx, lease.ID); revokeErr != nil && err == nil {
+			err = fmt.Errorf("etcdstore: self test revoke: %w", revokeErr)
+		}
+	}()
+
+	if _, err := s.put(ctx, key, value, clientv3.WithLease(lease.ID)); err != nil {
+		return fmt.Errorf("etcdstore: self test put: %w", err)
+	}
+
+	resp, err := s.get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("etcdstore: self test get: %w", err)
+	}
+	if resp.Count == 0 || string(resp.Kvs[0].Value) != value {
+		return fmt.Errorf("%w: sentinel read back differs from what was written", ErrSelfTest)
+	}
+
+	del, err := s.del(ctx, key)
+	if err != nil {
+		return fmt.Errorf("etcdstore: self test delete: %w", err)
+	}
+	if del.Deleted != 1 {
+		return fmt.Errorf("%w: sentinel vanished before delete", ErrSelfTest)
+	}
+	return nil
+}
+
+// selfTestPrefix returns the prefix of the keys SelfTest writes.
+func (s *EtcdStore) selfTestPrefix() string {
+	return s.key(selfTestSegment + "/")
+}
@@ -0,0 +1,39 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_SelfTest(t *testing.T) {
+	s := newTestStore(t)
+	newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	assert.Nil(t, s.SelfTest(context.Background()))
+	assertNoSentinel(t, s)
+
+	ids, err := s.FilterSessions(context.Background(), "_session", func(*sessions.Session) bool { return true })
+	assert.Nil(t, err)
+	assert.Len(t, ids, 1)
+}
+
+func TestEtcdStore_SelfTestCleansUp(t *testing.T) {
+	s := newTestStore(t)
+	s.kv = blindKV{s.kv}
+
+	err := s.SelfTest(context.Background())
+	assert.True(t, errors.Is(err, rpctypes.ErrPermissionDenied), "get error returned")
+	assertNoSentinel(t, s)
+}
+
+func assertNoSentinel(t *testing.T, s *EtcdStore) {
+	resp, err := store.Client.Get(context.Background(), s.selfTestPrefix(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "sentinel removed")
+}
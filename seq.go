@@ -0,0 +1,46 @@
+//go:build go1.23
+
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// errSeqStopped ends the scan of SessionsSeq when the loop breaks.
+var errSeqStopped = errors.New("etcdstore: sequence stopped")
+
+// SessionsSeq returns the IDs of the sessions stored under the prefix as an
+// iterator, fetching them a page at a time as the loop goes on:
+//
+//	for id, err := range store.SessionsSeq(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Like CountSessions, it leaves out the store's reserved keys, auxiliary
+// data and Buckets, and fetches no values. A failure to read a page, or ctx
+// being done, is yielded last with an empty ID. Breaking out of the loop
+// stops the scan, no further page is fetched.
+func (s *EtcdStore) SessionsSeq(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		err := s.scan(ctx, func(kv *mvccpb.KeyValue) error {
+			if !yield(s.idFromKey(kv.Key), nil) {
+				return errSeqStopped
+			}
+			return nil
+		}, s.readOpts(ReadScan, clientv3.WithKeysOnly())...)
+		if err != nil && err != errSeqStopped {
+			yield("", err)
+		}
+	}
+}
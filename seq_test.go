@@ -0,0 +1,54 @@
+//go:build go1.23
+
+package etcdstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_SessionsSeq(t *testing.T) {
+	s := newTestStore(t)
+	var saved []string
+	for i := 0; i < 10; i++ {
+		saved = append(saved, newSavedSession(t, s, nil).ID)
+	}
+	kv := &pagingKV{KV: s.kv}
+	s.kv = kv
+	s.PageSize = 4
+
+	var ids []string
+	for id, err := range s.SessionsSeq(context.Background()) {
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	assert.ElementsMatch(t, saved, ids)
+	assert.Equal(t, []int{4, 4, 2}, kv.pages)
+
+	// breaking within the second page fetches no third
+	kv.pages = nil
+	seen := 0
+	for _, err := range s.SessionsSeq(context.Background()) {
+		assert.Nil(t, err)
+		if seen++; seen == 5 {
+			break
+		}
+	}
+	assert.Equal(t, 5, seen)
+	assert.Equal(t, []int{4, 4}, kv.pages)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var errs []error
+	for id, err := range s.SessionsSeq(ctx) {
+		if err != nil {
+			assert.Empty(t, id)
+			errs = append(errs, err)
+			continue
+		}
+		cancel()
+	}
+	assert.Equal(t, []error{context.Canceled}, errs)
+}
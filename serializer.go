@@ -0,0 +1,360 @@
+package etcdstore
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func init() {
+	gob.Register(time.Time{})
+
+	for _, value := range []interface{}{
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), []byte(nil), []string(nil), time.Time{}, time.Duration(0),
+	} {
+		RegisterJSON(value)
+	}
+}
+
+// jsonTypes maps the types registered with RegisterJSON to their names and
+// back.
+var jsonTypes = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterJSON records the type of value, like gob.Register, so that
+// JSONSerializer restores session values of that type as such, instead of
+// as the generic types of encoding/json. The type must round-trip through
+// encoding/json. The integer types, float32, []byte, []string, time.Time
+// and time.Duration are registered already.
+func RegisterJSON(value interface{}) {
+	RegisterJSONName(reflect.TypeOf(value).String(), value)
+}
+
+// RegisterJSONName is RegisterJSON under name, which is stored along with
+// each value of the type. Like gob.RegisterName, it panics if the type or
+// name is already registered otherwise.
+func RegisterJSONName(name string, value interface{}) {
+	if name == "" {
+		panic("etcdstore: RegisterJSONName with empty name")
+	}
+	rt := reflect.TypeOf(value)
+
+	jsonTypes.Lock()
+	defer jsonTypes.Unlock()
+
+	if other, ok := jsonTypes.byName[name]; ok && other != rt {
+		panic(fmt.Sprintf("etcdstore: registering duplicate JSON name %q for %s", name, rt))
+	}
+	if other, ok := jsonTypes.byType[rt]; ok && other != name {
+		panic(fmt.Sprintf("etcdstore: registering duplicate JSON type %s as %q", rt, name))
+	}
+	jsonTypes.byName[name] = rt
+	jsonTypes.byType[rt] = name
+}
+
+// JSONSerializer is a securecookie.Serializer writing session values as a
+// JSON object. Each value is stored along with the name of its type if it
+// was registered with RegisterJSON, and restored as that type. Strings,
+// booleans, float64 values and nil, as well as slices and maps of them,
+// need no registration, other types are rejected.
+//
+// It is lossier than gob, which keeps every key and type: keys must be of
+// a string kind, and come back as plain strings, which other keys fail or,
+// under SkipNonStringKeys, are dropped; a nested map[interface{}]interface{}
+// is written as an object, under the same rules for its keys, and restored
+// as a map[string]interface{}; and values in nested maps and slices are
+// restored as the generic types of encoding/json.
+type JSONSerializer struct {
+	// Hooks marshal and unmarshal the values of specific keys their own
+	// way, ahead of the registered types, e.g. for a type that doesn't
+	// round-trip through encoding/json.
+	Hooks map[string]JSONHook
+
+	// SkipNonStringKeys drops the values under keys that aren't strings,
+	// top-level or nested, instead of failing to serialize them.
+	SkipNonStringKeys bool
+
+	// FieldName, if set, names the JSON field the value under key is
+	// written to, e.g. for stable field names in JSON read by other
+	// programs, and KeyName names the key a field is read back into. Values
+	// come back under their field names when KeyName is nil. They apply to
+	// top-level keys alone, not to the store's keys under
+	// ReservedValuesPrefix, and Hooks are looked up by key.
+	FieldName func(key string) string
+	KeyName   func(field string) string
+}
+
+// JSONHook converts the value of a session values key to JSON and back.
+type JSONHook struct {
+	Marshal   func(value interface{}) ([]byte, error)
+	Unmarshal func(data []byte) (interface{}, error)
+}
+
+// jsonValue is a session value as JSONSerializer writes it.
+type jsonValue struct {
+	Type  string          `json:"t,omitempty"`
+	Value json.RawMessage `json:"v"`
+}
+
+// Serialize encodes src, which is session values or, for session IDs and
+// compressed payloads, a plain value.
+func (e JSONSerializer) Serialize(src interface{}) ([]byte, error) {
+	values, ok := src.(map[interface{}]interface{})
+	if !ok {
+		return json.Marshal(src)
+	}
+
+	object := make(map[string]jsonValue, len(values))
+	for k, v := range values {
+		key, ok := e.key(k)
+		if !ok {
+			if e.SkipNonStringKeys {
+				continue
+			}
+			return nil, fmt.Errorf("etcdstore: json: key %v is %T, not a string", k, k)
+		}
+
+		var value jsonValue
+		var err error
+		if hook, ok := e.Hooks[key]; ok {
+			value.Value, err = hook.Marshal(v)
+		} else {
+			v, err = e.plain(v)
+			if err == nil {
+				value.Type, err = jsonTypeName(v)
+			}
+			if err == nil {
+				value.Value, err = json.Marshal(v)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("etcdstore: json: key %q: %w", key, err)
+		}
+		if e.FieldName != nil && !strings.HasPrefix(key, ReservedValuesPrefix) {
+			key = e.FieldName(key)
+		}
+		object[key] = value
+	}
+	return json.Marshal(object)
+}
+
+// key returns k as a string, if it is of a string kind.
+func (e JSONSerializer) key(k interface{}) (string, bool) {
+	if key, ok := k.(string); ok {
+		return key, true
+	}
+	if rv := reflect.ValueOf(k); rv.Kind() == reflect.String {
+		return rv.String(), true
+	}
+	return "", false
+}
+
+// plain turns the map[interface{}]interface{} found in v, as gob allows,
+// into objects encoding/json can write.
+func (e JSONSerializer) plain(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			key, ok := e.key(k)
+			if !ok {
+				if e.SkipNonStringKeys {
+					continue
+				}
+				return nil, fmt.Errorf("nested key %v is %T, not a string", k, k)
+			}
+			elem, err := e.plain(elem)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = elem
+		}
+		return object, nil
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			elem, err := e.plain(elem)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = elem
+		}
+		return object, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			elem, err := e.plain(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+// Deserialize decodes src into dst, which is session values or a plain
+// value, as written by Serialize.
+func (e JSONSerializer) Deserialize(src []byte, dst interface{}) error {
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return json.Unmarshal(src, dst)
+	}
+
+	var object map[string]jsonValue
+	if err := json.Unmarshal(src, &object); err != nil {
+		return err
+	}
+	if *values == nil {
+		*values = make(map[interface{}]interface{}, len(object))
+	}
+	for key, value := range object {
+		if e.KeyName != nil && !strings.HasPrefix(key, ReservedValuesPrefix) {
+			key = e.KeyName(key)
+		}
+		v, err := e.unmarshal(key, value)
+		if err != nil {
+			return fmt.Errorf("etcdstore: json: key %q: %w", key, err)
+		}
+		(*values)[key] = v
+	}
+	return nil
+}
+
+func (e JSONSerializer) unmarshal(key string, value jsonValue) (interface{}, error) {
+	if hook, ok := e.Hooks[key]; ok {
+		return hook.Unmarshal(value.Value)
+	}
+
+	if value.Type == "" {
+		var v interface{}
+		err := json.Unmarshal(value.Value, &v)
+		return v, err
+	}
+
+	jsonTypes.RLock()
+	rt, ok := jsonTypes.byName[value.Type]
+	jsonTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %q not registered", value.Type)
+	}
+	v := reflect.New(rt)
+	if err := json.Unmarshal(value.Value, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// jsonTypeName returns the name v is stored along with, "" for the values
+// encoding/json restores as they are.
+func jsonTypeName(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil, string, bool, float64:
+		return "", nil
+	case []interface{}:
+		for _, elem := range v {
+			if name, err := jsonTypeName(elem); err != nil || name != "" {
+				return "", fmt.Errorf("%T in a slice must be a plain JSON value", elem)
+			}
+		}
+		return "", nil
+	case map[string]interface{}:
+		for _, elem := range v {
+			if name, err := jsonTypeName(elem); err != nil || name != "" {
+				return "", fmt.Errorf("%T in a map must be a plain JSON value", elem)
+			}
+		}
+		return "", nil
+	}
+
+	jsonTypes.RLock()
+	defer jsonTypes.RUnlock()
+
+	name, ok := jsonTypes.byType[reflect.TypeOf(v)]
+	if !ok {
+		return "", fmt.Errorf("type %T not registered", v)
+	}
+	return name, nil
+}
+
+// versionHeader starts the data FallbackSerializer writes for a versioned
+// Primary, followed by its version. Neither gob nor JSON output starts
+// with it.
+const versionHeader = 0x00
+
+// VersionedSerializer is a serializer of FallbackSerializer, tagged with the
+// version that names it in headers, or 0 for none.
+type VersionedSerializer struct {
+	Version    byte
+	Serializer securecookie.Serializer
+}
+
+// FallbackSerializer is a securecookie.Serializer for migrating stored
+// values from one serializer to another without downtime, e.g. from gob to
+// JSONSerializer: it writes with Primary alone, and reads with Primary and
+// then each of Fallbacks in turn, until one succeeds.
+//
+// When Primary has a Version, what it writes starts with a two-byte header,
+// naming it so that reads pick it directly, without trying the others.
+// Data with a header naming none of them fails to decode. The header makes
+// values unreadable to a plain Primary, so turn it on once every reader
+// runs with a FallbackSerializer.
+type FallbackSerializer struct {
+	Primary   VersionedSerializer
+	Fallbacks []VersionedSerializer
+}
+
+// Serialize encodes src with Primary.
+func (f FallbackSerializer) Serialize(src interface{}) ([]byte, error) {
+	data, err := f.Primary.Serializer.Serialize(src)
+	if err != nil || f.Primary.Version == 0 {
+		return data, err
+	}
+	return append([]byte{versionHeader, f.Primary.Version}, data...), nil
+}
+
+// Deserialize decodes src into dst with the serializer its header names, or
+// with the first that succeeds. It returns the error of the primary if all
+// fail.
+func (f FallbackSerializer) Deserialize(src []byte, dst interface{}) error {
+	if len(src) >= 2 && src[0] == versionHeader {
+		for _, serializer := range append([]VersionedSerializer{f.Primary}, f.Fallbacks...) {
+			if serializer.Version != 0 && serializer.Version == src[1] {
+				return serializer.Serializer.Deserialize(src[2:], dst)
+			}
+		}
+		return fmt.Errorf("etcdstore: no serializer of version %d", src[1])
+	}
+
+	err := f.Primary.Serializer.Deserialize(src, dst)
+	if err == nil {
+		return nil
+	}
+	for _, fallback := range f.Fallbacks {
+		// a failed attempt may have left values behind
+		if values, ok := dst.(*map[interface{}]interface{}); ok {
+			for k := range *values {
+				delete(*values, k)
+			}
+		}
+		if fallback.Serializer.Deserialize(src, dst) == nil {
+			return nil
+		}
+	}
+	return err
+}
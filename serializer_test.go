@@ -0,0 +1,204 @@
+package etcdstore
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProfile struct {
+	Name  string
+	Since time.Time
+}
+
+func init() {
+	gob.Register(testProfile{})
+	RegisterJSON(testProfile{})
+}
+
+// setSerializer makes the codecs of s use serializer, as StoreConfig does.
+func setSerializer(s *EtcdStore, serializer securecookie.Serializer) {
+	for _, codec := range s.Codecs {
+		codec.(*securecookie.SecureCookie).SetSerializer(serializer)
+	}
+}
+
+func TestEtcdStore_Serializers(t *testing.T) {
+	since := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	for name, serializer := range map[string]securecookie.Serializer{
+		"gob":  securecookie.GobEncoder{},
+		"json": JSONSerializer{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t)
+			setSerializer(s, serializer)
+			s.IdleTimeout = time.Hour
+			s.TrackCreation = true
+
+			session := newSavedSession(t, s, map[interface{}]interface{}{
+				"since":   since,
+				"profile": testProfile{Name: "alice", Since: since},
+				"count":   3,
+				"tags":    []string{"a", "b"},
+			})
+			loaded, err := loadByID(s, session.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, since, loaded.Values["since"])
+			assert.Equal(t, testProfile{Name: "alice", Since: since}, loaded.Values["profile"])
+			assert.Equal(t, 3, loaded.Values["count"])
+			assert.Equal(t, []string{"a", "b"}, loaded.Values["tags"])
+
+			// the store's own values keep their types
+			_, ok := CreatedAt(loaded)
+			assert.True(t, ok)
+			_, ok = LeaseID(loaded)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestJSONSerializer(t *testing.T) {
+	s := newTestStore(t)
+	setSerializer(s, JSONSerializer{Hooks: map[string]JSONHook{
+		"csv": {
+			Marshal: func(value interface{}) ([]byte, error) {
+				return []byte(`"` + strings.Join(value.([]string), ",") + `"`), nil
+			},
+			Unmarshal: func(data []byte) (interface{}, error) {
+				return strings.Split(strings.Trim(string(data), `"`), ","), nil
+			},
+		},
+	}})
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{
+		"csv":   []string{"x", "y"},
+		"plain": map[string]interface{}{"n": 1.5, "list": []interface{}{"a", true}},
+	})
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x", "y"}, loaded.Values["csv"])
+	assert.Equal(t, map[string]interface{}{"n": 1.5, "list": []interface{}{"a", true}}, loaded.Values["plain"])
+
+	// unregistered types and keys other than strings are rejected
+	type unregistered struct{}
+	session.Values["bad"] = unregistered{}
+	err = s.Save(nil, httptest.NewRecorder(), session)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not registered")
+	delete(session.Values, "bad")
+	session.Values[1] = "one"
+	assert.NotNil(t, s.Save(nil, httptest.NewRecorder(), session))
+
+	assert.Panics(t, func() { RegisterJSONName("time.Time", testProfile{}) })
+}
+
+func TestJSONSerializer_Keys(t *testing.T) {
+	type key string
+	values := map[interface{}]interface{}{
+		"user":        "alice",
+		key("typed"):  1.5,
+		lastAccessKey: int64(1),
+		"nested": map[interface{}]interface{}{
+			"list": []interface{}{map[interface{}]interface{}{"deep": true}},
+			2:      "two",
+		},
+		3: "three",
+	}
+
+	// keys other than strings fail, nested ones too
+	_, err := JSONSerializer{}.Serialize(values)
+	assert.NotNil(t, err)
+	delete(values, 3)
+	_, err = JSONSerializer{}.Serialize(values)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "nested key 2")
+	values[3] = "three"
+
+	// or are dropped, string kinds coming back as plain strings
+	e := JSONSerializer{
+		SkipNonStringKeys: true,
+		FieldName:         strings.ToUpper,
+		KeyName:           strings.ToLower,
+	}
+	data, err := e.Serialize(values)
+	assert.Nil(t, err)
+	var object map[string]json.RawMessage
+	assert.Nil(t, json.Unmarshal(data, &object))
+	assert.ElementsMatch(t, []string{"USER", "TYPED", "NESTED", lastAccessKey}, keysOf(object))
+
+	decoded := make(map[interface{}]interface{})
+	assert.Nil(t, e.Deserialize(data, &decoded))
+	assert.Equal(t, map[interface{}]interface{}{
+		"user":        "alice",
+		"typed":       1.5,
+		lastAccessKey: int64(1),
+		"nested": map[string]interface{}{
+			"list": []interface{}{map[string]interface{}{"deep": true}},
+		},
+	}, decoded)
+
+	// without KeyName, values come back under their field names
+	e.KeyName = nil
+	decoded = make(map[interface{}]interface{})
+	assert.Nil(t, e.Deserialize(data, &decoded))
+	assert.Equal(t, "alice", decoded["USER"])
+	assert.Equal(t, int64(1), decoded[lastAccessKey])
+}
+
+func keysOf(object map[string]json.RawMessage) []string {
+	var keys []string
+	for k := range object {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestFallbackSerializer(t *testing.T) {
+	s := newTestStore(t)
+	old := newSavedSession(t, s, map[interface{}]interface{}{"count": 3})
+
+	setSerializer(s, FallbackSerializer{
+		Primary:   VersionedSerializer{Serializer: JSONSerializer{}},
+		Fallbacks: []VersionedSerializer{{Serializer: securecookie.GobEncoder{}}},
+	})
+	loaded, err := loadByID(s, old.ID)
+	assert.Nil(t, err, "gob value read by the fallback")
+	assert.Equal(t, 3, loaded.Values["count"])
+	loaded.Options.MaxAge = 60
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+
+	setSerializer(s, JSONSerializer{})
+	loaded, err = loadByID(s, old.ID)
+	assert.Nil(t, err, "rewritten with the primary")
+	assert.Equal(t, 3, loaded.Values["count"])
+
+	// versioned
+	versioned := FallbackSerializer{
+		Primary:   VersionedSerializer{Version: 2, Serializer: JSONSerializer{}},
+		Fallbacks: []VersionedSerializer{{Version: 1, Serializer: securecookie.GobEncoder{}}},
+	}
+	data, err := versioned.Serialize(map[interface{}]interface{}{"user": "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{versionHeader, 2}, data[:2])
+	values := make(map[interface{}]interface{})
+	assert.Nil(t, versioned.Deserialize(data, &values))
+	assert.Equal(t, "alice", values["user"])
+	gobData, err := securecookie.GobEncoder{}.Serialize(map[interface{}]interface{}{"user": "bob"})
+	assert.Nil(t, err)
+	values = make(map[interface{}]interface{})
+	assert.Nil(t, versioned.Deserialize(append([]byte{versionHeader, 1}, gobData...), &values))
+	assert.Equal(t, "bob", values["user"])
+	assert.NotNil(t, versioned.Deserialize([]byte{versionHeader, 9, '{', '}'}, &values), "unknown version")
+
+	setSerializer(s, versioned)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "carol"})
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "carol", loaded.Values["user"])
+}
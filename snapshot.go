@@ -0,0 +1,118 @@
+package etcdstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// StoreSnapshot is the effective configuration of a store at the time Config
+// was called, with defaults filled in, e.g. for a debug endpoint. It holds
+// copies, so changing it doesn't affect the store. Key material is left
+// out, only the number of codecs is reported.
+type StoreSnapshot struct {
+	Prefix       string
+	KeyDelimiter string
+
+	// Endpoints are those of the etcd client, nil for a store without one,
+	// such as a gateway or in-memory store.
+	Endpoints []string
+
+	Options sessions.Options
+	Codecs  int
+
+	// Serializer names the type of the serializer of session values, as
+	// set by StoreConfig.Serializer or RedistoreFormat; one set on the codecs
+	// directly isn't seen.
+	Serializer string
+
+	Compression       Compression
+	CompressThreshold int
+	CompressKeys      []string
+
+	// ReadConsistency holds the consistency of every read path.
+	ReadConsistency map[ReadOp]Consistency
+
+	IdleTimeout     time.Duration
+	SkewTolerance   time.Duration
+	TrackCreation   bool
+	SoftDeleteGrace time.Duration
+
+	CacheSize             int
+	CacheTTL              time.Duration
+	CacheWrites           bool
+	StaleWhileUnavailable bool
+
+	Retries   int
+	PageSize  int
+	Buckets   int
+	FieldKeys bool
+
+	UserIDKey          string
+	MaxSessionsPerUser int
+	MaxSessions        int
+
+	Checksum        bool
+	EmbedID         bool
+	InsecurePlainID bool
+}
+
+// Config returns a snapshot of the store's current configuration.
+func (s *EtcdStore) Config() StoreSnapshot {
+	snapshot := StoreSnapshot{
+		Prefix:                s.keyPrefix,
+		KeyDelimiter:          s.keyDelimiter,
+		Compression:           s.Compression,
+		CompressThreshold:     s.CompressThreshold,
+		CompressKeys:          append([]string(nil), s.CompressKeys...),
+		ReadConsistency:       make(map[ReadOp]Consistency),
+		IdleTimeout:           s.IdleTimeout,
+		SkewTolerance:         s.SkewTolerance,
+		TrackCreation:         s.TrackCreation,
+		SoftDeleteGrace:       s.SoftDeleteGrace,
+		CacheSize:             s.CacheSize,
+		CacheTTL:              s.CacheTTL,
+		CacheWrites:           s.CacheWrites,
+		StaleWhileUnavailable: s.StaleWhileUnavailable,
+		Retries:               s.Retries,
+		PageSize:              s.PageSize,
+		Buckets:               s.Buckets,
+		FieldKeys:             s.FieldKeys,
+		UserIDKey:             s.UserIDKey,
+		MaxSessionsPerUser:    s.MaxSessionsPerUser,
+		MaxSessions:           s.MaxSessions,
+		Checksum:              s.Checksum,
+		EmbedID:               s.EmbedID,
+		InsecurePlainID:       s.InsecurePlainID,
+	}
+	if s.Client != nil {
+		snapshot.Endpoints = s.Client.Endpoints()
+	}
+	for _, op := range []ReadOp{ReadLoad, ReadScan, ReadUserSessions, ReadAux} {
+		snapshot.ReadConsistency[op] = s.ReadConsistency[op]
+	}
+	if snapshot.CacheTTL <= 0 {
+		snapshot.CacheTTL = DefaultCacheTTL
+	}
+	if snapshot.PageSize == 0 {
+		snapshot.PageSize = DefaultPageSize
+	}
+
+	var serializer interface{} = securecookie.GobEncoder{}
+	switch {
+	case s.RedistoreFormat != nil:
+		serializer = s.RedistoreFormat
+	case s.serializer != nil:
+		serializer = s.serializer
+	}
+	snapshot.Serializer = fmt.Sprintf("%T", serializer)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot.Options = *s.Options
+	snapshot.Codecs = len(s.Codecs)
+	return snapshot
+}
@@ -0,0 +1,54 @@
+package etcdstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_Config(t *testing.T) {
+	s, err := NewEtcdStoreFromConfig(StoreConfig{
+		Endpoints:    []string{_defaultEtcd},
+		Prefix:       "/test/snapshot",
+		KeyDelimiter: ":",
+		KeyPairs:     [][]byte{[]byte("secret"), nil, []byte("old secret")},
+		Serializer:   JSONSerializer{},
+	})
+	assert.Nil(t, err)
+	defer s.Close()
+	s.MaxAge(60)
+	s.Compression = Gzip
+	s.CompressThreshold = 1024
+	s.ReadConsistency = map[ReadOp]Consistency{ReadScan: Serializable}
+
+	snapshot := s.Config()
+	assert.Equal(t, "/test/snapshot", snapshot.Prefix)
+	assert.Equal(t, ":", snapshot.KeyDelimiter)
+	assert.Equal(t, []string{_defaultEtcd}, snapshot.Endpoints)
+	assert.Equal(t, 60, snapshot.Options.MaxAge)
+	assert.Equal(t, 2, snapshot.Codecs)
+	assert.Equal(t, "etcdstore.JSONSerializer", snapshot.Serializer)
+	assert.Equal(t, Gzip, snapshot.Compression)
+	assert.Equal(t, 1024, snapshot.CompressThreshold)
+	assert.Equal(t, map[ReadOp]Consistency{
+		ReadLoad:         Linearizable,
+		ReadScan:         Serializable,
+		ReadUserSessions: Linearizable,
+		ReadAux:          Linearizable,
+	}, snapshot.ReadConsistency)
+	assert.Equal(t, DefaultCacheTTL, snapshot.CacheTTL, "defaults filled in")
+	assert.Equal(t, DefaultPageSize, snapshot.PageSize)
+
+	// a copy
+	snapshot.Options.MaxAge = 1
+	snapshot.ReadConsistency[ReadLoad] = Serializable
+	assert.Equal(t, 60, s.Options.MaxAge)
+	assert.Equal(t, Linearizable, s.ReadConsistency[ReadLoad])
+
+	s.RedistoreFormat = RedistoreGobSerializer{}
+	s.IdleTimeout = time.Minute
+	snapshot = s.Config()
+	assert.Equal(t, "etcdstore.RedistoreGobSerializer", snapshot.Serializer)
+	assert.Equal(t, time.Minute, snapshot.IdleTimeout)
+}
@@ -0,0 +1,190 @@
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// tombstoneSegment starts the keys soft-deleted sessions wait under during
+// SoftDeleteGrace, named {prefix}/_deleted/{session ID} with the store's key
+// delimiter in place of the slashes.
+const tombstoneSegment = reservedPrefix + "deleted"
+
+// tombstone is what a soft-deleted session leaves behind, enough for
+// Undelete to put it back without decoding it.
+type tombstone struct {
+	Value []byte
+
+	// TTL is how many seconds the session had left to live.
+	TTL int64
+
+	// UserID and IndexValue are the user index entry of the session, if
+	// it was indexed.
+	UserID     string
+	IndexValue []byte
+
+	// Fields holds what the field keys of the session held under
+	// FieldKeys, by values key.
+	Fields map[string][]byte
+}
+
+// tombstoneKey returns the key session id is kept under once soft-deleted.
+func (s *EtcdStore) tombstoneKey(id string) string {
+	return s.key(tombstoneSegment + s.keyDelimiter + id)
+}
+
+// softDelete moves session to its tombstone, leased for SoftDeleteGrace,
+// in place of deleting it, along with the values of its field keys. Its
+// auxiliary data and user index entry go right away.
+func (s *EtcdStore) softDelete(ctx context.Context, session *sessions.Session) error {
+	key := s.key(session.ID)
+	s.uncache(key)
+	userID, _ := session.Values[indexedUserKey].(string)
+	grace := int64((s.SoftDeleteGrace + time.Second - 1) / time.Second)
+
+	return s.retry(ctx, func() error {
+		for {
+			resp, err := s.get(ctx, key)
+			if err != nil {
+				return err
+			}
+			if resp.Count == 0 {
+				return fmt.Errorf("key: %s is %w", key, ErrNotFound)
+			}
+			kv := resp.Kvs[0]
+
+			stone := tombstone{Value: kv.Value, TTL: 1}
+			if kv.Lease != 0 {
+				ttl, err := s.timeToLive(ctx, clientv3.LeaseID(kv.Lease))
+				if err != nil {
+					return err
+				}
+				if ttl.TTL > stone.TTL {
+					stone.TTL = ttl.TTL
+				}
+			}
+			if s.fieldKeys() {
+				prefix := s.fieldPrefix(session.ID)
+				fields, err := s.get(ctx, prefix, clientv3.WithPrefix())
+				if err != nil {
+					return err
+				}
+				for _, kv := range fields.Kvs {
+					if stone.Fields == nil {
+						stone.Fields = make(map[string][]byte, len(fields.Kvs))
+					}
+					stone.Fields[strings.TrimPrefix(string(kv.Key), prefix)] = kv.Value
+				}
+			}
+			ops := []clientv3.Op{clientv3.OpDelete(key), clientv3.OpDelete(s.auxPrefix(session.ID), clientv3.WithPrefix())}
+			if userID != "" {
+				index, err := s.get(ctx, s.userIndexKey(userID, session.ID))
+				if err != nil {
+					return err
+				}
+				if index.Count > 0 {
+					stone.UserID, stone.IndexValue = userID, index.Kvs[0].Value
+				}
+				ops = append(ops, clientv3.OpDelete(s.userIndexKey(userID, session.ID)))
+			}
+
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(stone); err != nil {
+				return err
+			}
+			lease, err := s.grant(ctx, grace)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, clientv3.OpPut(s.tombstoneKey(session.ID), buf.String(), clientv3.WithLease(lease.ID)))
+
+			txn, err := s.commit(s.kv.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+				Then(ops...))
+			if err != nil {
+				return err
+			}
+			if txn.Succeeded {
+				s.logWrite(key, nil, txn.Header.Revision)
+				return nil
+			}
+			// saved meanwhile, start over
+			s.revoke(s.Context, lease.ID)
+		}
+	})
+}
+
+// Undelete restores the session id deleted under SoftDeleteGrace, before
+// the grace period ends, with the values and lifetime it had left when it
+// was deleted and back in the user index. Its auxiliary data is gone.
+// Undelete returns an error wrapping ErrNotFound once the grace period is
+// over, and ErrIDCollision if a session was stored under id meanwhile.
+//
+// The session isn't checked against MaxSessionsPerUser or MaxSessions.
+func (s *EtcdStore) Undelete(ctx context.Context, id string) error {
+	if err := s.checkPlainID(id); err != nil {
+		return err
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	key, tombKey := s.key(id), s.tombstoneKey(id)
+	for {
+		resp, err := s.get(ctx, tombKey)
+		if err != nil {
+			return err
+		}
+		if resp.Count == 0 {
+			return fmt.Errorf("key: %s is %w", tombKey, ErrNotFound)
+		}
+
+		var stone tombstone
+		if err := gob.NewDecoder(bytes.NewReader(resp.Kvs[0].Value)).Decode(&stone); err != nil {
+			return fmt.Errorf("%w: tombstone %s: %v", ErrCorruptValue, tombKey, err)
+		}
+		lease, err := s.grant(ctx, stone.TTL)
+		if err != nil {
+			return err
+		}
+		ops := []clientv3.Op{
+			clientv3.OpPut(key, string(stone.Value), clientv3.WithLease(lease.ID)),
+			clientv3.OpDelete(tombKey),
+		}
+		if stone.UserID != "" {
+			ops = append(ops, clientv3.OpPut(s.userIndexKey(stone.UserID, id), string(stone.IndexValue), clientv3.WithLease(lease.ID)))
+		}
+		for field, value := range stone.Fields {
+			ops = append(ops, clientv3.OpPut(s.fieldPrefix(id)+field, string(value), clientv3.WithLease(lease.ID)))
+		}
+
+		txn, err := s.commit(s.kv.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(tombKey), "=", resp.Kvs[0].ModRevision),
+				clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(ops...).
+			Else(clientv3.OpGet(key, clientv3.WithCountOnly())))
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			rev := txn.Header.Revision
+			s.logWrite(key, &mvccpb.KeyValue{Key: []byte(key), Value: stone.Value, ModRevision: rev, Lease: int64(lease.ID)}, rev)
+			return nil
+		}
+		s.revoke(s.Context, lease.ID)
+		if txn.Responses[0].GetResponseRange().Count > 0 {
+			return fmt.Errorf("%w: key %s", ErrIDCollision, key)
+		}
+		// expired or deleted again meanwhile, start over
+	}
+}
@@ -0,0 +1,106 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdStore_SoftDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.SoftDeleteGrace = time.Minute
+	s.UserIDKey = "user"
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	assert.Nil(t, s.PutAux(ctx, session, "csrf", []byte("token")))
+	before, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	ttl, err := store.Client.TimeToLive(ctx, clientv3.LeaseID(before.Kvs[0].Lease))
+	assert.Nil(t, err)
+
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "absent")
+	found, err := s.Exists(ctx, session.ID)
+	assert.Nil(t, err)
+	assert.False(t, found)
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+
+	tomb, err := store.Client.Get(ctx, s.tombstoneKey(session.ID))
+	assert.Nil(t, err)
+	grace, err := store.Client.TimeToLive(ctx, clientv3.LeaseID(tomb.Kvs[0].Lease))
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, grace.TTL, int64(60), "leased for the grace period")
+
+	// undelete within the grace period
+	assert.Nil(t, s.Undelete(ctx, session.ID))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", loaded.Values["user"])
+	ids, err = s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids, "indexed again")
+	after, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	remaining, err := store.Client.TimeToLive(ctx, clientv3.LeaseID(after.Kvs[0].Lease))
+	assert.Nil(t, err)
+	assert.InDelta(t, ttl.TTL, remaining.TTL, 2, "lifetime kept")
+	_, err = s.GetAux(ctx, loaded, "csrf")
+	assert.True(t, errors.Is(err, ErrNotFound), "aux data gone")
+
+	assert.True(t, errors.Is(s.Undelete(ctx, session.ID), ErrNotFound), "tombstone consumed")
+
+	// a session stored under the ID meanwhile isn't overwritten
+	loaded.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), loaded))
+	_, err = store.Client.Put(ctx, s.key(session.ID), "other")
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(s.Undelete(ctx, session.ID), ErrIDCollision))
+}
+
+func TestEtcdStore_SoftDeleteExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStore("/sessions", []byte("secret"))
+	defer s.Close()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.SoftDeleteGrace = time.Minute
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+
+	now = now.Add(time.Minute)
+	assert.True(t, errors.Is(s.Undelete(ctx, session.ID), ErrNotFound), "grace period over")
+	resp, err := s.kv.Get(ctx, s.key(""), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count, "nothing left")
+}
+
+func TestEtcdStore_SoftDeleteKeyDelimiter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	assert.Nil(t, s.SetKeyDelimiter(":"))
+	s.SoftDeleteGrace = time.Minute
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	tomb, err := store.Client.Get(ctx, s.keyPrefix+":"+tombstoneSegment+":"+session.ID, clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), tomb.Count, "under the delimiter")
+
+	assert.Nil(t, s.Undelete(ctx, session.ID))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+}
@@ -0,0 +1,46 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrSaveUncertain is returned by Save when the etcd write timed out. etcd
+// doesn't abort a write because its client gave up, so the session may or
+// may not have been stored; callers that care can read it back to find out.
+// The underlying error is wrapped as well.
+var ErrSaveUncertain = errors.New("etcdstore: save outcome unknown")
+
+// uncertainError wraps the timeout behind an ErrSaveUncertain.
+type uncertainError struct {
+	err error
+}
+
+func (e uncertainError) Error() string {
+	return ErrSaveUncertain.Error() + ": " + e.err.Error()
+}
+
+func (e uncertainError) Is(target error) bool {
+	return target == ErrSaveUncertain
+}
+
+func (e uncertainError) Unwrap() error {
+	return e.err
+}
+
+// isTimeout reports whether err means a request timed out without telling
+// whether etcd applied it.
+func isTimeout(err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, rpctypes.ErrTimeout),
+		errors.Is(err, rpctypes.ErrTimeoutDueToLeaderFail),
+		errors.Is(err, rpctypes.ErrTimeoutDueToConnectionLost):
+		return true
+	}
+	return status.Code(err) == codes.DeadlineExceeded
+}
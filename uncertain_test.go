@@ -0,0 +1,36 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_SaveUncertain(t *testing.T) {
+	s := newTestStore(t)
+	s.kv = lateKV{s.kv}
+
+	session := sessions.NewSession(s, "_session")
+	session.ID = "late"
+	session.Options = &sessions.Options{MaxAge: 60}
+	session.Values["foo"] = "bar"
+
+	err := s.save(context.Background(), session, false)
+	assert.True(t, errors.Is(err, ErrSaveUncertain))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "the cause is kept")
+
+	// the write landed anyway
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	// other failures are reported as they are
+	s.kv = &faultyKV{KV: s.kv, err: os.ErrPermission}
+	err = s.save(context.Background(), session, false)
+	assert.False(t, errors.Is(err, ErrSaveUncertain))
+	assert.True(t, errors.Is(err, os.ErrPermission))
+}
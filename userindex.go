@@ -0,0 +1,255 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// userIndexSegment starts the user index keys, one per session of a user,
+// named {prefix}/_users/{escaped user ID}/{session ID}, with the store's key
+// delimiter in place of the slashes. An entry holds the session's creation
+// time and shares the session's lease.
+const userIndexSegment = reservedPrefix + "users"
+
+// ErrInvalidUserID is returned by Save when the value under UserIDKey isn't
+// a string.
+var ErrInvalidUserID = errors.New("etcdstore: user id must be a string")
+
+// indexEntry is a user index entry as read from etcd.
+type indexEntry struct {
+	sessionID string
+	created   int64
+	kv        *mvccpb.KeyValue
+}
+
+// userIndexPrefix returns the prefix of the index entries of userID.
+func (s *EtcdStore) userIndexPrefix(userID string) string {
+	return s.key(userIndexSegment + s.keyDelimiter + s.escapeUserID(userID) + s.keyDelimiter)
+}
+
+// escapeUserID escapes userID for a segment of the user index keys, so that
+// it holds no key delimiter and no user's prefix covers another's.
+func (s *EtcdStore) escapeUserID(userID string) string {
+	escaped := url.PathEscape(userID)
+	if !strings.Contains(escaped, s.keyDelimiter) {
+		return escaped
+	}
+	var delim strings.Builder
+	for _, b := range []byte(s.keyDelimiter) {
+		fmt.Fprintf(&delim, "%%%02X", b)
+	}
+	return strings.ReplaceAll(escaped, s.keyDelimiter, delim.String())
+}
+
+// userIndexKey returns the index entry of session sessionID for userID.
+func (s *EtcdStore) userIndexKey(userID, sessionID string) string {
+	return s.userIndexPrefix(userID) + sessionID
+}
+
+// userOf returns the UserIDKey value, "" if it is unset or absent.
+func (s *EtcdStore) userOf(values map[interface{}]interface{}) (string, error) {
+	if s.UserIDKey == "" {
+		return "", nil
+	}
+
+	v, ok := values[s.UserIDKey]
+	if !ok {
+		return "", nil
+	}
+	userID, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %T under %q", ErrInvalidUserID, v, s.UserIDKey)
+	}
+	return userID, nil
+}
+
+// userEntries returns the index entries of userID, oldest first, and the
+// revision they were read at.
+func (s *EtcdStore) userEntries(ctx context.Context, userID string, opts ...clientv3.OpOption) ([]indexEntry, int64, error) {
+	prefix := s.userIndexPrefix(userID)
+	resp, err := s.get(ctx, prefix, append([]clientv3.OpOption{clientv3.WithPrefix()}, opts...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]indexEntry, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		created, _ := strconv.ParseInt(string(kv.Value), 10, 64)
+		entries = append(entries, indexEntry{
+			sessionID: strings.TrimPrefix(string(kv.Key), prefix),
+			created:   created,
+			kv:        kv,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].created < entries[j].created
+	})
+	return entries, resp.Header.Revision, nil
+}
+
+// UserSessions returns the IDs of the sessions of userID, oldest first. It
+// requires UserIDKey to be set.
+func (s *EtcdStore) UserSessions(ctx context.Context, userID string) ([]string, error) {
+	entries, _, err := s.userEntries(ctx, userID, s.readOpts(ReadUserSessions)...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.sessionID)
+	}
+	return ids, nil
+}
+
+// DeleteOtherUserSessions deletes every session of userID but
+// keepSessionID, along with their auxiliary data, e.g. for "sign out of all
+// other devices", and returns how many it deleted. It requires UserIDKey to
+// be set.
+//
+// The kept session is never written to, so saving it concurrently is safe.
+// A session is deleted only if it is still indexed under userID, and one
+// created meanwhile may survive. Each deletion is published as an
+// EventDeleted with an empty Name. Like Consume, it ignores SoftDeleteGrace.
+// Bucketed sessions aren't indexed, so none are deleted.
+func (s *EtcdStore) DeleteOtherUserSessions(ctx context.Context, userID, keepSessionID string) (int64, error) {
+	if s.UserIDKey == "" {
+		return 0, errors.New("etcdstore: DeleteOtherUserSessions requires UserIDKey")
+	}
+	done, err := s.inflight.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	entries, _, err := s.userEntries(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, entry := range entries {
+		if entry.sessionID == keepSessionID {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		key := s.key(entry.sessionID)
+		s.uncache(key)
+		var resp *clientv3.TxnResponse
+		err := s.retry(ctx, func() (err error) {
+			// the entry goes if the session is reassigned meanwhile
+			resp, err = s.commit(s.kv.Txn(ctx).
+				If(clientv3.Compare(clientv3.CreateRevision(string(entry.kv.Key)), "=", entry.kv.CreateRevision)).
+				Then(
+					clientv3.OpDelete(string(entry.kv.Key)),
+					clientv3.OpDelete(key),
+					clientv3.OpDelete(s.auxPrefix(entry.sessionID), clientv3.WithPrefix())))
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if !resp.Succeeded || resp.Responses[1].GetResponseDeleteRange().Deleted == 0 {
+			continue
+		}
+		s.logWrite(key, nil, resp.Header.Revision)
+		s.publish(EventDeleted, "", entry.sessionID)
+		deleted++
+	}
+	return deleted, nil
+}
+
+// indexTxn returns the compares and ops that keep the user index in line
+// when session is written with lease, along with the IDs of the sessions
+// of the user evicted under MaxSessionsPerUser. prevUser is the user the
+// session was indexed under before.
+func (s *EtcdStore) indexTxn(ctx context.Context, session *sessions.Session, prevUser, userID string, lease clientv3.LeaseID) ([]clientv3.Cmp, []clientv3.Op, []string, error) {
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	if prevUser != "" && prevUser != userID {
+		ops = append(ops, clientv3.OpDelete(s.userIndexKey(prevUser, session.ID)))
+	}
+	if userID == "" {
+		return cmps, ops, nil, nil
+	}
+
+	entries, rev, err := s.userEntries(ctx, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key := s.userIndexKey(userID, session.ID)
+	for _, entry := range entries {
+		if entry.sessionID == session.ID {
+			// already indexed: only move it to the new lease
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", entry.kv.ModRevision))
+			ops = append(ops, clientv3.OpPut(key, "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease)))
+			return cmps, ops, nil, nil
+		}
+	}
+
+	cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	ops = append(ops, clientv3.OpPut(key, strconv.FormatInt(s.clock().UnixNano(), 10), clientv3.WithLease(lease)))
+
+	var evicted []string
+	if s.MaxSessionsPerUser > 0 && len(entries) >= s.MaxSessionsPerUser {
+		// nothing under the prefix may change before the txn, or the count
+		// is off
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(s.userIndexPrefix(userID)).WithPrefix(), "<", rev+1))
+		for _, entry := range entries[:len(entries)-s.MaxSessionsPerUser+1] {
+			ops = append(ops,
+				clientv3.OpDelete(string(entry.kv.Key)),
+				clientv3.OpDelete(s.key(entry.sessionID)),
+				clientv3.OpDelete(s.auxPrefix(entry.sessionID), clientv3.WithPrefix()))
+			evicted = append(evicted, entry.sessionID)
+		}
+	}
+	return cmps, ops, evicted, nil
+}
+
+// moveIndexOps returns the ops moving the user index entries along with a
+// session moved from oldID to newID by MoveSession. src, dst and moved are
+// the encoded values of the old session, of the session previously at
+// newID if any, and the one stored at newID.
+func (s *EtcdStore) moveIndexOps(name, oldID, newID, src, dst, moved string, lease clientv3.LeaseID) []clientv3.Op {
+	var ops []clientv3.Op
+	movedUser := s.indexedUser(name, moved)
+	if user := s.indexedUser(name, src); user != "" {
+		ops = append(ops, clientv3.OpDelete(s.userIndexKey(user, oldID)))
+	}
+	// a txn can't touch a key twice, the put below replaces the entry
+	if user := s.indexedUser(name, dst); user != "" && user != movedUser {
+		ops = append(ops, clientv3.OpDelete(s.userIndexKey(user, newID)))
+	}
+	if user := movedUser; user != "" {
+		ops = append(ops, clientv3.OpPut(s.userIndexKey(user, newID), strconv.FormatInt(s.clock().UnixNano(), 10), clientv3.WithLease(lease)))
+	}
+	return ops
+}
+
+// indexedUser returns the user the encoded value is indexed under, "" if
+// none or if it can't be decoded.
+func (s *EtcdStore) indexedUser(name, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	values := make(map[interface{}]interface{})
+	if err := s.decode(name, "", []byte(value), &values); err != nil {
+		return ""
+	}
+	userID, _ := values[indexedUserKey].(string)
+	return userID
+}
@@ -0,0 +1,189 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// tickingClock returns a clock that advances by a second on every reading.
+func tickingClock() func() time.Time {
+	now := time.Now()
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func TestEtcdStore_MaxSessionsPerUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.now = tickingClock()
+	s.UserIDKey = "user"
+	s.MaxSessionsPerUser = 2
+	var evicted []string
+	s.OnEvict = func(userID string, ids []string) {
+		assert.Equal(t, "alice", userID)
+		evicted = append(evicted, ids...)
+	}
+
+	first := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	second := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	bob := newSavedSession(t, s, map[interface{}]interface{}{"user": "bob"})
+	newSavedSession(t, s, nil)
+
+	// saving again keeps the session's place
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), first))
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{first.ID, second.ID}, ids)
+	assert.Len(t, evicted, 0)
+
+	third := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	assert.Equal(t, []string{first.ID}, evicted, "oldest evicted")
+	ids, err = s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{second.ID, third.ID}, ids)
+
+	_, err = loadByID(s, first.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "evicted session deleted")
+	_, err = loadByID(s, bob.ID)
+	assert.Nil(t, err, "other users untouched")
+
+	matched, err := s.FilterSessions(ctx, "_session", func(*sessions.Session) bool { return true })
+	assert.Nil(t, err)
+	assert.Len(t, matched, 4, "index entries aren't sessions")
+}
+
+func TestEtcdStore_UserIndex(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"user": "a/lice"})
+	ids, err := s.UserSessions(ctx, "a/lice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids)
+
+	// changing user moves the entry
+	session.Values["user"] = "bob"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	ids, err = s.UserSessions(ctx, "a/lice")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+	ids, err = s.UserSessions(ctx, "bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{session.ID}, ids)
+
+	// and so does moving the session
+	assert.Nil(t, s.MoveSession(ctx, "_session", session.ID, "MOVED"))
+	ids, err = s.UserSessions(ctx, "bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"MOVED"}, ids)
+
+	// deleting it removes the entry
+	moved, err := loadByID(s, "MOVED")
+	assert.Nil(t, err)
+	moved.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), moved))
+	ids, err = s.UserSessions(ctx, "bob")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	assert.Nil(t, err, "http new request")
+	invalid, err := s.New(req, "_session")
+	assert.Nil(t, err)
+	invalid.Values["user"] = 42
+	err = s.Save(req, httptest.NewRecorder(), invalid)
+	assert.True(t, errors.Is(err, ErrInvalidUserID))
+}
+
+func TestEtcdStore_DeleteOtherUserSessions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.UserIDKey = "user"
+
+	var others []*sessions.Session
+	for i := 0; i < 4; i++ {
+		others = append(others, newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"}))
+	}
+	assert.Nil(t, s.PutAux(ctx, others[1], "csrf", []byte("token")))
+	kept := others[0]
+	others = others[1:]
+	bob := newSavedSession(t, s, map[interface{}]interface{}{"user": "bob"})
+
+	// the kept session is saved all along
+	stop := make(chan struct{})
+	saved := make(chan error)
+	go func() {
+		var err error
+		for {
+			select {
+			case <-stop:
+				saved <- err
+				return
+			default:
+			}
+			kept.Values["n"] = time.Now().UnixNano()
+			if err == nil {
+				err = s.Save(nil, httptest.NewRecorder(), kept)
+			}
+		}
+	}()
+	deleted, err := s.DeleteOtherUserSessions(ctx, "alice", kept.ID)
+	close(stop)
+	assert.Nil(t, <-saved)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(others)), deleted)
+
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{kept.ID}, ids)
+	_, err = loadByID(s, kept.ID)
+	assert.Nil(t, err)
+	for _, session := range others {
+		_, err := loadByID(s, session.ID)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+	_, err = s.GetAux(ctx, others[0], "csrf")
+	assert.True(t, errors.Is(err, ErrNotFound), "auxiliary data goes along")
+	_, err = loadByID(s, bob.ID)
+	assert.Nil(t, err, "other users are left alone")
+
+	deleted, err = s.DeleteOtherUserSessions(ctx, "alice", kept.ID)
+	assert.Nil(t, err)
+	assert.Zero(t, deleted)
+
+	s.UserIDKey = ""
+	_, err = s.DeleteOtherUserSessions(ctx, "alice", kept.ID)
+	assert.NotNil(t, err)
+}
+
+func TestEtcdStore_UserIndexKeyDelimiter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	assert.Nil(t, s.SetKeyDelimiter(":"))
+	s.UserIDKey = "user"
+
+	alice := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	other := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice:admin"})
+	resp, err := s.Client.Get(ctx, s.keyPrefix+":"+userIndexSegment+":alice:", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count, "under the delimiter")
+	assert.Equal(t, s.userIndexKey("alice", alice.ID), string(resp.Kvs[0].Key))
+
+	ids, err := s.UserSessions(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{alice.ID}, ids, "another user's sessions left out")
+	ids, err = s.UserSessions(ctx, "alice:admin")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{other.ID}, ids)
+}
@@ -0,0 +1,339 @@
+package etcdstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// checksumSep separates a stored value from its CRC32. It can't occur in
+// securecookie output, which is URL-safe base64.
+const checksumSep = "."
+
+// padSep starts the padding of a stored value, which is made of it alone.
+// Like checksumSep, it can't occur in securecookie output.
+const padSep = "~"
+
+// ErrChecksumMismatch is returned when a stored value doesn't match the
+// CRC32 saved alongside it.
+var ErrChecksumMismatch = errors.New("etcdstore: session value checksum mismatch")
+
+// ErrIDMismatch is returned under EmbedID when a stored value embeds the ID
+// of another session than the key it is read from.
+var ErrIDMismatch = errors.New("etcdstore: session value belongs to another ID")
+
+// ErrCorruptValue is returned for a stored value the codecs accept but
+// which can't be unpacked, e.g. because its compressed data is damaged.
+var ErrCorruptValue = errors.New("etcdstore: corrupt session value")
+
+// ReservedValuesPrefix starts the session.Values keys the store keeps its
+// own data under: "_etcdstore.last_access", "_etcdstore.created",
+// "_etcdstore.user", "_etcdstore.aux", "_etcdstore.lease",
+// "_etcdstore.labels", "_etcdstore.ttl", "_etcdstore.stale" and
+// "_etcdstore.fields". Applications must not set keys starting with it.
+// Save rejects other such keys, and reserved ones holding a type the store
+// doesn't write, with ErrReservedKey.
+const ReservedValuesPrefix = "_etcdstore."
+
+const (
+	// lastAccessKey holds the time, in Unix nanoseconds, a session was last
+	// loaded or saved, under IdleTimeout.
+	lastAccessKey = ReservedValuesPrefix + "last_access"
+
+	// createdKey holds the time, in Unix nanoseconds, a session was first
+	// saved, under TrackCreation.
+	createdKey = ReservedValuesPrefix + "created"
+
+	// indexedUserKey holds the user the session is indexed under, so that
+	// the entry can be moved when UserIDKey changes.
+	indexedUserKey = ReservedValuesPrefix + "user"
+
+	// auxKey holds the suffixes of the session's auxiliary data, so that
+	// Save can move them to the session's new lease.
+	auxKey = ReservedValuesPrefix + "aux"
+
+	// leaseKey holds the ID of the session's lease, as set by load and save
+	// for LeaseID. It is never stored.
+	leaseKey = ReservedValuesPrefix + "lease"
+
+	// labelsKey holds the labels set by SetLabel.
+	labelsKey = ReservedValuesPrefix + "labels"
+
+	// ttlKey holds the lifetime of the session's lease in seconds, under
+	// MaxTTL.
+	ttlKey = ReservedValuesPrefix + "ttl"
+
+	// fieldsKey holds, under FieldKeys, a digest of each value stored in a
+	// field key as last loaded or saved, for Save to tell the changed ones.
+	// It is never stored.
+	fieldsKey = ReservedValuesPrefix + "fields"
+
+	// idKey holds the session ID in stored values under EmbedID. It is
+	// never kept in session.Values.
+	idKey = ReservedValuesPrefix + "id"
+
+	// staleKey marks a session load served from the cache under
+	// StaleWhileUnavailable. Like leaseKey, it is never stored.
+	staleKey = ReservedValuesPrefix + "stale"
+)
+
+// ErrReservedKey is returned by Save for session values under
+// ReservedValuesPrefix the store doesn't write itself.
+var ErrReservedKey = errors.New("etcdstore: reserved session values key")
+
+// checkReservedValues rejects values that would be mistaken for the store's
+// own data, or break it.
+func checkReservedValues(values map[interface{}]interface{}) error {
+	for k, v := range values {
+		name, ok := k.(string)
+		if !ok || !strings.HasPrefix(name, ReservedValuesPrefix) {
+			continue
+		}
+
+		switch name {
+		case lastAccessKey, createdKey, leaseKey, ttlKey:
+			_, ok = v.(int64)
+		case indexedUserKey:
+			_, ok = v.(string)
+		case auxKey:
+			_, ok = v.([]string)
+		case labelsKey, fieldsKey:
+			_, ok = v.(map[string]string)
+		case staleKey:
+			_, ok = v.(bool)
+		default:
+			ok = false
+		}
+		if !ok {
+			return fmt.Errorf("%w: %q holding %T", ErrReservedKey, name, v)
+		}
+	}
+	return nil
+}
+
+// ErrInvalidPipeline is returned by Save when the settings of the stages a
+// value goes through contradict each other, see the package documentation.
+var ErrInvalidPipeline = errors.New("etcdstore: invalid value pipeline")
+
+// checkPipeline rejects stage settings that would silently not apply.
+func (s *EtcdStore) checkPipeline() error {
+	if s.RedistoreFormat != nil {
+		return nil
+	}
+	if len(s.CompressKeys) > 0 && s.Compression == NoCompression {
+		return fmt.Errorf("%w: CompressKeys without Compression", ErrInvalidPipeline)
+	}
+	if s.Compression != NoCompression {
+		if _, err := compressor(s.Compression); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPipeline, err)
+		}
+	}
+	if s.CompressThreshold < 0 {
+		return fmt.Errorf("%w: negative CompressThreshold %d", ErrInvalidPipeline, s.CompressThreshold)
+	}
+	return nil
+}
+
+// encode turns the values of session id into the string stored in etcd,
+// through the stages of the package documentation: pack, the codecs, the
+// checksum and the padding.
+func (s *EtcdStore) encode(name, id string, values map[interface{}]interface{}) (string, error) {
+	if err := s.checkPipeline(); err != nil {
+		return "", err
+	}
+	_, stale := values[staleKey]
+	_, fielded := values[fieldsKey]
+	if _, ok := values[leaseKey]; ok || stale || fielded || s.EmbedID || s.fieldKeys() {
+		stored := make(map[interface{}]interface{}, len(values)+1)
+		for k, v := range values {
+			if s.fieldKeys() && isField(k) {
+				continue
+			}
+			stored[k] = v
+		}
+		delete(stored, leaseKey)
+		delete(stored, staleKey)
+		delete(stored, fieldsKey)
+		if s.EmbedID {
+			stored[idKey] = id
+		}
+		values = stored
+	}
+	if s.RedistoreFormat != nil {
+		return s.encodeRedistore(name, values)
+	}
+	return s.seal(name, values)
+}
+
+// seal runs values through the stages of encode from the serialization on.
+func (s *EtcdStore) seal(name string, values map[interface{}]interface{}) (string, error) {
+	payload, err := s.pack(values)
+	if err != nil {
+		return "", s.unencodable(values, err)
+	}
+
+	s.mu.RLock()
+	encoded, err := securecookie.EncodeMulti(name, payload, s.Codecs...)
+	s.mu.RUnlock()
+	if err != nil {
+		return "", s.unencodable(values, err)
+	}
+
+	if s.Checksum {
+		encoded = fmt.Sprintf("%s%s%08x", encoded, checksumSep, crc32.ChecksumIEEE([]byte(encoded)))
+	}
+	if s.Padding != nil {
+		if size := s.Padding(len(encoded) + len(padSep)); size > len(encoded) {
+			encoded += strings.Repeat(padSep, size-len(encoded))
+		}
+	}
+	return encoded, nil
+}
+
+// valueSerializer returns the serializer the codecs use for values.
+func (s *EtcdStore) valueSerializer() securecookie.Serializer {
+	if s.serializer != nil {
+		return s.serializer
+	}
+	return securecookie.GobEncoder{}
+}
+
+// ErrUnencodableValue is returned by Save when the serializer can't encode
+// a session value, e.g. a func, a channel, or for gob a struct without
+// exported fields or a type it wasn't told about with gob.Register.
+var ErrUnencodableValue = errors.New("etcdstore: unencodable session value")
+
+// unencodable returns err, the failure to encode values, naming the first
+// key whose value the serializer can't encode on its own, if any.
+func (s *EtcdStore) unencodable(values map[interface{}]interface{}, err error) error {
+	serializer := s.valueSerializer()
+
+	keys := make([]string, 0, len(values))
+	byName := make(map[string]interface{}, len(values))
+	for k := range values {
+		name := fmt.Sprint(k)
+		keys = append(keys, name)
+		byName[name] = k
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		k := byName[name]
+		if _, probeErr := serializer.Serialize(map[interface{}]interface{}{k: values[k]}); probeErr != nil {
+			return fmt.Errorf("%w: key %q holds %T: %v", ErrUnencodableValue, name, values[k], probeErr)
+		}
+	}
+	return err
+}
+
+// Padding returns the size a stored value of size bytes, including the
+// separator the padding starts with, is padded to.
+type Padding func(size int) int
+
+// PadToMultiple pads stored values to a multiple of quantum bytes, which
+// costs up to quantum bytes per value. It panics if quantum isn't positive.
+func PadToMultiple(quantum int) Padding {
+	if quantum <= 0 {
+		panic(fmt.Sprintf("etcdstore: PadToMultiple with non-positive quantum %d", quantum))
+	}
+	return func(size int) int {
+		return (size + quantum - 1) / quantum * quantum
+	}
+}
+
+// PadToPowerOfTwo pads stored values to the next power of two, which hides
+// more of their size than a fixed quantum, at the cost of up to doubling
+// it.
+func PadToPowerOfTwo(size int) int {
+	padded := 1
+	for padded < size {
+		padded *= 2
+	}
+	return padded
+}
+
+// decode is the inverse of encode, undoing its stages in reverse whatever
+// the current settings, which aren't checked. Under EmbedID, it checks that
+// the ID embedded in the value, if any, is id, unless id is "".
+func (s *EtcdStore) decode(name, id string, data []byte, values *map[interface{}]interface{}) error {
+	var err error
+	if s.RedistoreFormat != nil {
+		err = s.decodeRedistore(name, data, values)
+	} else {
+		err = s.decodeDefault(name, data, values)
+	}
+	if err != nil {
+		return err
+	}
+
+	embedded, ok := (*values)[idKey].(string)
+	delete(*values, idKey)
+	if s.EmbedID && ok && id != "" && embedded != id {
+		return fmt.Errorf("%w: key %s holds %s", ErrIDMismatch, s.key(id), embedded)
+	}
+	return nil
+}
+
+// decodeDefault decodes the store's own format. A value carrying a checksum
+// is verified whether or not Checksum is currently set, and padding is
+// stripped whether or not Padding is.
+//
+// It takes the stored bytes as etcd returned them, and verifies the
+// checksum in place. The one copy it makes of the whole value is the
+// string securecookie's API requires; the codecs then allocate the base64
+// decoded, decrypted and deserialized data in turn, so decoding can't be
+// zero-copy short of a codec of one's own. A compressed value also goes
+// through the codecs twice, being tried as plain values first.
+func (s *EtcdStore) decodeDefault(name string, data []byte, values *map[interface{}]interface{}) error {
+	if i := bytes.Index(data, []byte(padSep)); i >= 0 {
+		data = data[:i]
+	}
+	if i := bytes.LastIndex(data, []byte(checksumSep)); i >= 0 {
+		var sum [4]byte
+		hexSum := data[i+len(checksumSep):]
+		if len(hexSum) != hex.EncodedLen(len(sum)) {
+			return ErrChecksumMismatch
+		}
+		if _, err := hex.Decode(sum[:], hexSum); err != nil || binary.BigEndian.Uint32(sum[:]) != crc32.ChecksumIEEE(data[:i]) {
+			return ErrChecksumMismatch
+		}
+		data = data[:i]
+	}
+	value := string(data)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := securecookie.DecodeMulti(name, value, values, s.Codecs...)
+	if err != nil {
+		// maybe a compressed payload, see pack
+		var payload []byte
+		if securecookie.DecodeMulti(name, value, &payload, s.Codecs...) == nil {
+			return s.unpack(payload, values)
+		}
+		return err
+	}
+	return s.unpackFields(*values)
+}
+
+// encodeID encodes a session ID for the cookie.
+func (s *EtcdStore) encodeID(name, id string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return securecookie.EncodeMulti(name, id, s.Codecs...)
+}
+
+// decodeID is the inverse of encodeID.
+func (s *EtcdStore) decodeID(name, value string, id *string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return securecookie.DecodeMulti(name, value, id, s.Codecs...)
+}
@@ -0,0 +1,286 @@
+package etcdstore
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_Checksum(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	// values saved before Checksum was set still load
+	legacy := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	s.Checksum = true
+	_, err := loadByID(s, legacy.ID)
+	assert.Nil(t, err)
+
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	key := s.key(session.ID)
+	resp, err := s.Client.Get(ctx, key)
+	assert.Nil(t, err)
+	stored := string(resp.Kvs[0].Value)
+	assert.Contains(t, stored, checksumSep)
+
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+
+	// flip a byte of the payload
+	i := strings.Index(stored, checksumSep) / 2
+	flipped := stored[:i] + string(stored[i]^1) + stored[i+1:]
+	_, err = s.Client.Put(ctx, key, flipped)
+	assert.Nil(t, err)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// a damaged checksum is caught too
+	_, err = s.Client.Put(ctx, key, stored[:len(stored)-1])
+	assert.Nil(t, err)
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func BenchmarkEtcdStore_LoadLarge(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10, 256 << 10} {
+		for _, checksum := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%dKiB/checksum=%t", size>>10, checksum), func(b *testing.B) {
+				s := newTestStore(b)
+				for _, codec := range s.Codecs {
+					codec.(*securecookie.SecureCookie).MaxLength(0)
+				}
+				s.Checksum = checksum
+
+				session := sessions.NewSession(s, "_session")
+				session.ID = "large"
+				session.Values["blob"] = strings.Repeat("x", size)
+				assert.Nil(b, s.save(context.Background(), session, false))
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := loadByID(s, session.ID); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEtcdStore_ReservedValues(t *testing.T) {
+	s := newTestStore(t)
+	s.IdleTimeout = time.Hour
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+
+	// the store's own keys round-trip
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, s.save(context.Background(), loaded, false))
+
+	for key, value := range map[string]interface{}{
+		lastAccessKey:                "yesterday",
+		auxKey:                       "csrf",
+		ReservedValuesPrefix + "foo": 1,
+	} {
+		loaded.Values[key] = value
+		err := s.save(context.Background(), loaded, false)
+		assert.ErrorIs(t, err, ErrReservedKey, key)
+		delete(loaded.Values, key)
+	}
+
+	// rejected before anything is written
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"])
+}
+
+func TestEtcdStore_Padding(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	storedSize := func(id string) int {
+		resp, err := s.Client.Get(ctx, s.key(id))
+		assert.Nil(t, err)
+		return len(resp.Kvs[0].Value)
+	}
+
+	for _, padding := range []struct {
+		name  string
+		pad   Padding
+		check func(size int) bool
+	}{
+		{"multiple", PadToMultiple(512), func(size int) bool { return size%512 == 0 }},
+		{"power of two", PadToPowerOfTwo, func(size int) bool { return size&(size-1) == 0 }},
+	} {
+		for _, checksum := range []bool{false, true} {
+			s.Padding, s.Checksum = padding.pad, checksum
+			sizes := map[int]bool{}
+			for _, n := range []int{0, 1, 100, 500, 2000} {
+				session := newSavedSession(t, s, map[interface{}]interface{}{"data": strings.Repeat("x", n)})
+				size := storedSize(session.ID)
+				assert.True(t, padding.check(size), "%s: %d bytes", padding.name, size)
+				sizes[size] = true
+
+				// padding is stripped whatever the current setting
+				s.Padding = nil
+				loaded, err := loadByID(s, session.ID)
+				assert.Nil(t, err, padding.name)
+				assert.Equal(t, strings.Repeat("x", n), loaded.Values["data"])
+				s.Padding = padding.pad
+			}
+			assert.Less(t, len(sizes), 5, "%s: sizes fall into fewer classes", padding.name)
+		}
+	}
+
+	assert.Equal(t, 1024, PadToMultiple(1024)(1))
+	assert.Equal(t, 1024, PadToMultiple(1024)(1024))
+	assert.Equal(t, 2048, PadToMultiple(1024)(1025))
+	assert.Panics(t, func() { PadToMultiple(0) })
+	assert.Panics(t, func() { PadToMultiple(-1) })
+	assert.Equal(t, 4096, PadToPowerOfTwo(2049))
+}
+
+func TestEtcdStore_EmbedID(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	// saved before EmbedID was set
+	legacy := newSavedSession(t, s, map[interface{}]interface{}{"user": "carol"})
+	s.EmbedID = true
+	_, err := loadByID(s, legacy.ID)
+	assert.Nil(t, err)
+
+	alice := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	loaded, err := loadByID(s, alice.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", loaded.Values["user"])
+	assert.NotContains(t, loaded.Values, idKey)
+
+	// a value embedding another ID
+	bob := newSavedSession(t, s, map[interface{}]interface{}{"user": "bob"})
+	altered, err := s.encode("_session", bob.ID, map[interface{}]interface{}{"user": "mallory"})
+	assert.Nil(t, err)
+	_, err = s.Client.Put(ctx, s.key(alice.ID), altered)
+	assert.Nil(t, err)
+	_, err = loadByID(s, alice.ID)
+	assert.True(t, errors.Is(err, ErrIDMismatch))
+
+	// moved values embed their new ID
+	newID := bob.ID + "moved"
+	assert.Nil(t, s.MoveSession(ctx, "_session", bob.ID, newID))
+	loaded, err = loadByID(s, newID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", loaded.Values["user"])
+}
+
+func TestEtcdStore_Pipeline(t *testing.T) {
+	s := newTestStore(t)
+	hashKey := []byte("secret")
+	blockKey := []byte("0123456789abcdef")
+	large := strings.Repeat("session data ", 150)
+
+	for _, compression := range []string{"none", "whole", "keys"} {
+		for _, encrypt := range []bool{false, true} {
+			for _, checksum := range []bool{false, true} {
+				for _, padding := range []bool{false, true} {
+					name := fmt.Sprintf("compression=%s,encrypt=%t,checksum=%t,padding=%t", compression, encrypt, checksum, padding)
+					t.Run(name, func(t *testing.T) {
+						s.Codecs = securecookie.CodecsFromPairs(hashKey)
+						if encrypt {
+							s.Codecs = securecookie.CodecsFromPairs(hashKey, blockKey)
+						}
+						s.Compression, s.CompressThreshold, s.CompressKeys = NoCompression, 1024, nil
+						if compression != "none" {
+							s.Compression = Gzip
+						}
+						if compression == "keys" {
+							s.CompressKeys = []string{"blob"}
+						}
+						s.Checksum = checksum
+						s.Padding = nil
+						if padding {
+							s.Padding = PadToMultiple(256)
+						}
+
+						session := newSavedSession(t, s, map[interface{}]interface{}{"blob": large, "user": "alice"})
+						resp, err := s.Client.Get(context.Background(), s.key(session.ID))
+						assert.Nil(t, err)
+						stored := string(resp.Kvs[0].Value)
+						assert.Equal(t, checksum, strings.Contains(stored, checksumSep))
+						assert.Equal(t, padding, strings.Contains(stored, padSep))
+						if padding {
+							assert.Zero(t, len(stored)%256)
+						}
+						if compression != "none" {
+							assert.Less(t, len(stored), len(large)/2)
+						}
+
+						// every stage is undone from the value alone
+						s.Compression, s.CompressKeys, s.Checksum, s.Padding = NoCompression, nil, false, nil
+						loaded, err := loadByID(s, session.ID)
+						assert.Nil(t, err)
+						assert.Equal(t, large, loaded.Values["blob"])
+						assert.Equal(t, "alice", loaded.Values["user"])
+
+						// and an encrypted value needs the block key
+						s.Codecs = securecookie.CodecsFromPairs(hashKey)
+						_, err = loadByID(s, session.ID)
+						assert.Equal(t, encrypt, err != nil)
+					})
+				}
+			}
+		}
+	}
+
+	s.Codecs = securecookie.CodecsFromPairs(hashKey)
+	session := newSavedSession(t, s, nil)
+	s.CompressKeys = []string{"blob"}
+	err := s.save(context.Background(), session, false)
+	assert.True(t, errors.Is(err, ErrInvalidPipeline), "CompressKeys without Compression")
+
+	s.CompressKeys = nil
+	s.Compression, s.CompressThreshold = Gzip, -1
+	err = s.save(context.Background(), session, false)
+	assert.True(t, errors.Is(err, ErrInvalidPipeline), "negative CompressThreshold")
+}
+
+type unexportedOnly struct {
+	secret string
+}
+
+func TestEtcdStore_UnencodableValue(t *testing.T) {
+	gob.Register(unexportedOnly{})
+	s := newTestStore(t)
+	session := newSavedSession(t, s, map[interface{}]interface{}{"ok": "fine"})
+
+	for key, value := range map[string]interface{}{
+		"callback": func() {},
+		"private":  unexportedOnly{secret: "x"},
+	} {
+		session.Values[key] = value
+		err := s.Save(nil, httptest.NewRecorder(), session)
+		assert.True(t, errors.Is(err, ErrUnencodableValue), key)
+		assert.Contains(t, err.Error(), fmt.Sprintf("key %q holds %T", key, value))
+		delete(session.Values, key)
+	}
+
+	// the key is named under compression too
+	s.Compression = Gzip
+	session.Values["callback"] = func() {}
+	err := s.Save(nil, httptest.NewRecorder(), session)
+	assert.True(t, errors.Is(err, ErrUnencodableValue))
+	assert.Contains(t, err.Error(), `key "callback"`)
+	delete(session.Values, "callback")
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+}
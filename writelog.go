@@ -0,0 +1,93 @@
+package etcdstore
+
+import (
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// writeLogSize bounds the entries of the write log. Past it, writes go
+// unlogged until entries expire.
+const writeLogSize = 4096
+
+// writeLog remembers, for WriteLogTTL, what this process last wrote to or
+// deleted from session keys, along with the revision of the write.
+type writeLog struct {
+	mu      sync.Mutex
+	entries map[string]writeLogEntry
+}
+
+type writeLogEntry struct {
+	// kv is the value written, nil for a delete.
+	kv       *mvccpb.KeyValue
+	revision int64
+	expires  time.Time
+}
+
+// record logs that key was set to kv, or deleted if kv is nil, at revision.
+func (l *writeLog) record(key string, kv *mvccpb.KeyValue, revision int64, expires, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.entries == nil {
+		l.entries = make(map[string]writeLogEntry)
+	}
+	if _, ok := l.entries[key]; !ok && len(l.entries) >= writeLogSize {
+		for k, entry := range l.entries {
+			if !now.Before(entry.expires) {
+				delete(l.entries, k)
+			}
+		}
+		if len(l.entries) >= writeLogSize {
+			return
+		}
+	}
+	l.entries[key] = writeLogEntry{kv: kv, revision: revision, expires: expires}
+}
+
+// newer returns what was logged for key if it is more recent than read,
+// the result of a read of key, nil if the key was missing.
+func (l *writeLog) newer(key string, read *mvccpb.KeyValue, now time.Time) (writeLogEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return writeLogEntry{}, false
+	}
+	if !now.Before(entry.expires) {
+		delete(l.entries, key)
+		return writeLogEntry{}, false
+	}
+	if read != nil && read.ModRevision >= entry.revision {
+		return writeLogEntry{}, false
+	}
+	if read == nil && entry.kv == nil {
+		return writeLogEntry{}, false
+	}
+	return entry, true
+}
+
+// logWrite records kv, written at revision, under WriteLogTTL, or a delete
+// of key if kv is nil.
+func (s *EtcdStore) logWrite(key string, kv *mvccpb.KeyValue, revision int64) {
+	if s.WriteLogTTL <= 0 || s.Buckets > 0 {
+		return
+	}
+	now := s.clock()
+	s.writeLog.record(key, kv, revision, now.Add(s.WriteLogTTL), now)
+}
+
+// readYourWrites returns what load should use of read, the result of a
+// read of key, nil if missing: the value this process logged for key if
+// the read missed it.
+func (s *EtcdStore) readYourWrites(key string, read *mvccpb.KeyValue) *mvccpb.KeyValue {
+	if s.WriteLogTTL <= 0 || s.Buckets > 0 {
+		return read
+	}
+	if entry, ok := s.writeLog.newer(key, read, s.clock()); ok {
+		return entry.kv
+	}
+	return read
+}
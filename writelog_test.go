@@ -0,0 +1,114 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdStore_WriteLog(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := store.Client.Get(context.Background(), s.key(session.ID))
+	assert.Nil(t, err)
+
+	// reads lag behind the first write from now on
+	s.kv = laggingKV{KV: s.kv, rev: resp.Header.Revision}
+	session.Values["foo"] = "baz"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"], "stale without the write log")
+
+	s.WriteLogTTL = time.Second
+	session.Values["foo"] = "qux"
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "qux", loaded.Values["foo"], "stale read upgraded")
+
+	now = now.Add(time.Second)
+	loaded, err = loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"], "log entry expired")
+
+	// deletes are logged too, and new sessions
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	fresh := newSavedSession(t, s, map[interface{}]interface{}{"foo": "new"})
+	loaded, err = loadByID(s, fresh.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", loaded.Values["foo"])
+}
+
+func TestEtcdStore_WriteLogSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.WriteLogTTL = time.Minute
+	s.SoftDeleteGrace = time.Minute
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	kv := s.kv
+
+	// reads lag behind the soft delete
+	s.kv = laggingKV{KV: kv, rev: resp.Header.Revision}
+	session.Options.MaxAge = -1
+	assert.Nil(t, s.Save(nil, httptest.NewRecorder(), session))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "logged out")
+
+	// and behind the undelete
+	s.kv = kv
+	deleted, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+	assert.Nil(t, s.Undelete(ctx, session.ID))
+	s.kv = laggingKV{KV: kv, rev: deleted.Header.Revision}
+	loaded, err := loadByID(s, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"], "back")
+}
+
+func TestEtcdStore_WriteLogMove(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.WriteLogTTL = time.Minute
+	session := newSavedSession(t, s, map[interface{}]interface{}{"foo": "bar"})
+	resp, err := store.Client.Get(ctx, s.key(session.ID))
+	assert.Nil(t, err)
+
+	// reads lag behind the move
+	s.kv = laggingKV{KV: s.kv, rev: resp.Header.Revision}
+	assert.Nil(t, s.MoveSession(ctx, "_session", session.ID, "moved-"+session.ID))
+	_, err = loadByID(s, session.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "old ID gone")
+	loaded, err := loadByID(s, "moved-"+session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", loaded.Values["foo"], "new ID there")
+}
+
+func TestEtcdStore_WriteLogEvict(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	s.now = tickingClock()
+	s.WriteLogTTL = time.Minute
+	s.UserIDKey = "user"
+	s.MaxSessionsPerUser = 1
+	first := newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	resp, err := store.Client.Get(ctx, s.key(first.ID))
+	assert.Nil(t, err)
+
+	// reads lag behind the eviction
+	s.kv = laggingKV{KV: s.kv, rev: resp.Header.Revision}
+	newSavedSession(t, s, map[interface{}]interface{}{"user": "alice"})
+	_, err = loadByID(s, first.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "evicted")
+}